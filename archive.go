@@ -0,0 +1,532 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// An archive starts with archiveMagic and the format version, followed by frames of a type byte,
+// a little-endian uint32 length and the data. The first frame is the header manifest, then the
+// possibly compressed send stream follows in data frames, and the final manifest with the byte counts
+// and checksum ends the archive.
+const (
+	archiveMagic         = "btrfs-archive\x00"
+	archiveVersion       = 1
+	archiveFrameHeader   = 'H'
+	archiveFrameData     = 'D'
+	archiveFrameManifest = 'M'
+	archiveFrameSize     = 1 << 20
+)
+
+// Compression methods of archives.
+const (
+	ArchiveCompressionNone = "none"
+	ArchiveCompressionGzip = "gzip"
+	ArchiveCompressionZstd = "zstd"
+)
+
+// ArchiveManifest describes the snapshot in an archive written by ExportSnapshot.
+type ArchiveManifest struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	UUID    UUID   `json:"uuid"`
+	// ParentUUID identifies the parent of an incremental stream as the receiving side knows it:
+	// the received UUID of the parent if it was received itself, otherwise its UUID.
+	// It is zero for full streams.
+	ParentUUID  UUID      `json:"parent_uuid"`
+	Generation  uint64    `json:"generation"`
+	Ctransid    uint64    `json:"ctransid"`
+	Otime       time.Time `json:"otime"`
+	Created     time.Time `json:"created"`
+	Compression string    `json:"compression"`
+	// StreamBytes is the size of the send stream, ArchiveBytes the size of the stored data after compression.
+	// Both and SHA256, the hex encoded checksum of the send stream, are only set in the final manifest.
+	StreamBytes  uint64 `json:"stream_bytes"`
+	ArchiveBytes uint64 `json:"archive_bytes"`
+	SHA256       string `json:"sha256"`
+}
+
+// ExportOptions configures ExportSnapshot.
+type ExportOptions struct {
+	SendOptions
+	// Compression is ArchiveCompressionGzip, the default, ArchiveCompressionZstd or ArchiveCompressionNone.
+	Compression string
+	// Level is the gzip or zstd compression level. Zero uses the default level of the method.
+	Level int
+}
+
+func validCompression(compression string) bool {
+	switch compression {
+	case ArchiveCompressionNone, ArchiveCompressionGzip, ArchiveCompressionZstd:
+		return true
+	}
+	return false
+}
+
+// newCompressor returns a writer which compresses to w with the given method and level.
+func newCompressor(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch compression {
+	case ArchiveCompressionNone:
+		return nopWriteCloser{w}, nil
+	case ArchiveCompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		zw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, ErrInvalidArgument
+		}
+		return zw, nil
+	case ArchiveCompressionZstd:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, ErrInvalidArgument
+		}
+		return zw, nil
+	}
+	return nil, ErrInvalidArgument
+}
+
+// digest counts and hashes the bytes written to it.
+type digest struct {
+	hash hash.Hash
+	n    uint64
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += uint64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digest) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+func writeFrame(w io.Writer, typ byte, data []byte) error {
+	header := make([]byte, 5)
+	header[0] = typ
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// frameWriter splits the data written to it into data frames.
+type frameWriter struct {
+	w   io.Writer
+	buf []byte
+	n   uint64
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := copy(f.buf[len(f.buf):cap(f.buf)], p)
+		f.buf, p = f.buf[:len(f.buf)+n], p[n:]
+		if len(f.buf) == cap(f.buf) {
+			if err := f.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (f *frameWriter) flush() error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+	f.n += uint64(len(f.buf))
+	err := writeFrame(f.w, archiveFrameData, f.buf)
+	f.buf = f.buf[:0]
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// ExportSnapshot writes a read-only snapshot to w as an archive, which ImportSnapshot restores
+// onto any Btrfs filesystem. With opts.Parent set, the archive holds an incremental stream, which
+// can only be imported where the parent has been imported or received before.
+// It returns the manifest stored at the end of the archive.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func ExportSnapshot(ctx context.Context, snapshot string, w io.Writer, opts *ExportOptions) (*ArchiveManifest, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	manifest := &ArchiveManifest{
+		Version:     archiveVersion,
+		Name:        filepath.Base(snapshot),
+		Created:     time.Now().UTC(),
+		Compression: opts.Compression,
+	}
+	if manifest.Compression == "" {
+		manifest.Compression = ArchiveCompressionGzip
+	}
+	if !validCompression(manifest.Compression) {
+		return nil, ErrInvalidArgument
+	}
+
+	info, err := GetSubvolumeInfo(snapshot, 0)
+	if err != nil {
+		return nil, err
+	}
+	manifest.UUID = info.UUID
+	manifest.Generation = info.Generation
+	manifest.Ctransid = info.Ctransid
+	manifest.Otime = info.Otime.UTC()
+	if opts.Parent != "" {
+		parent, err := GetSubvolumeInfo(opts.Parent, 0)
+		if err != nil {
+			return nil, err
+		}
+		manifest.ParentUUID = parent.UUID
+		if !parent.ReceivedUUID.IsZero() {
+			manifest.ParentUUID = parent.ReceivedUUID
+		}
+	}
+
+	header, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(archiveMagic)
+	binary.Write(bw, binary.LittleEndian, uint32(archiveVersion))
+	if err := writeFrame(bw, archiveFrameHeader, header); err != nil {
+		return nil, err
+	}
+
+	frames := &frameWriter{w: bw, buf: make([]byte, 0, archiveFrameSize)}
+	compressor, err := newCompressor(frames, manifest.Compression, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := newDigest()
+	if err := Send(ctx, snapshot, io.MultiWriter(stream, compressor), &opts.SendOptions); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	if err := frames.flush(); err != nil {
+		return nil, err
+	}
+
+	manifest.StreamBytes = stream.n
+	manifest.ArchiveBytes = frames.n
+	manifest.SHA256 = stream.sum()
+	trailer, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(bw, archiveFrameManifest, trailer); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// archiveReader reads the data frames of an archive. It returns io.EOF after the final manifest,
+// which is then available in manifest.
+type archiveReader struct {
+	r         *bufio.Reader
+	header    *ArchiveManifest
+	manifest  *ArchiveManifest
+	remaining uint32
+	n         uint64
+}
+
+// readFrame reads the type and length of the next frame.
+func (a *archiveReader) readFrame() (byte, uint32, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(a.r, header); err != nil {
+		return 0, 0, ErrInvalidArchive
+	}
+	return header[0], binary.LittleEndian.Uint32(header[1:]), nil
+}
+
+func (a *archiveReader) readManifest(length uint32) (*ArchiveManifest, error) {
+	if length > archiveFrameSize {
+		return nil, ErrInvalidArchive
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(a.r, data); err != nil {
+		return nil, ErrInvalidArchive
+	}
+	manifest := new(ArchiveManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return manifest, nil
+}
+
+func newArchiveReader(r io.Reader) (*archiveReader, error) {
+	a := &archiveReader{r: bufio.NewReaderSize(r, 256*1024)}
+	start := make([]byte, len(archiveMagic)+4)
+	if _, err := io.ReadFull(a.r, start); err != nil || string(start[:len(archiveMagic)]) != archiveMagic {
+		return nil, ErrInvalidArchive
+	}
+	if version := binary.LittleEndian.Uint32(start[len(archiveMagic):]); version != archiveVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, version)
+	}
+
+	typ, length, err := a.readFrame()
+	if err != nil || typ != archiveFrameHeader {
+		return nil, ErrInvalidArchive
+	}
+	if a.header, err = a.readManifest(length); err != nil {
+		return nil, err
+	}
+	if !validCompression(a.header.Compression) {
+		return nil, fmt.Errorf("%w: unsupported compression %q", ErrInvalidArchive, a.header.Compression)
+	}
+	return a, nil
+}
+
+func (a *archiveReader) Read(p []byte) (int, error) {
+	for a.remaining == 0 {
+		if a.manifest != nil {
+			return 0, io.EOF
+		}
+		typ, length, err := a.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch typ {
+		case archiveFrameData:
+			a.remaining = length
+		case archiveFrameManifest:
+			if a.manifest, err = a.readManifest(length); err != nil {
+				return 0, err
+			}
+			// Nothing may follow the final manifest.
+			if _, err := a.r.ReadByte(); err != io.EOF {
+				return 0, ErrInvalidArchive
+			}
+		default:
+			return 0, ErrInvalidArchive
+		}
+	}
+
+	if uint32(len(p)) > a.remaining {
+		p = p[:a.remaining]
+	}
+	n, err := a.r.Read(p)
+	a.remaining -= uint32(n)
+	a.n += uint64(n)
+	if err == io.EOF {
+		err = ErrInvalidArchive
+	}
+	return n, err
+}
+
+// stream returns a reader of the decompressed send stream, which adds the stream to d.
+// It must be closed to release the decompressor.
+func (a *archiveReader) stream(d *digest) (io.ReadCloser, error) {
+	var r io.ReadCloser = io.NopCloser(a)
+	var err error
+	switch a.header.Compression {
+	case ArchiveCompressionGzip:
+		r, err = gzip.NewReader(a)
+	case ArchiveCompressionZstd:
+		var zr *zstd.Decoder
+		// A single goroutine decodes synchronously, without read ahead.
+		if zr, err = zstd.NewReader(a, zstd.WithDecoderConcurrency(1)); err == nil {
+			r = zr.IOReadCloser()
+		}
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidArchive) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r, d), r}, nil
+}
+
+// verify checks that the archive has been read completely and matches its final manifest.
+func (a *archiveReader) verify(d *digest) error {
+	m := a.manifest
+	if m == nil {
+		return ErrInvalidArchive
+	}
+	if m.UUID != a.header.UUID || m.ParentUUID != a.header.ParentUUID || m.Compression != a.header.Compression {
+		return fmt.Errorf("%w: manifest does not match header", ErrInvalidArchive)
+	}
+	if m.ArchiveBytes != a.n || m.StreamBytes != d.n || m.SHA256 != d.sum() {
+		return ErrArchiveChecksum
+	}
+	return nil
+}
+
+// readStream passes the send stream of an archive to fn and verifies the archive afterwards.
+// fn must read the stream until its end or return an error.
+func readStream(r io.Reader, fn func(stream io.Reader) error) (*ArchiveManifest, error) {
+	a, err := newArchiveReader(r)
+	if err != nil {
+		return nil, err
+	}
+	d := newDigest()
+	stream, err := a.stream(d)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	if err := fn(stream); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		if errors.Is(err, ErrInvalidArchive) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if err := a.verify(d); err != nil {
+		return nil, err
+	}
+	return a.manifest, nil
+}
+
+// VerifyArchive reads an archive completely and checks its integrity.
+// It returns the manifest of the archive, or ErrArchiveChecksum if the data does not match it.
+func VerifyArchive(r io.Reader) (*ArchiveManifest, error) {
+	return readStream(r, func(io.Reader) error { return nil })
+}
+
+// ImportSnapshot restores the snapshot of an archive written by ExportSnapshot into dstDir
+// and returns its path and the manifest of the archive.
+// The archive is verified before it is received. If r is an io.ReadSeeker, it is read twice,
+// otherwise the archive is copied to a temporary file in os.TempDir first.
+// Incremental archives require the parent to be present in the filesystem of dstDir,
+// otherwise ErrParentNotFound is returned.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func ImportSnapshot(ctx context.Context, r io.Reader, dstDir string) (string, *ArchiveManifest, error) {
+	source, ok := r.(io.ReadSeeker)
+	var start int64
+	var err error
+	if ok {
+		if start, err = source.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+
+	var manifest *ArchiveManifest
+	if ok {
+		manifest, err = VerifyArchive(source)
+	} else {
+		var spool *os.File
+		if spool, err = os.CreateTemp("", "btrfs-archive-"); err != nil {
+			return "", nil, err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		manifest, err = VerifyArchive(io.TeeReader(r, spool))
+		source = spool
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := source.Seek(start, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+
+	if !manifest.ParentUUID.IsZero() {
+		if err := findParent(dstDir, manifest.ParentUUID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var received []string
+	_, err = readStream(source, func(stream io.Reader) error {
+		var err error
+		received, err = Receive(ctx, stream, dstDir)
+		if err == nil && len(received) != 1 {
+			err = fmt.Errorf("%w: expected one subvolume, got %d", ErrInvalidSendStream, len(received))
+		}
+		return err
+	})
+	// The archive may have changed since it was verified.
+	if err != nil && len(received) > 0 && (errors.Is(err, ErrArchiveChecksum) || errors.Is(err, ErrInvalidArchive) || errors.Is(err, ErrInvalidSendStream)) {
+		for _, path := range received {
+			DeleteSubvolume(path, false)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return received[0], manifest, nil
+}
+
+// findParent returns ErrParentNotFound unless a subvolume in the filesystem of dir
+// has the given UUID or was received from it.
+func findParent(dir string, uuid UUID) error {
+	results, err := FindSubvolumesByReceivedUUID(dir, uuid)
+	if err != nil {
+		return err
+	}
+	if len(results) > 0 {
+		return nil
+	}
+	if _, err := FindSubvolumeByUUID(dir, uuid); err != nil {
+		if errors.Is(err, ErrSubvolumeNotFound) {
+			return fmt.Errorf("%w: %s", ErrParentNotFound, uuid)
+		}
+		return err
+	}
+	return nil
+}
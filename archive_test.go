@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testArchive builds an archive of stream like ExportSnapshot, with data frames of frameSize bytes.
+func testArchive(t *testing.T, stream []byte, compression string, frameSize int) []byte {
+	var archive bytes.Buffer
+	archive.WriteString(archiveMagic)
+	binary.Write(&archive, binary.LittleEndian, uint32(archiveVersion))
+
+	manifest := &ArchiveManifest{Version: archiveVersion, Name: "snap", UUID: UUID{1}, Compression: compression}
+	header, _ := json.Marshal(manifest)
+	writeFrame(&archive, archiveFrameHeader, header)
+
+	frames := &frameWriter{w: &archive, buf: make([]byte, 0, frameSize)}
+	w, err := newCompressor(frames, compression, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDigest()
+	if _, err := io.MultiWriter(d, w).Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	frames.flush()
+
+	manifest.StreamBytes, manifest.ArchiveBytes, manifest.SHA256 = d.n, frames.n, d.sum()
+	trailer, _ := json.Marshal(manifest)
+	writeFrame(&archive, archiveFrameManifest, trailer)
+	return archive.Bytes()
+}
+
+func TestVerifyArchive(t *testing.T) {
+	stream := bytes.Repeat([]byte("btrfs-stream\x00"), 1000)
+	gzipped := testArchive(t, stream, ArchiveCompressionGzip, 100)
+	zstded := testArchive(t, stream, ArchiveCompressionZstd, 100)
+	plain := testArchive(t, stream, ArchiveCompressionNone, 4096)
+
+	corrupt := func(archive []byte, offset int) []byte {
+		archive = bytes.Clone(archive)
+		archive[offset] ^= 0xff
+		return archive
+	}
+	// The stream starts after the magic, version and the header frame.
+	payload := len(archiveMagic) + 4 + 5 + int(binary.LittleEndian.Uint32(plain[len(archiveMagic)+5:])) + 5
+
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr error
+	}{
+		{"gzip", gzipped, nil},
+		{"zstd", zstded, nil},
+		{"none", plain, nil},
+		{"payload", corrupt(plain, payload+10), ErrArchiveChecksum},
+		{"compressed payload", corrupt(gzipped, len(gzipped)/2), ErrInvalidArchive},
+		{"zstd payload", corrupt(zstded, len(zstded)/2), ErrInvalidArchive},
+		{"magic", corrupt(plain, 0), ErrInvalidArchive},
+		{"truncated", plain[:len(plain)-10], ErrInvalidArchive},
+		{"no manifest", plain[:payload+4096], ErrInvalidArchive},
+		{"trailing data", append(bytes.Clone(plain), 0), ErrInvalidArchive},
+		{"empty", nil, ErrInvalidArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := VerifyArchive(bytes.NewReader(tt.archive))
+			if tt.wantErr != nil {
+				// A corrupted compressed stream may fail either way.
+				if !errors.Is(err, tt.wantErr) && !errors.Is(err, ErrArchiveChecksum) {
+					t.Errorf("VerifyArchive() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyArchive() error = %v", err)
+			}
+			if manifest.StreamBytes != uint64(len(stream)) || manifest.UUID != (UUID{1}) {
+				t.Errorf("VerifyArchive() = %+v", manifest)
+			}
+		})
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	stream := bytes.Repeat([]byte{0, 1, 2, 3, 4, 5, 6, 7}, 300000)
+	for _, compression := range []string{ArchiveCompressionNone, ArchiveCompressionGzip, ArchiveCompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			var got []byte
+			manifest, err := readStream(bytes.NewReader(testArchive(t, stream, compression, archiveFrameSize)), func(r io.Reader) error {
+				var err error
+				got, err = io.ReadAll(r)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("readStream() error = %v", err)
+			}
+			if !bytes.Equal(got, stream) {
+				t.Errorf("readStream() stream differs")
+			}
+			if compression != ArchiveCompressionNone && manifest.ArchiveBytes >= manifest.StreamBytes {
+				t.Errorf("readStream() archive of %d bytes is not compressed", manifest.ArchiveBytes)
+			}
+		})
+	}
+}
+
+func TestExportImportSnapshot(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	subvol := filepath.Join(mountpoint.path, "subvol1")
+	if CreateSubvolume(subvol) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	src := filepath.Join(mountpoint.path, "src")
+	dst := filepath.Join(mountpoint.path, "dst")
+	os.Mkdir(src, 0755)
+	os.Mkdir(dst, 0755)
+
+	var archives [2]bytes.Buffer
+	for i, name := range []string{"snap1", "snap2"} {
+		os.WriteFile(filepath.Join(subvol, name), []byte(name), 0644)
+		if CreateSnapshot(subvol, filepath.Join(src, name), false, true) != nil {
+			t.Error("Failed to create snapshots")
+		}
+		opts := &ExportOptions{}
+		if i > 0 {
+			opts.Parent = filepath.Join(src, "snap1")
+			opts.Compression = ArchiveCompressionZstd
+		}
+		manifest, err := ExportSnapshot(context.Background(), filepath.Join(src, name), &archives[i], opts)
+		if err != nil {
+			t.Fatalf("ExportSnapshot() error = %v", err)
+		}
+		if manifest.Name != name || manifest.StreamBytes == 0 || (i > 0) == manifest.ParentUUID.IsZero() {
+			t.Errorf("ExportSnapshot() manifest = %+v", manifest)
+		}
+	}
+
+	// The first archive is imported from a reader which cannot seek.
+	path, manifest, err := ImportSnapshot(context.Background(), struct{ io.Reader }{&archives[0]}, dst)
+	if err != nil {
+		t.Fatalf("ImportSnapshot() error = %v", err)
+	}
+	if path != filepath.Join(dst, "snap1") || manifest.Name != "snap1" {
+		t.Errorf("ImportSnapshot() = %v, %+v", path, manifest)
+	}
+
+	corrupted := bytes.Clone(archives[1].Bytes())
+	corrupted[len(corrupted)/2] ^= 0xff
+	if _, _, err := ImportSnapshot(context.Background(), bytes.NewReader(corrupted), dst); err == nil {
+		t.Error("ImportSnapshot() of corrupted archive succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "snap2")); !os.IsNotExist(err) {
+		t.Error("ImportSnapshot() received corrupted archive")
+	}
+
+	if _, _, err := ImportSnapshot(context.Background(), bytes.NewReader(archives[1].Bytes()), dst); err != nil {
+		t.Fatalf("ImportSnapshot() incremental error = %v", err)
+	}
+	for _, name := range []string{"snap1", "snap2"} {
+		if data, _ := os.ReadFile(filepath.Join(dst, "snap2", name)); string(data) != name {
+			t.Errorf("ImportSnapshot() file content mismatch")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+import "C"
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// BalanceProgress is the progress of a running or paused balance.
+type BalanceProgress struct {
+	// Running is false if the balance is paused.
+	Running         bool
+	PauseRequested  bool
+	CancelRequested bool
+	// Expected is the estimated number of chunks to relocate.
+	Expected   uint64
+	Considered uint64
+	Completed  uint64
+}
+
+// GetBalanceProgress returns the progress of the balance of the filesystem containing path.
+// It returns ErrNotRunning if there is no running or paused balance.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func GetBalanceProgress(path string) (*BalanceProgress, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetBalanceProgressFd(file.Fd())
+}
+
+// See GetBalanceProgress.
+func GetBalanceProgressFd(fd uintptr) (*BalanceProgress, error) {
+	args := new(C.struct_btrfs_ioctl_balance_args)
+
+	if err := ioctl(fd, C.BTRFS_IOC_BALANCE_PROGRESS, unsafe.Pointer(args)); err != nil {
+		if err == syscall.ENOTCONN {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("%w: %v", ErrBalanceProgressFailed, err)
+	}
+
+	return &BalanceProgress{
+		Running:         args.state&C.BTRFS_BALANCE_STATE_RUNNING != 0,
+		PauseRequested:  args.state&C.BTRFS_BALANCE_STATE_PAUSE_REQ != 0,
+		CancelRequested: args.state&C.BTRFS_BALANCE_STATE_CANCEL_REQ != 0,
+		Expected:        uint64(args.stat.expected),
+		Considered:      uint64(args.stat.considered),
+		Completed:       uint64(args.stat.completed),
+	}, nil
+}
@@ -24,7 +24,6 @@ package btrfsutil
 // #include <btrfsutil.h>
 import "C"
 import (
-	"fmt"
 	"time"
 	"unsafe"
 )
@@ -35,9 +34,9 @@ type SubvolumeInfo struct {
 	ParentId     uint64
 	DirId        uint64
 	Flags        uint64
-	UUID         string
-	ParentUUID   string
-	ReceivedUUID string
+	UUID         UUID
+	ParentUUID   UUID
+	ReceivedUUID UUID
 	Generation   uint64
 	Ctransid     uint64
 	Otransid     uint64
@@ -55,9 +54,9 @@ func newSubvolumeInfo(info *C.struct_btrfs_util_subvolume_info) *SubvolumeInfo {
 		ParentId:     uint64(info.parent_id),
 		DirId:        uint64(info.dir_id),
 		Flags:        uint64(info.flags),
-		UUID:         uuidString(info.uuid),
-		ParentUUID:   uuidString(info.parent_uuid),
-		ReceivedUUID: uuidString(info.received_uuid),
+		UUID:         newUUID(info.uuid),
+		ParentUUID:   newUUID(info.parent_uuid),
+		ReceivedUUID: newUUID(info.received_uuid),
 		Generation:   uint64(info.generation),
 		Ctransid:     uint64(info.ctransid),
 		Otransid:     uint64(info.otransid),
@@ -71,8 +70,27 @@ func newSubvolumeInfo(info *C.struct_btrfs_util_subvolume_info) *SubvolumeInfo {
 	return &subvol
 }
 
-func uuidString(uuid [16]C.uchar) string {
-	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+// UUIDString returns the UUID of the subvolume as a string.
+func (info *SubvolumeInfo) UUIDString() string {
+	return info.UUID.String()
+}
+
+// ParentUUIDString returns the UUID of the subvolume's parent as a string.
+func (info *SubvolumeInfo) ParentUUIDString() string {
+	return info.ParentUUID.String()
+}
+
+// ReceivedUUIDString returns the UUID of the subvolume the subvolume was received from as a string.
+func (info *SubvolumeInfo) ReceivedUUIDString() string {
+	return info.ReceivedUUID.String()
+}
+
+func newUUID(uuid [16]C.uchar) UUID {
+	var u UUID
+	for i := range uuid {
+		u[i] = byte(uuid[i])
+	}
+	return u
 }
 
 // Sync forces a sync on a specific Btrfs filesystem.
@@ -435,14 +453,16 @@ func DeletedSubvolumes(path string) ([]uint64, error) {
 
 	var n C.size_t
 	var Cids *C.uint64_t
-	defer C.free(unsafe.Pointer(Cids))
+	// Cids is only set by the call, so it must not be evaluated by defer before.
+	defer func() { C.free(unsafe.Pointer(Cids)) }()
 
 	err := getError(C.btrfs_util_deleted_subvolumes(Cpath, &Cids, &n))
 
 	var ids []uint64
 
 	if n != 0 {
-		ids = (*[1 << 31]uint64)(unsafe.Pointer(Cids))[:n:n]
+		ids = make([]uint64, n)
+		copy(ids, (*[1 << 31]uint64)(unsafe.Pointer(Cids))[:n:n])
 	}
 	return ids, err
 }
@@ -451,14 +471,16 @@ func DeletedSubvolumes(path string) ([]uint64, error) {
 func DeletedSubvolumesFd(fd uintptr) ([]uint64, error) {
 	var n C.size_t
 	var Cids *C.uint64_t
-	defer C.free(unsafe.Pointer(Cids))
+	// Cids is only set by the call, so it must not be evaluated by defer before.
+	defer func() { C.free(unsafe.Pointer(Cids)) }()
 
 	err := getError(C.btrfs_util_deleted_subvolumes_fd(C.int(fd), &Cids, &n))
 
 	var ids []uint64
 
 	if n != 0 {
-		ids = (*[1 << 31]uint64)(unsafe.Pointer(Cids))[:n:n]
+		ids = make([]uint64, n)
+		copy(ids, (*[1 << 31]uint64)(unsafe.Pointer(Cids))[:n:n])
 	}
 	return ids, err
 }
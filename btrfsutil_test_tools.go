@@ -20,7 +20,6 @@
 package btrfsutil
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -92,16 +91,11 @@ func hasPrivileges() bool {
 }
 
 func superGeneration(mp *btrfsMountpoint) (uint64, error) {
-	bytes := make([]byte, 8)
-	_, err := mp.image.ReadAt(bytes, 65536+32+16+8+8)
+	super, err := ReadSuperblock(mp.image, 0)
 	if err != nil {
 		return 0, err
 	}
-	if string(bytes) != "_BHRfS_M" {
-		return 0, fmt.Errorf("wrong Magic value: got '%s' want '_BHRfS_M'", string(bytes))
-	}
-	_, err = mp.image.ReadAt(bytes, 65536+32+16+8+8+8)
-	return binary.LittleEndian.Uint64(bytes), err
+	return super.Generation, nil
 }
 
 func compareSubvolumeInfo(got, want *SubvolumeInfo) string {
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChecksumType is the checksum algorithm of a filesystem, used for metadata and data.
+type ChecksumType uint16
+
+const (
+	ChecksumCRC32C ChecksumType = iota
+	ChecksumXXHash
+	ChecksumSHA256
+	ChecksumBlake2b
+)
+
+// ChecksumSize is the size of the checksum fields of superblocks and tree nodes.
+const ChecksumSize = 32
+
+func (c ChecksumType) String() string {
+	switch c {
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash:
+		return "xxhash64"
+	case ChecksumSHA256:
+		return "sha256"
+	case ChecksumBlake2b:
+		return "blake2b"
+	}
+	return fmt.Sprintf("unknown (%d)", uint16(c))
+}
+
+// Size returns the number of bytes of a checksum, or 0 for unknown algorithms.
+func (c ChecksumType) Size() int {
+	switch c {
+	case ChecksumCRC32C:
+		return 4
+	case ChecksumXXHash:
+		return 8
+	case ChecksumSHA256, ChecksumBlake2b:
+		return 32
+	}
+	return 0
+}
+
+// Sum returns the checksum of data as it is stored on disk, padded with zeros to ChecksumSize.
+// It returns ErrUnsupportedChecksum for unknown algorithms.
+func (c ChecksumType) Sum(data []byte) ([ChecksumSize]byte, error) {
+	var sum [ChecksumSize]byte
+	switch c {
+	case ChecksumCRC32C:
+		binary.LittleEndian.PutUint32(sum[:], crc32.Checksum(data, crc32cTable))
+	case ChecksumXXHash:
+		binary.LittleEndian.PutUint64(sum[:], xxhash64(data))
+	case ChecksumSHA256:
+		sum = sha256.Sum256(data)
+	case ChecksumBlake2b:
+		sum = blake2b.Sum256(data)
+	default:
+		return sum, fmt.Errorf("%w: %v", ErrUnsupportedChecksum, c)
+	}
+	return sum, nil
+}
+
+const (
+	xxhashPrime1 uint64 = 11400714785074694791
+	xxhashPrime2 uint64 = 14029467366897019727
+	xxhashPrime3 uint64 = 1609587929392839161
+	xxhashPrime4 uint64 = 9650029242287828579
+	xxhashPrime5 uint64 = 2870177450012600261
+)
+
+func xxhashRound(acc, input uint64) uint64 {
+	acc += input * xxhashPrime2
+	return bits.RotateLeft64(acc, 31) * xxhashPrime1
+}
+
+func xxhashMerge(acc, val uint64) uint64 {
+	acc ^= xxhashRound(0, val)
+	return acc*xxhashPrime1 + xxhashPrime4
+}
+
+// xxhash64 returns the XXH64 hash of data with seed 0, which Btrfs uses.
+func xxhash64(data []byte) uint64 {
+	n := uint64(len(data))
+	var h uint64
+	if len(data) >= 32 {
+		v1 := xxhashPrime1
+		v1 += xxhashPrime2
+		v2 := xxhashPrime2
+		var v3 uint64
+		var v4 uint64
+		v4 -= xxhashPrime1
+		for ; len(data) >= 32; data = data[32:] {
+			v1 = xxhashRound(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxhashRound(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxhashRound(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxhashRound(v4, binary.LittleEndian.Uint64(data[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxhashMerge(h, v1)
+		h = xxhashMerge(h, v2)
+		h = xxhashMerge(h, v3)
+		h = xxhashMerge(h, v4)
+	} else {
+		h = xxhashPrime5
+	}
+	h += n
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxhashRound(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxhashPrime1 + xxhashPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxhashPrime1
+		h = bits.RotateLeft64(h, 23)*xxhashPrime2 + xxhashPrime3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * xxhashPrime5
+		h = bits.RotateLeft64(h, 11) * xxhashPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhashPrime2
+	h ^= h >> 29
+	h *= xxhashPrime3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			if got := xxhash64([]byte(tt.data)); got != tt.want {
+				t.Errorf("xxhash64() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksumType(t *testing.T) {
+	tests := []struct {
+		typ  ChecksumType
+		want string
+	}{
+		{ChecksumCRC32C, "b73f4b36"},
+		{ChecksumXXHash, "990977adf52cbc44"},
+		{ChecksumSHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{ChecksumBlake2b, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ.String(), func(t *testing.T) {
+			sum, err := tt.typ.Sum([]byte("abc"))
+			if err != nil {
+				t.Fatalf("Sum() error = %v", err)
+			}
+			if got := hex.EncodeToString(sum[:tt.typ.Size()]); got != tt.want {
+				t.Errorf("Sum() = %v, want %v", got, tt.want)
+			}
+			for _, b := range sum[tt.typ.Size():] {
+				if b != 0 {
+					t.Fatalf("Sum() = %x, not padded with zeros", sum)
+				}
+			}
+		})
+	}
+
+	if _, err := ChecksumType(4).Sum(nil); !errors.Is(err, ErrUnsupportedChecksum) {
+		t.Errorf("Sum() error = %v, want %v", err, ErrUnsupportedChecksum)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// requestedCapacity returns the qgroup limit for a capacity range, which is zero for no limit.
+func requestedCapacity(r *csi.CapacityRange) (uint64, error) {
+	required, limit := r.GetRequiredBytes(), r.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity must not be negative")
+	}
+	if limit != 0 && required > limit {
+		return 0, status.Error(codes.InvalidArgument, "required capacity exceeds the capacity limit")
+	}
+	if required != 0 {
+		return uint64(required), nil
+	}
+	return uint64(limit), nil
+}
+
+// capacityMatches reports whether an existing volume of the given capacity satisfies a capacity range.
+func capacityMatches(r *csi.CapacityRange, capacity uint64) bool {
+	required, limit := r.GetRequiredBytes(), r.GetLimitBytes()
+	if capacity == 0 {
+		return required == 0 && limit == 0
+	}
+	return uint64(required) <= capacity && (limit == 0 || capacity <= uint64(limit))
+}
+
+// qgroup returns the level 0 qgroup of a subvolume, or nil if quotas are not enabled.
+func qgroup(path string, info *btrfsutil.SubvolumeInfo) (*btrfsutil.QgroupInfo, error) {
+	qgroups, err := btrfsutil.GetQgroups(path)
+	if errors.Is(err, btrfsutil.ErrQuotaNotEnabled) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range qgroups {
+		if qgroups[i].Id == info.Id {
+			return &qgroups[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// accessible reports whether volumes on this node satisfy the requisite topology of a request.
+func (d *driver) accessible(requirements *csi.TopologyRequirement) bool {
+	if len(requirements.GetRequisite()) == 0 {
+		return true
+	}
+	for _, topology := range requirements.GetRequisite() {
+		if node, ok := topology.GetSegments()[topologyKey]; ok && node == d.nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// contentSource returns the path and info of the snapshot or volume a volume is created from.
+func (d *driver) contentSource(source *csi.VolumeContentSource) (string, *btrfsutil.SubvolumeInfo, error) {
+	if snapshot := source.GetSnapshot(); snapshot != nil {
+		return subvolume(d.snapshotsDir(), snapshot.GetSnapshotId(), "snapshot")
+	}
+	if volume := source.GetVolume(); volume != nil {
+		return subvolume(d.volumesDir(), volume.GetVolumeId(), "volume")
+	}
+	return "", nil, nil
+}
+
+// CreateVolume creates a subvolume, or a writable snapshot if the volume has a content source.
+// The capacity is enforced by a limit on the referenced space of the qgroup of the subvolume,
+// which requires quotas to be enabled.
+func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume name missing")
+	}
+	if !validId(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume name %q", name)
+	}
+	if err := validateCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, err
+	}
+	capacity, err := requestedCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	if !d.accessible(req.GetAccessibilityRequirements()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "volumes can only be created on node %q", d.nodeId)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	source, sourceInfo, err := d.contentSource(req.GetVolumeContentSource())
+	if err != nil {
+		return nil, err
+	}
+	response := func(capacity uint64) *csi.CreateVolumeResponse {
+		return &csi.CreateVolumeResponse{Volume: &csi.Volume{
+			VolumeId:           name,
+			CapacityBytes:      int64(capacity),
+			ContentSource:      req.GetVolumeContentSource(),
+			AccessibleTopology: []*csi.Topology{d.topology()},
+		}}
+	}
+
+	path, info, err := subvolume(d.volumesDir(), name, "volume")
+	if err == nil {
+		group, err := qgroup(path, info)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		var existing uint64
+		if group != nil {
+			existing = group.Limit.MaxReferenced
+		}
+		if !capacityMatches(req.GetCapacityRange(), existing) || (sourceInfo != nil && info.ParentUUID != sourceInfo.UUID) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %q exists with different parameters", name)
+		}
+		return response(existing), nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	if source != "" {
+		err = btrfsutil.CreateSnapshot(source, path, false, false)
+	} else {
+		err = btrfsutil.CreateSubvolume(path)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if capacity != 0 {
+		if err := btrfsutil.SetQgroupLimit(path, 0, btrfsutil.QgroupLimit{MaxReferenced: capacity}); err != nil {
+			btrfsutil.DeleteSubvolume(path, false)
+			return nil, internalError(err)
+		}
+	}
+	return response(capacity), nil
+}
+
+// DeleteVolume deletes the subvolume of a volume. Deleting a missing volume succeeds.
+func (d *driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path, _, err := subvolume(d.volumesDir(), req.GetVolumeId(), "volume")
+	if isNotFound(err) {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := btrfsutil.DeleteSubvolume(path, false); err != nil {
+		return nil, internalError(err)
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (d *driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if _, _, err := subvolume(d.volumesDir(), req.GetVolumeId(), "volume"); err != nil {
+		return nil, err
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities missing")
+	}
+	if err := validateCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: status.Convert(err).Message()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+		VolumeContext:      req.GetVolumeContext(),
+		VolumeCapabilities: req.GetVolumeCapabilities(),
+		Parameters:         req.GetParameters(),
+	}}, nil
+}
+
+func (d *driver) ControllerGetCapabilities(context.Context, *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	var capabilities []*csi.ControllerServiceCapability
+	for _, t := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+	} {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{Type: t}},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+// CreateSnapshot creates a read-only snapshot of a volume.
+func (d *driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot name missing")
+	}
+	if !validId(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid snapshot name %q", name)
+	}
+	if req.GetSourceVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume ID missing")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	source, sourceInfo, err := subvolume(d.volumesDir(), req.GetSourceVolumeId(), "volume")
+	if err != nil {
+		return nil, err
+	}
+
+	path, info, err := subvolume(d.snapshotsDir(), name, "snapshot")
+	if err == nil && info.ParentUUID != sourceInfo.UUID {
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %q exists for another volume", name)
+	}
+	if isNotFound(err) {
+		if err := btrfsutil.CreateSnapshot(source, path, false, true); err != nil {
+			return nil, internalError(err)
+		}
+		if info, err = btrfsutil.GetSubvolumeInfo(path, 0); err != nil {
+			return nil, internalError(err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	if group, err := qgroup(path, info); err != nil {
+		return nil, internalError(err)
+	} else if group != nil {
+		size = group.Referenced
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: &csi.Snapshot{
+		SnapshotId:     name,
+		SourceVolumeId: req.GetSourceVolumeId(),
+		SizeBytes:      int64(size),
+		CreationTime:   timestamppb.New(info.Otime),
+		ReadyToUse:     true,
+	}}, nil
+}
+
+// DeleteSnapshot deletes a snapshot. Deleting a missing snapshot succeeds.
+func (d *driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path, _, err := subvolume(d.snapshotsDir(), req.GetSnapshotId(), "snapshot")
+	if isNotFound(err) {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := btrfsutil.DeleteSubvolume(path, false); err != nil {
+		return nil, internalError(err)
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	driverName    = "btrfs.csi.libbtrfsutil-go"
+	driverVersion = "0.1.0"
+
+	// topologyKey restricts volumes to the node whose disk holds them.
+	topologyKey = driverName + "/node"
+)
+
+// driver implements the CSI identity, controller and node services.
+// Volumes are subvolumes in root/volumes and snapshots are read-only
+// subvolumes in root/snapshots, both named by their ID.
+type driver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+
+	root   string
+	nodeId string
+
+	// mu serializes the controller operations, so that repeated requests are idempotent.
+	mu sync.Mutex
+}
+
+func newDriver(root string, nodeId string) (*driver, error) {
+	d := &driver{root: root, nodeId: nodeId}
+	for _, dir := range []string{d.volumesDir(), d.snapshotsDir()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *driver) volumesDir() string {
+	return filepath.Join(d.root, "volumes")
+}
+
+func (d *driver) snapshotsDir() string {
+	return filepath.Join(d.root, "snapshots")
+}
+
+// validId reports whether an ID, which is also the subvolume name, is usable as a single path element.
+func validId(id string) bool {
+	return id != "" && id != "." && id != ".." && len(id) <= 255 && !strings.ContainsAny(id, "/\x00")
+}
+
+// subvolume returns the path of the subvolume with the given ID in dir.
+// It fails with InvalidArgument for an unusable ID and NotFound if there is no such subvolume.
+func subvolume(dir string, id string, kind string) (string, *btrfsutil.SubvolumeInfo, error) {
+	if id == "" {
+		return "", nil, status.Errorf(codes.InvalidArgument, "%s ID missing", kind)
+	}
+	if !validId(id) {
+		return "", nil, status.Errorf(codes.NotFound, "%s %q not found", kind, id)
+	}
+
+	path := filepath.Join(dir, id)
+	if ok, _ := btrfsutil.IsSubvolume(path); !ok {
+		return path, nil, status.Errorf(codes.NotFound, "%s %q not found", kind, id)
+	}
+	info, err := btrfsutil.GetSubvolumeInfo(path, 0)
+	if err != nil {
+		return path, nil, status.Errorf(codes.Internal, "%s %q: %v", kind, id, err)
+	}
+	return path, info, nil
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+// internalError converts an error of the library to a gRPC status.
+func internalError(err error) error {
+	if errors.Is(err, btrfsutil.ErrQuotaNotEnabled) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (d *driver) GetPluginInfo(context.Context, *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: driverName, VendorVersion: driverVersion}, nil
+}
+
+func (d *driver) GetPluginCapabilities(context.Context, *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	capability := func(t csi.PluginCapability_Service_Type) *csi.PluginCapability {
+		return &csi.PluginCapability{Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{Type: t},
+		}}
+	}
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: []*csi.PluginCapability{
+		capability(csi.PluginCapability_Service_CONTROLLER_SERVICE),
+		capability(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS),
+	}}, nil
+}
+
+func (d *driver) Probe(context.Context, *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if _, err := btrfsutil.GetFilesystemInfo(d.root); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &csi.ProbeResponse{}, nil
+}
+
+// topology returns the topology of the volumes on this node.
+func (d *driver) topology() *csi.Topology {
+	return &csi.Topology{Segments: map[string]string{topologyKey: d.nodeId}}
+}
+
+// validateCapabilities checks that all capabilities request a filesystem accessed from a single node.
+func validateCapabilities(capabilities []*csi.VolumeCapability) error {
+	if len(capabilities) == 0 {
+		return status.Error(codes.InvalidArgument, "volume capabilities missing")
+	}
+	for _, capability := range capabilities {
+		if capability.GetMount() == nil {
+			return status.Error(codes.InvalidArgument, "only filesystem volumes are supported")
+		}
+		switch capability.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		default:
+			return status.Errorf(codes.InvalidArgument, "access mode %v is not supported", capability.GetAccessMode().GetMode())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequestedCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		required int64
+		limit    int64
+		want     uint64
+		wantErr  bool
+	}{
+		{"none", 0, 0, 0, false},
+		{"required", 1 << 30, 0, 1 << 30, false},
+		{"limit", 0, 1 << 30, 1 << 30, false},
+		{"both", 1 << 20, 1 << 30, 1 << 20, false},
+		{"exceeds limit", 1 << 30, 1 << 20, 0, true},
+		{"negative", -1, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := requestedCapacity(&csi.CapacityRange{RequiredBytes: tt.required, LimitBytes: tt.limit})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("requestedCapacity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("requestedCapacity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapacityMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		required int64
+		limit    int64
+		capacity uint64
+		want     bool
+	}{
+		{"unlimited", 0, 0, 0, true},
+		{"unlimited for required", 1 << 30, 0, 0, false},
+		{"same", 1 << 30, 1 << 30, 1 << 30, true},
+		{"larger", 1 << 20, 0, 1 << 30, true},
+		{"smaller", 2 << 30, 0, 1 << 30, false},
+		{"above limit", 0, 1 << 20, 1 << 30, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capacityMatches(&csi.CapacityRange{RequiredBytes: tt.required, LimitBytes: tt.limit}, tt.capacity); got != tt.want {
+				t.Errorf("capacityMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mountCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	block := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	tests := []struct {
+		name         string
+		capabilities []*csi.VolumeCapability
+		wantErr      bool
+	}{
+		{"none", nil, true},
+		{"single node writer", []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}, false},
+		{"single node reader", []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)}, false},
+		{"multi node", []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)}, true},
+		{"block", []*csi.VolumeCapability{block}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCapabilities(tt.capabilities); (err != nil) != tt.wantErr {
+				t.Errorf("validateCapabilities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type clients struct {
+	csi.IdentityClient
+	csi.ControllerClient
+	csi.NodeClient
+}
+
+// serve serves a driver for root on a unix socket and returns clients connected to it.
+func serve(t *testing.T, root string) clients {
+	d, err := newDriver(root, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(d)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return clients{csi.NewIdentityClient(conn), csi.NewControllerClient(conn), csi.NewNodeClient(conn)}
+}
+
+func TestProtocol(t *testing.T) {
+	ctx := context.Background()
+	c := serve(t, t.TempDir())
+
+	if res, err := c.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}); err != nil || res.GetName() != driverName {
+		t.Errorf("GetPluginInfo() = %v, %v", res, err)
+	}
+	if res, err := c.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{}); err != nil || res.GetNodeId() != "node1" ||
+		res.GetAccessibleTopology().GetSegments()[topologyKey] != "node1" {
+		t.Errorf("NodeGetInfo() = %v, %v", res, err)
+	}
+
+	capabilities := []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}
+	invalid := []*csi.CreateVolumeRequest{
+		{VolumeCapabilities: capabilities},
+		{Name: "a/b", VolumeCapabilities: capabilities},
+		{Name: "vol"},
+	}
+	for _, req := range invalid {
+		if _, err := c.CreateVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateVolume(%v) error = %v, want %v", req, err, codes.InvalidArgument)
+		}
+	}
+	if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DeleteVolume() without ID error = %v, want %v", err, codes.InvalidArgument)
+	}
+	if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "missing"}); err != nil {
+		t.Errorf("DeleteVolume() of missing volume error = %v", err)
+	}
+	if _, err := c.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "missing"}); err != nil {
+		t.Errorf("DeleteSnapshot() of missing snapshot error = %v", err)
+	}
+	if _, err := c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("CreateSnapshot() of missing volume error = %v, want %v", err, codes.NotFound)
+	}
+	if _, err := c.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:         "missing",
+		TargetPath:       filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: capabilities[0],
+	}); status.Code(err) != codes.NotFound {
+		t.Errorf("NodePublishVolume() of missing volume error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	mountpoint := btrfstest.Mount(t)
+	if err := btrfsutil.SetQuotaEnabled(mountpoint, true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c := serve(t, mountpoint)
+	capability := mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	createVolume := &csi.CreateVolumeRequest{
+		Name:               "vol1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 8 << 20},
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+	}
+
+	res, err := c.CreateVolume(ctx, createVolume)
+	if err != nil || res.GetVolume().GetVolumeId() != "vol1" || res.GetVolume().GetCapacityBytes() != 8<<20 {
+		t.Fatalf("CreateVolume() = %v, %v", res, err)
+	}
+	if res, err := c.CreateVolume(ctx, createVolume); err != nil || res.GetVolume().GetCapacityBytes() != 8<<20 {
+		t.Errorf("repeated CreateVolume() = %v, %v", res, err)
+	}
+	createVolume.CapacityRange.RequiredBytes = 16 << 20
+	if _, err := c.CreateVolume(ctx, createVolume); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateVolume() with other capacity error = %v, want %v", err, codes.AlreadyExists)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	publish := &csi.NodePublishVolumeRequest{VolumeId: "vol1", TargetPath: target, VolumeCapability: capability}
+	for i := 0; i < 2; i++ {
+		if _, err := c.NodePublishVolume(ctx, publish); err != nil {
+			t.Fatalf("NodePublishVolume() error = %v", err)
+		}
+	}
+
+	// Writing beyond the capacity must fail once the qgroup limit is reached.
+	file, err := os.Create(filepath.Join(target, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 1<<20)
+	for i := 0; i < 16 && err == nil; i++ {
+		if _, err = file.Write(chunk); err == nil {
+			err = file.Sync()
+		}
+	}
+	file.Close()
+	if !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("write beyond capacity error = %v, want %v", err, syscall.EDQUOT)
+	}
+	os.Remove(filepath.Join(target, "data"))
+	if err := os.WriteFile(filepath.Join(target, "file"), []byte("vol1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unpublish := &csi.NodeUnpublishVolumeRequest{VolumeId: "vol1", TargetPath: target}
+	for i := 0; i < 2; i++ {
+		if _, err := c.NodeUnpublishVolume(ctx, unpublish); err != nil {
+			t.Fatalf("NodeUnpublishVolume() error = %v", err)
+		}
+	}
+	if _, err := os.Stat(target); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("target after NodeUnpublishVolume() error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	snapshot, err := c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap1", SourceVolumeId: "vol1"})
+	if err != nil || !snapshot.GetSnapshot().GetReadyToUse() || snapshot.GetSnapshot().GetSourceVolumeId() != "vol1" {
+		t.Fatalf("CreateSnapshot() = %v, %v", snapshot, err)
+	}
+	if err := os.WriteFile(filepath.Join(mountpoint, "snapshots", "snap1", "file"), nil, 0644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("write to snapshot error = %v, want %v", err, syscall.EROFS)
+	}
+
+	createVolume.Name = "vol2"
+	createVolume.CapacityRange = nil
+	createVolume.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap1"},
+	}}
+	if _, err := c.CreateVolume(ctx, createVolume); err != nil {
+		t.Fatalf("CreateVolume() from snapshot error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(mountpoint, "volumes", "vol2", "file")); string(data) != "vol1" {
+		t.Errorf("volume from snapshot content = %q, want vol1", data)
+	}
+	if err := os.WriteFile(filepath.Join(mountpoint, "volumes", "vol2", "file"), []byte("vol2"), 0644); err != nil {
+		t.Errorf("write to volume from snapshot error = %v", err)
+	}
+
+	if _, err := c.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "snap1"}); err != nil {
+		t.Errorf("DeleteSnapshot() error = %v", err)
+	}
+	for _, id := range []string{"vol1", "vol2"} {
+		if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id}); err != nil {
+			t.Errorf("DeleteVolume(%v) error = %v", id, err)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(mountpoint, "volumes"))
+	if len(entries) != 0 {
+		t.Errorf("volumes after DeleteVolume() = %v, want none", entries)
+	}
+}
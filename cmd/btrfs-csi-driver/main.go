@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Command btrfs-csi-driver is a Container Storage Interface plugin which provides every volume
+// as a Btrfs subvolume and every snapshot as a read-only snapshot of it.
+//
+// Usage:
+//
+//	btrfs-csi-driver [-endpoint unix:///path] [-node-id id] -root dir
+//
+// Volumes are subvolumes in dir/volumes, which must be on a Btrfs filesystem, and snapshots are
+// kept in dir/snapshots. The capacity of a volume is enforced through a qgroup limit, which requires
+// quotas to be enabled. The plugin runs the controller and node services together on every node,
+// and reports the node as the topology of its volumes. It can be tested with csi-sanity:
+//
+//	btrfs quota enable /mnt
+//	btrfs-csi-driver -endpoint unix:///tmp/csi.sock -root /mnt &
+//	csi-sanity -csi.endpoint /tmp/csi.sock
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+)
+
+// newServer returns a gRPC server providing all services of d.
+func newServer(d *driver) *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		res, err := handler(ctx, req)
+		if err != nil {
+			log.Printf("%s: %v", info.FullMethod, err)
+		}
+		return res, err
+	}))
+	csi.RegisterIdentityServer(server, d)
+	csi.RegisterControllerServer(server, d)
+	csi.RegisterNodeServer(server, d)
+	return server
+}
+
+func main() {
+	hostname, _ := os.Hostname()
+	endpoint := flag.String("endpoint", "unix:///run/csi/csi.sock", "unix socket to serve the CSI services on")
+	nodeId := flag.String("node-id", hostname, "ID of this node")
+	root := flag.String("root", "", "directory on a Btrfs filesystem to create the volumes and snapshots in")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: btrfs-csi-driver [-endpoint unix:///path] [-node-id id] -root dir")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *root == "" || *nodeId == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	socket := strings.TrimPrefix(*endpoint, "unix://")
+	if strings.Contains(socket, "://") {
+		log.Fatalf("unsupported endpoint %s", *endpoint)
+	}
+
+	d, err := newDriver(*root, *nodeId)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := newServer(d)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.GracefulStop()
+	}()
+
+	log.Printf("serving volumes in %s on %s", *root, socket)
+	err = server.Serve(listener)
+	os.Remove(socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Command btrfs-exporter serves metrics of Btrfs filesystems in the Prometheus text format.
+//
+// Usage:
+//
+//	btrfs-exporter [-listen address] [path...]
+//
+// Without paths, all mounted Btrfs filesystems are reported. Most metrics require root.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/sapphic-kitten/libbtrfsutil-go/exporter"
+)
+
+func main() {
+	listen := flag.String("listen", ":9919", "address to serve /metrics on")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: btrfs-exporter [-listen address] [path...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	http.Handle("/metrics", &exporter.Exporter{Paths: flag.Args()})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><a href="/metrics">Metrics</a></body></html>`)
+	})
+
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+// config is the configuration of the daemon, written as JSON or in a TOML-like form.
+type config struct {
+	Jobs []jobConfig `json:"jobs"`
+}
+
+type jobConfig struct {
+	Name      string `json:"name"`
+	Subvolume string `json:"subvolume"`
+	// SnapshotDir holds the snapshots of the job. Retention applies to all snapshots in it.
+	SnapshotDir string          `json:"snapshot_dir"`
+	Schedule    string          `json:"schedule"`
+	Naming      namingConfig    `json:"naming"`
+	Retention   retentionConfig `json:"retention"`
+	// ReadOnly defaults to true unless Recursive is set; recursive snapshots cannot be read-only.
+	ReadOnly  *bool    `json:"read_only"`
+	Recursive bool     `json:"recursive"`
+	PreHook   []string `json:"pre_hook"`
+	PostHook  []string `json:"post_hook"`
+}
+
+type namingConfig struct {
+	// Scheme is "iso8601", the default, "shadowcopy" or "template".
+	Scheme   string `json:"scheme"`
+	Prefix   string `json:"prefix"`
+	Template string `json:"template"`
+	// Location is the time zone of shadowcopy and template names, e.g. "UTC".
+	Location string `json:"location"`
+}
+
+type retentionConfig struct {
+	KeepLast int      `json:"keep_last"`
+	Hourly   int      `json:"hourly"`
+	Daily    int      `json:"daily"`
+	Weekly   int      `json:"weekly"`
+	Monthly  int      `json:"monthly"`
+	Yearly   int      `json:"yearly"`
+	MinAge   duration `json:"min_age"`
+	MaxCount int      `json:"max_count"`
+}
+
+// duration is a time.Duration written as a string like "36h" in JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(value)
+	return nil
+}
+
+// job is a validated job of the configuration.
+type job struct {
+	jobConfig
+	schedule  *schedule
+	namer     btrfsutil.SnapshotNamer
+	retention *btrfsutil.Retention
+	readOnly  bool
+}
+
+// loadConfig reads the configuration file and returns its jobs.
+func loadConfig(path string) ([]*job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything but a JSON object is read as the TOML-like form and decoded from its JSON encoding,
+	// so that both forms are checked alike.
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		values, err := parseTOML(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if data, err = json.Marshal(values); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var c config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var jobs []*job
+	names := make(map[string]bool)
+	for i := range c.Jobs {
+		j, err := newJob(c.Jobs[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: job %d: %w", path, i+1, err)
+		}
+		if names[j.Name] {
+			return nil, fmt.Errorf("%s: duplicate job %q", path, j.Name)
+		}
+		names[j.Name] = true
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func newJob(c jobConfig) (*job, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("name missing")
+	}
+	if !filepath.IsAbs(c.Subvolume) || !filepath.IsAbs(c.SnapshotDir) {
+		return nil, fmt.Errorf("subvolume and snapshot_dir must be absolute paths")
+	}
+	schedule, err := parseSchedule(c.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// libbtrfsutil refuses to create recursive snapshots read-only.
+	if c.Recursive && c.ReadOnly != nil && *c.ReadOnly {
+		return nil, fmt.Errorf("recursive snapshots cannot be read-only")
+	}
+
+	j := &job{jobConfig: c, schedule: schedule, readOnly: !c.Recursive}
+	if c.ReadOnly != nil {
+		j.readOnly = *c.ReadOnly
+	}
+	if j.namer, err = c.Naming.namer(); err != nil {
+		return nil, err
+	}
+	if r := c.Retention; r != (retentionConfig{}) {
+		j.retention = &btrfsutil.Retention{
+			KeepLast: r.KeepLast,
+			Hourly:   r.Hourly,
+			Daily:    r.Daily,
+			Weekly:   r.Weekly,
+			Monthly:  r.Monthly,
+			Yearly:   r.Yearly,
+			MinAge:   time.Duration(r.MinAge),
+			MaxCount: r.MaxCount,
+			// Snapshots of a recursive job contain the nested subvolumes.
+			Recursive: c.Recursive,
+		}
+	}
+	return j, nil
+}
+
+func (c namingConfig) namer() (btrfsutil.SnapshotNamer, error) {
+	var loc *time.Location
+	if c.Location != "" {
+		var err error
+		if loc, err = time.LoadLocation(c.Location); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.Scheme {
+	case "", "iso8601":
+		return btrfsutil.ISO8601Namer{Prefix: c.Prefix}, nil
+	case "shadowcopy":
+		return btrfsutil.ShadowCopyNamer{Location: loc}, nil
+	case "template":
+		if c.Template == "" {
+			return nil, fmt.Errorf("naming template missing")
+		}
+		return btrfsutil.TemplateNamer{Template: c.Template, Location: loc}, nil
+	}
+	return nil, fmt.Errorf("unknown naming scheme %q", c.Scheme)
+}
+
+// sameSchedule reports whether a reloaded job keeps the schedule of j, so that its next run carries over.
+func (j *job) sameSchedule(other *job) bool {
+	return j.Name == other.Name && j.Schedule == other.Schedule
+}
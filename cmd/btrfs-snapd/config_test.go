@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "btrfs-snapd.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"jobs": [{
+			"name": "home",
+			"subvolume": "/home",
+			"snapshot_dir": "/snapshots/home",
+			"schedule": "@hourly",
+			"naming": {"prefix": "home-"},
+			"retention": {"hourly": 24, "daily": 7, "min_age": "30m"},
+			"pre_hook": ["true"]
+		}, {
+			"name": "data",
+			"subvolume": "/data",
+			"snapshot_dir": "/snapshots/data",
+			"schedule": "*/5 * * * *",
+			"naming": {"scheme": "template", "template": "data-%Y%m%d-%H%M", "location": "UTC"},
+			"read_only": false,
+			"recursive": true
+		}]
+	}`)
+
+	jobs, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("loadConfig() = %d jobs, want 2", len(jobs))
+	}
+
+	home := jobs[0]
+	if !home.readOnly || home.Recursive || len(home.PreHook) != 1 {
+		t.Errorf("home = %+v", home)
+	}
+	if home.retention == nil || home.retention.Hourly != 24 || home.retention.Daily != 7 || home.retention.MinAge != 30*time.Minute {
+		t.Errorf("home retention = %+v", home.retention)
+	}
+	if namer, ok := home.namer.(btrfsutil.ISO8601Namer); !ok || namer.Prefix != "home-" {
+		t.Errorf("home namer = %#v", home.namer)
+	}
+
+	data := jobs[1]
+	if data.readOnly || !data.Recursive || data.retention != nil {
+		t.Errorf("data = %+v", data)
+	}
+	if got := data.namer.Name(time.Date(2023, 1, 15, 10, 30, 0, 0, time.UTC), nil); got != "data-20230115-1030" {
+		t.Errorf("data namer Name() = %q", got)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeConfig(t, `# snapshots of /home and /data
+[[jobs]]
+name = "home"
+subvolume = "/home"
+snapshot_dir = '/snapshots/home'
+schedule = "@hourly" # every hour
+naming = {prefix = "home-"}
+pre_hook = ["true", "--", "a # b"]
+
+[jobs.retention]
+hourly = 24
+daily = 7
+min_age = "30m"
+
+[[jobs]]
+name = "data"
+subvolume = "/data"
+snapshot_dir = "/snapshots/data"
+schedule = "*/5 * * * *"
+recursive = true
+
+[jobs.naming]
+scheme = "template"
+template = "data-%Y%m%d-%H%M"
+location = "UTC"
+`)
+
+	jobs, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("loadConfig() = %d jobs, want 2", len(jobs))
+	}
+
+	home := jobs[0]
+	if !home.readOnly || home.Recursive || home.SnapshotDir != "/snapshots/home" || len(home.PreHook) != 3 || home.PreHook[2] != "a # b" {
+		t.Errorf("home = %+v", home)
+	}
+	if home.retention == nil || home.retention.Hourly != 24 || home.retention.Daily != 7 || home.retention.MinAge != 30*time.Minute || home.retention.Recursive {
+		t.Errorf("home retention = %+v", home.retention)
+	}
+	if namer, ok := home.namer.(btrfsutil.ISO8601Namer); !ok || namer.Prefix != "home-" {
+		t.Errorf("home namer = %#v", home.namer)
+	}
+
+	// Recursive snapshots are writable unless configured otherwise.
+	data := jobs[1]
+	if data.readOnly || !data.Recursive || data.retention != nil {
+		t.Errorf("data = %+v", data)
+	}
+	if got := data.namer.Name(time.Date(2023, 1, 15, 10, 30, 0, 0, time.UTC), nil); got != "data-20230115-1030" {
+		t.Errorf("data namer Name() = %q", got)
+	}
+}
+
+func TestLoadConfigRecursiveRetention(t *testing.T) {
+	path := writeConfig(t, `{"jobs": [{"name": "a", "subvolume": "/a", "snapshot_dir": "/s", "schedule": "@daily",
+		"recursive": true, "retention": {"keep_last": 3}}]}`)
+	jobs, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j := jobs[0]; j.readOnly || j.retention == nil || !j.retention.Recursive {
+		t.Errorf("job = %+v, retention = %+v", j, j.retention)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	job := `"subvolume": "/home", "snapshot_dir": "/snapshots", "schedule": "@daily"`
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"syntax", `{"jobs": [`, "unexpected EOF"},
+		{"unknown field", `{"jobs": [{"name": "a", ` + job + `, "color": "red"}]}`, "unknown field"},
+		{"no name", `{"jobs": [{` + job + `}]}`, "name missing"},
+		{"relative", `{"jobs": [{"name": "a", "subvolume": "home", "snapshot_dir": "/s", "schedule": "@daily"}]}`, "absolute"},
+		{"schedule", `{"jobs": [{"name": "a", "subvolume": "/home", "snapshot_dir": "/s", "schedule": "daily"}]}`, "5 fields"},
+		{"naming", `{"jobs": [{"name": "a", ` + job + `, "naming": {"scheme": "snapper"}}]}`, "unknown naming scheme"},
+		{"template", `{"jobs": [{"name": "a", ` + job + `, "naming": {"scheme": "template"}}]}`, "template missing"},
+		{"min age", `{"jobs": [{"name": "a", ` + job + `, "retention": {"min_age": "1 day"}}]}`, "duration"},
+		{"duplicate", `{"jobs": [{"name": "a", ` + job + `}, {"name": "a", ` + job + `}]}`, "duplicate"},
+		{"recursive read-only", `{"jobs": [{"name": "a", ` + job + `, "recursive": true, "read_only": true}]}`, "cannot be read-only"},
+		{"toml syntax", "[[jobs]]\nname = \"a", "line 2: unterminated string"},
+		{"toml value", "[[jobs]]\nname = a", "line 2: invalid value"},
+		{"toml duplicate key", "[[jobs]]\nname = \"a\"\nname = \"b\"", "line 3: duplicate key"},
+		{"toml header", "[[jobs]\nname = \"a\"", "line 1: unterminated table header"},
+		{"toml unknown field", "[[jobs]]\ncolor = \"red\"", "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
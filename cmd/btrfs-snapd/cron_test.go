@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Sunday, 15 January 2023
+	from := time.Date(2023, 1, 15, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2023, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2023, 1, 22, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2023, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0,30 8-18/2 * * *", time.Date(2023, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2023, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, 1, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields are restricted, so either matches: the 20th or the next Wednesday.
+		{"0 0 20 * wed", time.Date(2023, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.next(from); !got.Equal(tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// 02:30 does not exist on 26 March 2023, so there is no run that day.
+		{"skipped", "30 2 * * *", time.Date(2023, 3, 26, 1, 0, 0, 0, loc), time.Date(2023, 3, 27, 2, 30, 0, 0, loc)},
+		{"hourly across skipped", "0 * * * *", time.Date(2023, 3, 26, 1, 30, 0, 0, loc), time.Date(2023, 3, 26, 3, 0, 0, 0, loc)},
+		// 02:00 occurs twice on 29 October 2023, the first is 00:00 UTC.
+		{"repeated", "0 * * * *", time.Date(2023, 10, 29, 1, 30, 0, 0, loc), time.Date(2023, 10, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+			if !got.After(tt.from) {
+				t.Errorf("next() = %v is not after %v", got, tt.from)
+			}
+		})
+	}
+}
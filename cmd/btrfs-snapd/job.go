@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+// runHook runs a hook command with the job environment added.
+// Its output is logged at the warning level if it fails.
+func runHook(ctx context.Context, logger *slog.Logger, hook []string, env []string) error {
+	if len(hook) == 0 {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, hook[0], hook[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.Warn("hook failed", "command", hook, "output", string(output), "error", err)
+		return err
+	}
+	return nil
+}
+
+// run takes a snapshot for the job at now and applies its retention.
+// The pre hook runs before the snapshot and the post hook after it, even if the snapshot failed,
+// so that a post hook can undo what the pre hook did. A failing pre hook cancels the run.
+func (j *job) run(ctx context.Context, logger *slog.Logger, now time.Time) error {
+	logger = logger.With("job", j.Name)
+	env := []string{"BTRFS_SNAPD_JOB=" + j.Name, "BTRFS_SNAPD_SUBVOLUME=" + j.Subvolume}
+
+	if err := runHook(ctx, logger, j.PreHook, env); err != nil {
+		return fmt.Errorf("pre hook: %w", err)
+	}
+	path, err := j.snapshot(now)
+	status := "ok"
+	if err != nil {
+		status = "failed"
+	}
+	postErr := runHook(ctx, logger, j.PostHook, append(env, "BTRFS_SNAPD_SNAPSHOT="+path, "BTRFS_SNAPD_STATUS="+status))
+	if err != nil {
+		return err
+	}
+	logger.Info("created snapshot", "path", path)
+	if postErr != nil {
+		return fmt.Errorf("post hook: %w", postErr)
+	}
+
+	if j.retention == nil {
+		return nil
+	}
+	plan, err := j.retention.Apply(ctx, j.SnapshotDir)
+	if plan != nil {
+		// On error, the snapshots before the failing one have been deleted.
+		for _, snapshot := range plan.Delete {
+			if _, statErr := os.Lstat(snapshot.Path); os.IsNotExist(statErr) {
+				logger.Info("deleted snapshot", "path", snapshot.Path)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	return nil
+}
+
+// snapshot creates the snapshot of the job, named by its naming scheme, and returns its path.
+func (j *job) snapshot(now time.Time) (string, error) {
+	if err := os.MkdirAll(j.SnapshotDir, 0755); err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(j.SnapshotDir)
+	if err != nil {
+		return "", err
+	}
+	existing := make([]string, len(entries))
+	for i, entry := range entries {
+		existing[i] = entry.Name()
+	}
+
+	path := filepath.Join(j.SnapshotDir, j.namer.Name(now, existing))
+	if err := btrfsutil.CreateSnapshot(j.Subvolume, path, j.Recursive, j.readOnly); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Command btrfs-snapd takes snapshots of subvolumes on a schedule and prunes them by a retention policy.
+//
+// Usage:
+//
+//	btrfs-snapd [-config file] [-check] [-log-json]
+//
+// The configuration is a JSON file with a list of jobs, for example
+//
+//	{
+//		"jobs": [{
+//			"name": "home",
+//			"subvolume": "/home",
+//			"snapshot_dir": "/snapshots/home",
+//			"schedule": "0 * * * *",
+//			"naming": {"scheme": "iso8601", "prefix": "home-"},
+//			"retention": {"hourly": 24, "daily": 7, "weekly": 4, "min_age": "30m"},
+//			"read_only": true,
+//			"recursive": false,
+//			"pre_hook": ["/usr/local/bin/db-freeze"],
+//			"post_hook": ["/usr/local/bin/db-thaw"]
+//		}]
+//	}
+//
+// The same configuration may be written in a TOML-like form, which is used if the file does not
+// start with "{". It supports comments, [tables], [[arrays of tables]], strings, integers, booleans,
+// arrays and inline tables, with every key and value on one line:
+//
+//	[[jobs]]
+//	name = "home"
+//	subvolume = "/home"
+//	snapshot_dir = "/snapshots/home"
+//	schedule = "0 * * * *"
+//	naming = {scheme = "iso8601", prefix = "home-"}
+//	pre_hook = ["/usr/local/bin/db-freeze"]
+//	post_hook = ["/usr/local/bin/db-thaw"]
+//
+//	[jobs.retention]
+//	hourly = 24
+//	daily = 7
+//	weekly = 4
+//	min_age = "30m"
+//
+// Snapshots are read-only unless read_only is false. Recursive snapshots, which include the
+// subvolumes nested in subvolume, cannot be read-only, so read_only defaults to false for them and
+// retention deletes them together with their nested subvolumes.
+//
+// Schedules are cron expressions with five fields or one of the macros @hourly, @daily, @weekly,
+// @monthly and @yearly, evaluated in the local time zone. Naming schemes are iso8601, shadowcopy and
+// template, which takes a "template" like "home-%Y%m%d-%H%M". Retention applies to all snapshots in
+// snapshot_dir, see Retention of libbtrfsutil-go; it is skipped if no rule is given.
+//
+// Hooks get the environment variables BTRFS_SNAPD_JOB and BTRFS_SNAPD_SUBVOLUME, and the post hook
+// additionally BTRFS_SNAPD_SNAPSHOT and BTRFS_SNAPD_STATUS, which is ok or failed.
+// A failing pre hook cancels the run; the post hook runs whenever the pre hook succeeded.
+//
+// On SIGHUP the configuration is reloaded. Jobs keep their next run if their schedule is unchanged,
+// and runs which became due while reloading are not missed. An invalid configuration is logged
+// and the previous one is kept.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	path := flag.String("config", "/etc/btrfs-snapd.json", "configuration file")
+	check := flag.Bool("check", false, "check the configuration and exit")
+	logJSON := flag.Bool("log-json", false, "log in JSON instead of text")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: btrfs-snapd [-config file] [-check] [-log-json]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	if *logJSON {
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	}
+	logger := slog.New(handler)
+
+	jobs, err := loadConfig(*path)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if *check {
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	reload := make(chan []*job)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+			jobs, err := loadConfig(*path)
+			if err != nil {
+				logger.Error("keeping previous configuration", "error", err)
+				continue
+			}
+			logger.Info("reloaded configuration", "jobs", len(jobs))
+			select {
+			case reload <- jobs:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	s := newScheduler(logger, time.Now())
+	s.load(jobs)
+	s.serve(ctx, reload)
+	logger.Info("stopped")
+}
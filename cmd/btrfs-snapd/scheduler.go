@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxWait bounds the sleep of the scheduler, so that changes of the wall clock and
+// resuming from suspend are noticed within a minute.
+const maxWait = time.Minute
+
+// scheduledJob is a job with the state of its schedule.
+type scheduledJob struct {
+	*job
+	next    time.Time
+	running bool
+}
+
+// scheduler runs jobs according to their schedules. Its jobs can be replaced
+// while it runs without losing runs which are due.
+type scheduler struct {
+	logger *slog.Logger
+	// run runs a job, defaulting to job.run.
+	run func(ctx context.Context, j *job, now time.Time) error
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+	// checked is the time up to which all due runs have been started.
+	checked time.Time
+	wg      sync.WaitGroup
+}
+
+func newScheduler(logger *slog.Logger, now time.Time) *scheduler {
+	s := &scheduler{logger: logger, jobs: make(map[string]*scheduledJob), checked: now}
+	s.run = func(ctx context.Context, j *job, now time.Time) error {
+		return j.run(ctx, s.logger, now)
+	}
+	return s
+}
+
+// load replaces the jobs. A job whose name and schedule are unchanged keeps its next run,
+// other jobs are scheduled after the last check, so no run due since then is missed.
+func (s *scheduler) load(jobs []*job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scheduled := make(map[string]*scheduledJob, len(jobs))
+	for _, j := range jobs {
+		next := &scheduledJob{job: j}
+		if old, ok := s.jobs[j.Name]; ok {
+			next.running = old.running
+			if old.sameSchedule(j) {
+				next.next = old.next
+			}
+		}
+		if next.next.IsZero() {
+			next.next = j.schedule.next(s.checked)
+		}
+		scheduled[j.Name] = next
+		s.logger.Info("scheduled job", "job", j.Name, "next", next.next)
+	}
+	s.jobs = scheduled
+}
+
+// due returns the jobs whose next run is not after now and schedules their following run.
+// Jobs which are still running are skipped for this run.
+func (s *scheduler) due(now time.Time) []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []*job
+	for _, j := range s.jobs {
+		if j.next.IsZero() || j.next.After(now) {
+			continue
+		}
+		j.next = j.schedule.next(now)
+		if j.running {
+			s.logger.Warn("skipped run, job is still running", "job", j.Name)
+			continue
+		}
+		j.running = true
+		jobs = append(jobs, j.job)
+	}
+	s.checked = now
+	return jobs
+}
+
+// finish marks a job as no longer running.
+func (s *scheduler) finish(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[name]; ok {
+		j.running = false
+	}
+}
+
+// wait returns how long to sleep until the next run.
+func (s *scheduler) wait(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := maxWait
+	for _, j := range s.jobs {
+		if !j.next.IsZero() && j.next.Sub(now) < wait {
+			wait = j.next.Sub(now)
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// start starts the runs of all due jobs. Runs are not canceled with ctx,
+// so that a post hook always follows its pre hook.
+func (s *scheduler) start(ctx context.Context, now time.Time) {
+	ctx = context.WithoutCancel(ctx)
+	for _, j := range s.due(now) {
+		s.wg.Add(1)
+		go func(j *job) {
+			defer s.wg.Done()
+			defer s.finish(j.Name)
+			if err := s.run(ctx, j, now); err != nil {
+				s.logger.Error("job failed", "job", j.Name, "error", err)
+			}
+		}(j)
+	}
+}
+
+// serve runs the jobs until ctx is done and waits for running jobs to finish.
+// A configuration received on reload replaces the jobs.
+func (s *scheduler) serve(ctx context.Context, reload <-chan []*job) {
+	timer := time.NewTimer(s.wait(time.Now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			return
+		case jobs := <-reload:
+			s.load(jobs)
+		case <-timer.C:
+		}
+		s.start(ctx, time.Now())
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.wait(time.Now()))
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testJob(t *testing.T, name string, spec string) *job {
+	j, err := newJob(jobConfig{Name: name, Subvolume: "/" + name, SnapshotDir: "/snapshots/" + name, Schedule: spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func dueNames(jobs []*job) []string {
+	var names []string
+	for _, j := range jobs {
+		names = append(names, j.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSchedulerDue(t *testing.T) {
+	start := time.Date(2023, 1, 15, 10, 0, 30, 0, time.UTC)
+	s := newScheduler(slog.New(slog.NewTextHandler(io.Discard, nil)), start)
+	s.load([]*job{testJob(t, "hourly", "@hourly"), testJob(t, "quarter", "*/15 * * * *")})
+
+	if got := s.wait(start); got != maxWait {
+		t.Errorf("wait() = %v, want %v", got, maxWait)
+	}
+	if got := s.wait(start.Add(14 * time.Minute)); got != 30*time.Second {
+		t.Errorf("wait() = %v, want 30s", got)
+	}
+	if got := dueNames(s.due(start.Add(10 * time.Minute))); len(got) != 0 {
+		t.Errorf("due() = %v, want none", got)
+	}
+	if got := dueNames(s.due(start.Add(15 * time.Minute))); len(got) != 1 || got[0] != "quarter" {
+		t.Errorf("due() = %v, want [quarter]", got)
+	}
+
+	// A run due while the previous one is running is skipped.
+	if got := dueNames(s.due(start.Add(30 * time.Minute))); len(got) != 0 {
+		t.Errorf("due() while running = %v, want none", got)
+	}
+	s.finish("quarter")
+
+	// After a suspend, each overdue job runs once.
+	if got := dueNames(s.due(start.Add(3 * time.Hour))); len(got) != 2 {
+		t.Errorf("due() after suspend = %v, want both", got)
+	}
+}
+
+func TestSchedulerReload(t *testing.T) {
+	start := time.Date(2023, 1, 15, 10, 0, 30, 0, time.UTC)
+	s := newScheduler(slog.New(slog.NewTextHandler(io.Discard, nil)), start)
+	s.load([]*job{testJob(t, "a", "*/15 * * * *"), testJob(t, "b", "@hourly")})
+	s.due(start.Add(5 * time.Minute))
+
+	// The reload takes effect after the run of a at 10:15 became due. It must still run,
+	// and b, whose schedule changed, is scheduled from the last check instead of now.
+	s.load([]*job{testJob(t, "a", "*/15 * * * *"), testJob(t, "b", "10,20 * * * *"), testJob(t, "c", "@daily")})
+	if got := dueNames(s.due(start.Add(16 * time.Minute))); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("due() after reload = %v, want [a b]", got)
+	}
+	if _, ok := s.jobs["c"]; !ok {
+		t.Errorf("job c was not added")
+	}
+
+	// The running state survives a reload, and removed jobs are dropped.
+	s.load([]*job{testJob(t, "a", "*/15 * * * *")})
+	if len(s.jobs) != 1 || !s.jobs["a"].running {
+		t.Errorf("jobs after reload = %v", s.jobs)
+	}
+}
+
+func TestSchedulerServe(t *testing.T) {
+	s := newScheduler(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Now())
+
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	done := make(chan struct{})
+	s.run = func(ctx context.Context, j *job, now time.Time) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if runs[j.Name]++; runs[j.Name] == 1 {
+			close(done)
+		}
+		return nil
+	}
+
+	// A job reloaded with a run in the past is due immediately.
+	j := testJob(t, "a", "* * * * *")
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan []*job)
+	stopped := make(chan struct{})
+	go func() {
+		s.serve(ctx, reload)
+		close(stopped)
+	}()
+	s.mu.Lock()
+	s.checked = time.Now().Add(-2 * time.Minute)
+	s.mu.Unlock()
+	reload <- []*job{j}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job did not run")
+	}
+	cancel()
+	<-stopped
+}
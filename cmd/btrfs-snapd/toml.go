@@ -0,0 +1,274 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the TOML-like form of the configuration into the generic values of encoding/json,
+// so that it is decoded like the JSON form. It supports comments, tables, arrays of tables, strings,
+// integers, booleans, arrays and inline tables; every key and value must be on one line.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	current := root
+	for i, line := range strings.Split(string(data), "\n") {
+		p := &tomlParser{s: strings.TrimSpace(line)}
+		var err error
+		if current, err = p.line(root, current); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return root, nil
+}
+
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+// line parses a table header or a key/value pair into current and returns the table following it.
+func (p *tomlParser) line(root, current map[string]interface{}) (map[string]interface{}, error) {
+	if p.done() {
+		return current, nil
+	}
+	if p.peek() == '[' {
+		table, err := p.header(root)
+		if err != nil {
+			return nil, err
+		}
+		return table, p.end()
+	}
+	if err := p.keyValue(current); err != nil {
+		return nil, err
+	}
+	return current, p.end()
+}
+
+// header parses [table] or [[array]]. Dotted names refer to the last element of arrays of tables.
+func (p *tomlParser) header(root map[string]interface{}) (map[string]interface{}, error) {
+	p.consume('[')
+	array := p.consume('[')
+	var path []string
+	for {
+		p.skipSpace()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, key)
+		p.skipSpace()
+		if !p.consume('.') {
+			break
+		}
+	}
+	if !p.consume(']') || array && !p.consume(']') {
+		return nil, fmt.Errorf("unterminated table header")
+	}
+
+	table := root
+	for _, key := range path[:len(path)-1] {
+		switch value := table[key].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			table[key] = child
+			table = child
+		case map[string]interface{}:
+			table = value
+		case []interface{}:
+			last, ok := value[len(value)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%q is not a table", key)
+		}
+	}
+
+	key := path[len(path)-1]
+	child := make(map[string]interface{})
+	if array {
+		tables, ok := table[key].([]interface{})
+		if table[key] != nil && !ok {
+			return nil, fmt.Errorf("%q is not an array", key)
+		}
+		table[key] = append(tables, child)
+		return child, nil
+	}
+	if _, ok := table[key]; ok {
+		return nil, fmt.Errorf("duplicate key %q", key)
+	}
+	table[key] = child
+	return child, nil
+}
+
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	key, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if !p.consume('=') {
+		return fmt.Errorf("missing = after %q", key)
+	}
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	if _, ok := table[key]; ok {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	table[key] = value
+	return nil
+}
+
+// key parses a bare key.
+func (p *tomlParser) key() (string, error) {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("key expected at %q", p.s[start:])
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	switch p.peek() {
+	case '"':
+		end := p.pos + 1
+		for ; end < len(p.s) && p.s[end] != '"'; end++ {
+			if p.s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(p.s) {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		value, err := strconv.Unquote(p.s[p.pos : end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", p.s[p.pos:end+1])
+		}
+		p.pos = end + 1
+		return value, nil
+	case '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		value := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return value, nil
+	case '[':
+		p.consume('[')
+		values := []interface{}{}
+		for {
+			p.skipSpace()
+			if p.consume(']') {
+				return values, nil
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			p.skipSpace()
+			if !p.consume(',') && p.peek() != ']' {
+				return nil, fmt.Errorf("unterminated array")
+			}
+		}
+	case '{':
+		p.consume('{')
+		table := make(map[string]interface{})
+		for {
+			p.skipSpace()
+			if p.consume('}') {
+				return table, nil
+			}
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume(',') && p.peek() != '}' {
+				return nil, fmt.Errorf("unterminated inline table")
+			}
+		}
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t,]}#", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	value, err := strconv.ParseInt(strings.ReplaceAll(token, "_", ""), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", token)
+	}
+	return value, nil
+}
+
+// end checks that only a comment is left.
+func (p *tomlParser) end() error {
+	p.skipSpace()
+	if !p.done() {
+		return fmt.Errorf("unexpected %q", p.s[p.pos:])
+	}
+	return nil
+}
+
+func (p *tomlParser) done() bool {
+	return p.pos >= len(p.s) || p.s[p.pos] == '#'
+}
+
+func (p *tomlParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *tomlParser) consume(c byte) bool {
+	if p.peek() != c || c == 0 {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+// driver implements the Docker volume plugin protocol with one subvolume per volume below root.
+type driver struct {
+	root string
+
+	mu sync.Mutex
+	// mounts are the IDs of the containers which mounted a volume, by volume name.
+	mounts map[string]map[string]bool
+}
+
+func newDriver(root string) *driver {
+	return &driver{root: root, mounts: make(map[string]map[string]bool)}
+}
+
+type request struct {
+	Name string
+	Opts map[string]string
+	ID   string
+}
+
+type volume struct {
+	Name       string
+	Mountpoint string                 `json:",omitempty"`
+	CreatedAt  string                 `json:",omitempty"`
+	Status     map[string]interface{} `json:",omitempty"`
+}
+
+type capabilities struct {
+	Scope string
+}
+
+type response struct {
+	Err          string
+	Mountpoint   string        `json:",omitempty"`
+	Volume       *volume       `json:",omitempty"`
+	Volumes      []*volume     `json:",omitempty"`
+	Capabilities *capabilities `json:",omitempty"`
+	Implements   []string      `json:",omitempty"`
+}
+
+const contentType = "application/vnd.docker.plugins.v1.2+json"
+
+// handler returns the HTTP handler serving the plugin protocol.
+func (d *driver) handler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, fn func(req *request) *response) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			req := &request{}
+			// Some requests, like List, have no body.
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+				w.Header().Set("Content-Type", contentType)
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(&response{Err: err.Error()})
+				return
+			}
+
+			res := fn(req)
+			w.Header().Set("Content-Type", contentType)
+			if res.Err != "" {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			json.NewEncoder(w).Encode(res)
+		})
+	}
+
+	handle("/Plugin.Activate", func(*request) *response {
+		return &response{Implements: []string{"VolumeDriver"}}
+	})
+	handle("/VolumeDriver.Capabilities", func(*request) *response {
+		return &response{Capabilities: &capabilities{Scope: "local"}}
+	})
+	handle("/VolumeDriver.Create", func(req *request) *response {
+		return errorResponse(d.create(req.Name, req.Opts))
+	})
+	handle("/VolumeDriver.Remove", func(req *request) *response {
+		return errorResponse(d.remove(req.Name))
+	})
+	handle("/VolumeDriver.Mount", func(req *request) *response {
+		mountpoint, err := d.mount(req.Name, req.ID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &response{Mountpoint: mountpoint}
+	})
+	handle("/VolumeDriver.Unmount", func(req *request) *response {
+		return errorResponse(d.unmount(req.Name, req.ID))
+	})
+	handle("/VolumeDriver.Path", func(req *request) *response {
+		v, err := d.get(req.Name)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &response{Mountpoint: v.Mountpoint}
+	})
+	handle("/VolumeDriver.Get", func(req *request) *response {
+		v, err := d.get(req.Name)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &response{Volume: v}
+	})
+	handle("/VolumeDriver.List", func(*request) *response {
+		volumes, err := d.list()
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &response{Volumes: volumes}
+	})
+	return mux
+}
+
+func errorResponse(err error) *response {
+	if err != nil {
+		return &response{Err: err.Error()}
+	}
+	return &response{}
+}
+
+var errVolumeNotFound = errors.New("no such volume")
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// path returns the path of the subvolume of a volume.
+func (d *driver) path(name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("invalid volume name %q", name)
+	}
+	return filepath.Join(d.root, name), nil
+}
+
+// parseSize parses a size in bytes with an optional binary unit suffix, e.g. "512M" or "10GiB".
+func parseSize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		shift  uint
+	}{{"k", 10}, {"m", 20}, {"g", 30}, {"t", 40}, {"p", 50}}
+
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "b"), "i")
+	var shift uint
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, shift = strings.TrimSuffix(value, unit.suffix), unit.shift
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 || n > ^uint64(0)>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+// parseQgroupId parses a qgroup ID in the form "level/id".
+func parseQgroupId(s string) (uint64, error) {
+	level, id, ok := strings.Cut(s, "/")
+	if ok {
+		l, err1 := strconv.ParseUint(level, 10, 16)
+		i, err2 := strconv.ParseUint(id, 10, 48)
+		if err1 == nil && err2 == nil {
+			return l<<48 | i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid qgroup %q", s)
+}
+
+// create creates the subvolume of a volume. The options are
+//
+//	size=<size>           limit the referenced space of the volume through its qgroup
+//	from-snapshot=<name>  create the volume as a snapshot of another volume
+//	qgroup=<level/id>     add the qgroup of the volume to a higher level qgroup
+func (d *driver) create(name string, opts map[string]string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	var size uint64
+	var source string
+	inherit := &btrfsutil.QgroupInherit{}
+	for key, value := range opts {
+		switch key {
+		case "size":
+			if size, err = parseSize(value); err != nil {
+				return err
+			}
+		case "from-snapshot":
+			// Only volumes of the plugin may be snapshotted, not arbitrary subvolumes of the host.
+			if source, err = d.path(value); err != nil {
+				return err
+			}
+		case "qgroup":
+			qgroupid, err := parseQgroupId(value)
+			if err != nil {
+				return err
+			}
+			if inherit, err = btrfsutil.CreateQgroupInherit(); err != nil {
+				return err
+			}
+			defer inherit.Destroy()
+			if err := inherit.AddGroup(qgroupid); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("volume %q already exists", name)
+	}
+	if source != "" {
+		if ok, _ := btrfsutil.IsSubvolume(source); !ok {
+			return fmt.Errorf("snapshot source %q: %w", opts["from-snapshot"], errVolumeNotFound)
+		}
+		err = btrfsutil.CreateSnapshotWithQgroup(source, path, false, false, inherit)
+	} else {
+		err = btrfsutil.CreateSubvolumeWithQgroup(path, inherit)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create volume %q: %w", name, err)
+	}
+
+	if size != 0 {
+		if err := btrfsutil.SetQgroupLimit(path, 0, btrfsutil.QgroupLimit{MaxReferenced: size}); err != nil {
+			btrfsutil.DeleteSubvolume(path, false)
+			return fmt.Errorf("could not limit size of volume %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (d *driver) remove(name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.mounts[name]) > 0 {
+		return fmt.Errorf("volume %q is in use", name)
+	}
+	if ok, _ := btrfsutil.IsSubvolume(path); !ok {
+		return errVolumeNotFound
+	}
+	return btrfsutil.DeleteSubvolume(path, false)
+}
+
+// mount records the mount of a volume by a container. The subvolume is
+// accessible below root already, so Docker bind mounts it directly.
+func (d *driver) mount(name string, id string) (string, error) {
+	v, err := d.get(name)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mounts[name] == nil {
+		d.mounts[name] = make(map[string]bool)
+	}
+	d.mounts[name][id] = true
+	return v.Mountpoint, nil
+}
+
+func (d *driver) unmount(name string, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.mounts[name], id)
+	if len(d.mounts[name]) == 0 {
+		delete(d.mounts, name)
+	}
+	return nil
+}
+
+func (d *driver) get(name string) (*volume, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := btrfsutil.IsSubvolume(path); !ok {
+		return nil, errVolumeNotFound
+	}
+	info, err := btrfsutil.GetSubvolumeInfo(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newVolume(name, path, info), nil
+}
+
+func newVolume(name string, path string, info *btrfsutil.SubvolumeInfo) *volume {
+	status := map[string]interface{}{
+		"id":         info.Id,
+		"uuid":       info.UUID.String(),
+		"generation": info.Generation,
+	}
+	if !info.ParentUUID.IsZero() {
+		status["parent_uuid"] = info.ParentUUID.String()
+	}
+	return &volume{
+		Name:       name,
+		Mountpoint: path,
+		CreatedAt:  info.Otime.UTC().Format(time.RFC3339),
+		Status:     status,
+	}
+}
+
+func (d *driver) list() ([]*volume, error) {
+	entries, err := os.ReadDir(d.root)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []*volume{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(d.root, entry.Name())
+		if ok, _ := btrfsutil.IsSubvolume(path); !ok {
+			continue
+		}
+		volumes = append(volumes, &volume{Name: entry.Name(), Mountpoint: path})
+	}
+	return volumes, nil
+}
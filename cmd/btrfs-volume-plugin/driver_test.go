@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint64
+		wantErr bool
+	}{
+		{"4096", 4096, false},
+		{"512k", 512 << 10, false},
+		{"10M", 10 << 20, false},
+		{"1GiB", 1 << 30, false},
+		{"2gb", 2 << 30, false},
+		{"1T", 1 << 40, false},
+		{"0", 0, true},
+		{"-1G", 0, true},
+		{"1.5G", 0, true},
+		{"G", 0, true},
+		{"16777216T", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseSize(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQgroupId(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint64
+		wantErr bool
+	}{
+		{"0/256", 256, false},
+		{"1/100", 1<<48 | 100, false},
+		{"256", 0, true},
+		{"65536/1", 0, true},
+		{"a/b", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseQgroupId(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseQgroupId() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseQgroupId() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// serve serves the plugin for root on a unix socket and returns a function to call it.
+func serve(t *testing.T, root string) func(endpoint string, req *request) (*response, int) {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: newDriver(root).handler()}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	return func(endpoint string, req *request) (*response, int) {
+		var body bytes.Buffer
+		if req != nil {
+			json.NewEncoder(&body).Encode(req)
+		}
+		r, err := client.Post("http://plugin/"+endpoint, contentType, &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+
+		res := &response{}
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+		return res, r.StatusCode
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	call := serve(t, t.TempDir())
+
+	if res, _ := call("Plugin.Activate", nil); len(res.Implements) != 1 || res.Implements[0] != "VolumeDriver" {
+		t.Errorf("Plugin.Activate = %+v", res)
+	}
+	if res, _ := call("VolumeDriver.Capabilities", nil); res.Capabilities == nil || res.Capabilities.Scope != "local" {
+		t.Errorf("VolumeDriver.Capabilities = %+v", res)
+	}
+	for _, name := range []string{"", "..", "a/b"} {
+		if res, code := call("VolumeDriver.Create", &request{Name: name}); res.Err == "" || code != http.StatusInternalServerError {
+			t.Errorf("VolumeDriver.Create(%q) = %+v, %v, want error", name, res, code)
+		}
+	}
+	if res, _ := call("VolumeDriver.Create", &request{Name: "vol", Opts: map[string]string{"color": "red"}}); res.Err == "" {
+		t.Errorf("VolumeDriver.Create() with unknown option = %+v, want error", res)
+	}
+	for _, source := range []string{"/", "/etc", "../vol", "missing"} {
+		if res, _ := call("VolumeDriver.Create", &request{Name: "vol", Opts: map[string]string{"from-snapshot": source}}); res.Err == "" {
+			t.Errorf("VolumeDriver.Create() from snapshot %q = %+v, want error", source, res)
+		}
+	}
+	if res, _ := call("VolumeDriver.List", nil); res.Err != "" || len(res.Volumes) != 0 {
+		t.Errorf("VolumeDriver.List = %+v, want no volumes", res)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	mountpoint := btrfstest.Mount(t)
+	if err := btrfsutil.SetQuotaEnabled(mountpoint, true); err != nil {
+		t.Fatal(err)
+	}
+
+	call := serve(t, mountpoint)
+
+	if res, _ := call("VolumeDriver.Create", &request{Name: "vol1", Opts: map[string]string{"size": "8M"}}); res.Err != "" {
+		t.Fatalf("VolumeDriver.Create = %+v", res)
+	}
+	res, _ := call("VolumeDriver.Mount", &request{Name: "vol1", ID: "container1"})
+	if res.Err != "" || res.Mountpoint != filepath.Join(mountpoint, "vol1") {
+		t.Fatalf("VolumeDriver.Mount = %+v", res)
+	}
+
+	// Writing beyond the size must fail once the qgroup limit is reached.
+	file, err := os.Create(filepath.Join(res.Mountpoint, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 1<<20)
+	for i := 0; i < 16 && err == nil; i++ {
+		if _, err = file.Write(chunk); err == nil {
+			err = file.Sync()
+		}
+	}
+	file.Close()
+	if !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("write beyond size error = %v, want %v", err, syscall.EDQUOT)
+	}
+	os.Remove(filepath.Join(res.Mountpoint, "data"))
+	os.WriteFile(filepath.Join(res.Mountpoint, "file"), []byte("vol1"), 0644)
+
+	if res, _ := call("VolumeDriver.Remove", &request{Name: "vol1"}); res.Err == "" {
+		t.Errorf("VolumeDriver.Remove of mounted volume = %+v, want error", res)
+	}
+	if res, _ := call("VolumeDriver.Unmount", &request{Name: "vol1", ID: "container1"}); res.Err != "" {
+		t.Errorf("VolumeDriver.Unmount = %+v", res)
+	}
+
+	if res, _ := call("VolumeDriver.Create", &request{Name: "vol2", Opts: map[string]string{"from-snapshot": filepath.Join(mountpoint, "vol1")}}); res.Err == "" {
+		t.Errorf("VolumeDriver.Create from absolute path = %+v, want error", res)
+	}
+	if res, _ := call("VolumeDriver.Create", &request{Name: "vol2", Opts: map[string]string{"from-snapshot": "vol1"}}); res.Err != "" {
+		t.Fatalf("VolumeDriver.Create from snapshot = %+v", res)
+	}
+	res, _ = call("VolumeDriver.Get", &request{Name: "vol2"})
+	if res.Err != "" || res.Volume == nil || res.Volume.Status["parent_uuid"] == nil {
+		t.Fatalf("VolumeDriver.Get = %+v", res)
+	}
+	if data, _ := os.ReadFile(filepath.Join(res.Volume.Mountpoint, "file")); string(data) != "vol1" {
+		t.Errorf("snapshot content = %q, want vol1", data)
+	}
+	if res, _ := call("VolumeDriver.Path", &request{Name: "vol2"}); res.Mountpoint != filepath.Join(mountpoint, "vol2") {
+		t.Errorf("VolumeDriver.Path = %+v", res)
+	}
+
+	if res, _ := call("VolumeDriver.List", nil); len(res.Volumes) != 2 {
+		t.Errorf("VolumeDriver.List = %+v, want 2 volumes", res)
+	}
+	for _, name := range []string{"vol1", "vol2"} {
+		if res, _ := call("VolumeDriver.Remove", &request{Name: name}); res.Err != "" {
+			t.Errorf("VolumeDriver.Remove(%v) = %+v", name, res)
+		}
+	}
+	if res, _ := call("VolumeDriver.Get", &request{Name: "vol1"}); res.Err == "" {
+		t.Errorf("VolumeDriver.Get of removed volume = %+v, want error", res)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Command btrfs-volume-plugin is a Docker volume plugin which creates every volume as a Btrfs subvolume.
+//
+// Usage:
+//
+//	btrfs-volume-plugin [-socket path] -root dir
+//
+// Volumes are subvolumes in dir, which must be on a Btrfs filesystem. They accept the options
+// size=<size>, which requires quotas to be enabled, from-snapshot=<volume> and qgroup=<level/id>.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	socket := flag.String("socket", "/run/docker/plugins/btrfs.sock", "unix socket to serve the plugin protocol on")
+	root := flag.String("root", "", "directory on a Btrfs filesystem to create the volumes in")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: btrfs-volume-plugin [-socket path] -root dir")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *root == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := os.MkdirAll(*root, 0700); err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(*socket)
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	log.Printf("serving volumes in %s on %s", *root, *socket)
+	err = http.Serve(listener, newDriver(*root).handler())
+	os.Remove(*socket)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Fatal(err)
+	}
+}
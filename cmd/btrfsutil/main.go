@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Command btrfsutil manages Btrfs subvolumes like the subvolume commands of btrfs-progs,
+// built only on libbtrfsutil-go.
+//
+// Usage:
+//
+//	btrfsutil subvolume <command> [flags] <args>
+//
+// Every command accepts --json to print its result in a stable JSON schema instead of text.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(args []string) error
+}
+
+var subvolumeCommands map[string]command
+
+func init() {
+	subvolumeCommands = map[string]command{
+		"list":        {"[-o] [--json] <path>", "List subvolumes", listCommand},
+		"show":        {"[--json] <path>", "Show information about a subvolume", showCommand},
+		"create":      {"[--json] <path>...", "Create subvolumes", createCommand},
+		"delete":      {"[-R] [--json] <path>...", "Delete subvolumes", deleteCommand},
+		"snapshot":    {"[-r] [-R] [--json] <source> <dest>", "Create a snapshot of a subvolume", snapshotCommand},
+		"get-default": {"[--json] <path>", "Show the default subvolume of a filesystem", getDefaultCommand},
+		"set-default": {"[--json] (<id> <path> | <path>)", "Set the default subvolume of a filesystem", setDefaultCommand},
+		"sync":        {"[--json] <path> [<id>...]", "Wait until deleted subvolumes are cleaned up", syncCommand},
+	}
+}
+
+// errUsage reports invalid arguments. The usage has already been printed.
+var errUsage = errors.New("invalid arguments")
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: btrfsutil subvolume <command> [flags] <args>")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+
+	var names []string
+	for name := range subvolumeCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, subvolumeCommands[name].help)
+	}
+}
+
+// newFlagSet returns the flags of a command with the --json flag defined.
+func newFlagSet(name string, json *bool) *flag.FlagSet {
+	cmd := subvolumeCommands[name]
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.BoolVar(json, "json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: btrfsutil subvolume %s %s\n\n%s.\n\n", name, cmd.usage, cmd.help)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args and checks the number of remaining arguments, max < 0 meaning no limit.
+func parseFlags(flags *flag.FlagSet, args []string, min int, max int) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 3 || (os.Args[1] != "subvolume" && os.Args[1] != "subvol") {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := subvolumeCommands[os.Args[2]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "btrfsutil: unknown command %q\n\n", os.Args[2])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[3:]); err != nil {
+		if err == errUsage {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "btrfsutil: %v\n", err)
+		os.Exit(1)
+	}
+}
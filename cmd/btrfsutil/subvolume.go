@@ -0,0 +1,379 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/schema"
+)
+
+// fsTreeObjectid is BTRFS_FS_TREE_OBJECTID, the ID of the top-level subvolume.
+const fsTreeObjectid = 5
+
+func listCommand(args []string) error {
+	var asJSON, below bool
+	flags := newFlagSet("list", &asJSON)
+	flags.BoolVar(&below, "o", false, "list only subvolumes below the subvolume containing path")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	top := uint64(fsTreeObjectid)
+	if below {
+		top = 0
+	}
+	it, err := btrfsutil.CreateSubvolumeInfoIterator(flags.Arg(0), top, false)
+	if err != nil {
+		return err
+	}
+	defer it.Destroy()
+
+	subvolumes := []*schema.Subvolume{}
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			subvolumes = append(subvolumes, schema.NewSubvolume(result.Path, result.Info))
+			continue
+		}
+		fmt.Printf("ID %d gen %d top level %d path %s\n", result.Info.Id, result.Info.Generation, result.Info.ParentId, result.Path)
+	}
+
+	if asJSON {
+		return printJSON(subvolumes)
+	}
+	return nil
+}
+
+func formatUUID(uuid btrfsutil.UUID) string {
+	if uuid.IsZero() {
+		return "-"
+	}
+	return uuid.String()
+}
+
+func formatTime(t time.Time) string {
+	if t.Unix() == 0 {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05 -0700")
+}
+
+func showCommand(args []string) error {
+	var asJSON bool
+	flags := newFlagSet("show", &asJSON)
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+
+	info, err := btrfsutil.GetSubvolumeInfo(path, 0)
+	if err != nil {
+		return err
+	}
+	subvolPath, err := btrfsutil.SubvolumePath(path, 0)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(schema.NewSubvolume(subvolPath, info))
+	}
+
+	name := filepath.Base(subvolPath)
+	if subvolPath == "" {
+		subvolPath, name = "/", "<FS_TREE>"
+	}
+	flagsText := "-"
+	if info.Flags&schema.SubvolReadOnly != 0 {
+		flagsText = "readonly"
+	}
+
+	fmt.Println(subvolPath)
+	fmt.Printf("\tName: \t\t\t%s\n", name)
+	fmt.Printf("\tUUID: \t\t\t%s\n", formatUUID(info.UUID))
+	fmt.Printf("\tParent UUID: \t\t%s\n", formatUUID(info.ParentUUID))
+	fmt.Printf("\tReceived UUID: \t\t%s\n", formatUUID(info.ReceivedUUID))
+	fmt.Printf("\tCreation time: \t\t%s\n", formatTime(info.Otime))
+	fmt.Printf("\tSubvolume ID: \t\t%d\n", info.Id)
+	fmt.Printf("\tGeneration: \t\t%d\n", info.Generation)
+	fmt.Printf("\tGen at creation: \t%d\n", info.Otransid)
+	fmt.Printf("\tParent ID: \t\t%d\n", info.ParentId)
+	fmt.Printf("\tTop level ID: \t\t%d\n", info.ParentId)
+	fmt.Printf("\tFlags: \t\t\t%s\n", flagsText)
+	fmt.Printf("\tSend transid: \t\t%d\n", info.Stransid)
+	fmt.Printf("\tSend time: \t\t%s\n", formatTime(info.Stime))
+	fmt.Printf("\tReceive transid: \t%d\n", info.Rtransid)
+	fmt.Printf("\tReceive time: \t\t%s\n", formatTime(info.Rtime))
+	return nil
+}
+
+// subvolumeJSONAt returns the JSON representation of the subvolume at path.
+func subvolumeJSONAt(path string) (*schema.Subvolume, error) {
+	info, err := btrfsutil.GetSubvolumeInfo(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	subvolPath, err := btrfsutil.SubvolumePath(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	return schema.NewSubvolume(subvolPath, info), nil
+}
+
+func createCommand(args []string) error {
+	var asJSON bool
+	flags := newFlagSet("create", &asJSON)
+	if err := parseFlags(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	created := []*schema.Subvolume{}
+	var err error
+	for _, path := range flags.Args() {
+		if err = btrfsutil.CreateSubvolume(path); err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			break
+		}
+		if !asJSON {
+			fmt.Printf("Create subvolume '%s'\n", path)
+			continue
+		}
+
+		var subvolume *schema.Subvolume
+		if subvolume, err = subvolumeJSONAt(path); err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			break
+		}
+		created = append(created, subvolume)
+	}
+
+	if asJSON {
+		if err := printJSON(created); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func deleteCommand(args []string) error {
+	var asJSON, recursive bool
+	flags := newFlagSet("delete", &asJSON)
+	flags.BoolVar(&recursive, "R", false, "delete subvolumes below the given subvolumes as well")
+	if err := parseFlags(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	results := []*deleteJSON{}
+	failed := 0
+	for _, path := range flags.Args() {
+		result := &deleteJSON{Path: path}
+		results = append(results, result)
+
+		id, err := btrfsutil.SubvolumeId(path)
+		if err == nil {
+			result.Id = id
+			err = btrfsutil.DeleteSubvolume(path, recursive)
+		}
+		if err != nil {
+			failed++
+			message := err.Error()
+			result.Error = &message
+			if !asJSON {
+				fmt.Fprintf(os.Stderr, "btrfsutil: %s: %v\n", path, err)
+			}
+			continue
+		}
+		if !asJSON {
+			fmt.Printf("Delete subvolume (no-commit): '%s'\n", path)
+		}
+	}
+
+	if asJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not delete %d of %d subvolumes", failed, len(results))
+	}
+	return nil
+}
+
+func snapshotCommand(args []string) error {
+	var asJSON, readOnly, recursive bool
+	flags := newFlagSet("snapshot", &asJSON)
+	flags.BoolVar(&readOnly, "r", false, "create a read-only snapshot")
+	flags.BoolVar(&recursive, "R", false, "snapshot subvolumes below the source as well")
+	if err := parseFlags(flags, args, 2, 2); err != nil {
+		return err
+	}
+	source, dest := flags.Arg(0), flags.Arg(1)
+
+	// Like btrfs subvolume snapshot, an existing destination directory receives a snapshot of the same name.
+	if stat, err := os.Stat(dest); err == nil && stat.IsDir() {
+		dest = filepath.Join(dest, filepath.Base(filepath.Clean(source)))
+	}
+	if err := btrfsutil.CreateSnapshot(source, dest, recursive, readOnly); err != nil {
+		return err
+	}
+
+	if asJSON {
+		subvolume, err := subvolumeJSONAt(dest)
+		if err != nil {
+			return err
+		}
+		return printJSON(subvolume)
+	}
+	if readOnly {
+		fmt.Printf("Create a readonly snapshot of '%s' in '%s'\n", source, dest)
+	} else {
+		fmt.Printf("Create a snapshot of '%s' in '%s'\n", source, dest)
+	}
+	return nil
+}
+
+// printDefault prints the default subvolume of the filesystem containing path.
+func printDefault(path string, asJSON bool) error {
+	id, err := btrfsutil.GetDefaultSubvolume(path)
+	if err != nil {
+		return err
+	}
+	info, err := btrfsutil.GetSubvolumeInfo(path, id)
+	if err != nil {
+		return err
+	}
+	subvolPath, err := btrfsutil.SubvolumePath(path, id)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(schema.NewSubvolume(subvolPath, info))
+	}
+	if id == fsTreeObjectid {
+		fmt.Printf("ID %d (FS_TREE)\n", id)
+		return nil
+	}
+	fmt.Printf("ID %d gen %d top level %d path %s\n", id, info.Generation, info.ParentId, subvolPath)
+	return nil
+}
+
+func getDefaultCommand(args []string) error {
+	var asJSON bool
+	flags := newFlagSet("get-default", &asJSON)
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	return printDefault(flags.Arg(0), asJSON)
+}
+
+func setDefaultCommand(args []string) error {
+	var asJSON bool
+	flags := newFlagSet("set-default", &asJSON)
+	if err := parseFlags(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	// With a single argument the subvolume containing path becomes the default.
+	var id uint64
+	path := flags.Arg(0)
+	if flags.NArg() == 2 {
+		var err error
+		if id, err = strconv.ParseUint(flags.Arg(0), 10, 64); err != nil {
+			return fmt.Errorf("invalid subvolume ID %q", flags.Arg(0))
+		}
+		path = flags.Arg(1)
+	}
+
+	if err := btrfsutil.SetDefaultSubvolume(path, id); err != nil {
+		return err
+	}
+	if asJSON {
+		return printDefault(path, true)
+	}
+	return nil
+}
+
+func syncCommand(args []string) error {
+	var asJSON bool
+	flags := newFlagSet("sync", &asJSON)
+	if err := parseFlags(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	var ids []uint64
+	for _, arg := range flags.Args()[1:] {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid subvolume ID %q", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var pending []uint64
+	first := true
+	err := btrfsutil.WaitForSubvolumeCleanup(ctx, flags.Arg(0), ids, func(remaining []uint64) {
+		if first {
+			pending, first = remaining, false
+		}
+		if !asJSON && len(remaining) > 0 {
+			fmt.Printf("Waiting for %d subvolumes: %s\n", len(remaining), formatIds(remaining))
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		return errors.New("interrupted")
+	}
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if pending == nil {
+			pending = []uint64{}
+		}
+		return printJSON(&syncJSON{Cleaned: pending})
+	}
+	fmt.Printf("Cleaned %d subvolumes\n", len(pending))
+	return nil
+}
+
+func formatIds(ids []uint64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatUint(id, 10)
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// jobRetention is how long finished jobs can be queried.
+const jobRetention = 10 * time.Minute
+
+// maxJobsPerPeer is how many jobs a peer which is not an admin may run at once, per uid.
+const maxJobsPerPeer = 4
+
+// Job states.
+const (
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// job is a long-running operation started by a peer.
+type job struct {
+	uid    uint32
+	key    string
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	status jobStatus
+}
+
+// jobStatus is the JSON representation of a job.
+type jobStatus struct {
+	Id       string      `json:"id"`
+	Method   string      `json:"method"`
+	State    string      `json:"state"`
+	Done     uint64      `json:"done"`
+	Total    uint64      `json:"total"`
+	Started  time.Time   `json:"started"`
+	Finished *time.Time  `json:"finished"`
+	Error    *string     `json:"error"`
+	Result   interface{} `json:"result"`
+}
+
+// progress records how much of the job is done.
+func (j *job) progress(done uint64, total uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Done, j.status.Total = done, total
+}
+
+func (j *job) get() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// jobs are the jobs of all peers. Peers only see their own jobs, admins see all jobs.
+type jobs struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	lastId uint64
+	wg     sync.WaitGroup
+}
+
+func newJobs() *jobs {
+	return &jobs{jobs: make(map[string]*job)}
+}
+
+var errTooManyJobs = &rpcError{Code: codeLimitExceeded, Message: "too many running jobs"}
+
+// start runs fn as a job of the peer and returns its status. The job is canceled with ctx.
+// key identifies what the job does: for peers which are not admins, a running job of theirs with
+// the same method and key is returned instead of starting another one, and at most maxJobsPerPeer
+// of their jobs run at once. started reports whether fn is run.
+func (js *jobs) start(ctx context.Context, p *peer, method string, key string, fn func(ctx context.Context, j *job) (interface{}, error)) (status jobStatus, started bool, err error) {
+	js.mu.Lock()
+	js.prune(time.Now())
+	if !p.admin {
+		running := 0
+		for _, j := range js.jobs {
+			status := j.get()
+			if j.uid != p.uid || status.State != jobRunning {
+				continue
+			}
+			if status.Method == method && j.key == key {
+				js.mu.Unlock()
+				return status, false, nil
+			}
+			running++
+		}
+		if running >= maxJobsPerPeer {
+			js.mu.Unlock()
+			return jobStatus{}, false, errTooManyJobs
+		}
+	}
+	js.lastId++
+	ctx, cancel := context.WithCancel(ctx)
+	j := &job{uid: p.uid, key: key, cancel: cancel, status: jobStatus{
+		Id:      strconv.FormatUint(js.lastId, 10),
+		Method:  method,
+		State:   jobRunning,
+		Started: time.Now().UTC(),
+	}}
+	js.jobs[j.status.Id] = j
+	js.mu.Unlock()
+
+	js.wg.Add(1)
+	go func() {
+		defer js.wg.Done()
+		defer cancel()
+		result, err := fn(ctx, j)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		finished := time.Now().UTC()
+		j.status.Finished = &finished
+		switch {
+		case errors.Is(err, context.Canceled):
+			j.status.State = jobCanceled
+		case err != nil:
+			j.status.State = jobFailed
+			message := err.Error()
+			j.status.Error = &message
+		default:
+			j.status.State = jobDone
+			j.status.Result = result
+		}
+	}()
+	return j.get(), true, nil
+}
+
+// prune removes jobs which finished more than jobRetention before now. js.mu must be held.
+func (js *jobs) prune(now time.Time) {
+	for id, j := range js.jobs {
+		status := j.get()
+		if status.Finished != nil && now.Sub(*status.Finished) > jobRetention {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+var errJobNotFound = &rpcError{Code: codeNotFound, Message: "no such job"}
+
+// lookup returns a job of the peer. Jobs of other peers are reported as not found.
+func (js *jobs) lookup(p *peer, id string) (*job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok || !p.admin && j.uid != p.uid {
+		return nil, errJobNotFound
+	}
+	return j, nil
+}
+
+// list returns the jobs visible to the peer ordered by ID.
+func (js *jobs) list(p *peer) []jobStatus {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.prune(time.Now())
+
+	statuses := []jobStatus{}
+	for _, j := range js.jobs {
+		if p.admin || j.uid == p.uid {
+			statuses = append(statuses, j.get())
+		}
+	}
+	sort.Slice(statuses, func(i, k int) bool {
+		a, _ := strconv.ParseUint(statuses[i].Id, 10, 64)
+		b, _ := strconv.ParseUint(statuses[k].Id, 10, 64)
+		return a < b
+	})
+	return statuses
+}
+
+// wait waits for all jobs to return.
+func (js *jobs) wait() {
+	js.wg.Wait()
+}
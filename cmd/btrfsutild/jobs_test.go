@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitJob polls a job until it is no longer running.
+func waitJob(t *testing.T, js *jobs, p *peer, id string) jobStatus {
+	for i := 0; i < 1000; i++ {
+		j, err := js.lookup(p, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := j.get(); status.State != jobRunning {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobStatus{}
+}
+
+func TestJobs(t *testing.T) {
+	js := newJobs()
+	alice, bob, admin := &peer{uid: 1000}, &peer{uid: 1001}, &peer{uid: 0, admin: true}
+
+	release := make(chan struct{})
+	running, _, _ := js.start(context.Background(), alice, "test.wait", "", func(ctx context.Context, j *job) (interface{}, error) {
+		j.progress(1, 3)
+		select {
+		case <-release:
+			j.progress(3, 3)
+			return "result", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	failing, _, _ := js.start(context.Background(), bob, "test.fail", "", func(ctx context.Context, j *job) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	canceled, _, _ := js.start(context.Background(), alice, "test.cancel", "", func(ctx context.Context, j *job) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if running.Id != "1" || running.State != jobRunning || failing.Id != "2" {
+		t.Errorf("start() = %+v, %+v", running, failing)
+	}
+
+	if _, err := js.lookup(bob, running.Id); !errors.Is(err, errJobNotFound) {
+		t.Errorf("lookup() of job of another peer error = %v, want %v", err, errJobNotFound)
+	}
+	if got := js.list(alice); len(got) != 2 || got[0].Id != "1" || got[1].Id != "3" {
+		t.Errorf("list() = %+v, want jobs 1 and 3", got)
+	}
+	if got := js.list(admin); len(got) != 3 {
+		t.Errorf("list() for admin = %+v, want all jobs", got)
+	}
+
+	status := waitJob(t, js, bob, failing.Id)
+	if status.State != jobFailed || status.Error == nil || *status.Error != "failed" || status.Finished == nil {
+		t.Errorf("failed job = %+v", status)
+	}
+
+	j, _ := js.lookup(alice, canceled.Id)
+	j.cancel()
+	if status := waitJob(t, js, alice, canceled.Id); status.State != jobCanceled {
+		t.Errorf("canceled job = %+v", status)
+	}
+
+	j, _ = js.lookup(alice, running.Id)
+	if status := j.get(); status.Done != 1 || status.Total != 3 {
+		t.Errorf("progress = %v/%v, want 1/3", status.Done, status.Total)
+	}
+	close(release)
+	status = waitJob(t, js, alice, running.Id)
+	if status.State != jobDone || status.Result != "result" || status.Done != 3 {
+		t.Errorf("done job = %+v", status)
+	}
+
+	// Finished jobs are pruned after jobRetention.
+	js.mu.Lock()
+	js.prune(time.Now().Add(jobRetention + time.Minute))
+	js.mu.Unlock()
+	if got := js.list(admin); len(got) != 0 {
+		t.Errorf("list() after prune = %+v, want none", got)
+	}
+	js.wait()
+}
+
+func TestJobsLimit(t *testing.T) {
+	js := newJobs()
+	alice, bob, admin := &peer{uid: 1000}, &peer{uid: 1001}, &peer{uid: 0, admin: true}
+
+	release := make(chan struct{})
+	wait := func(ctx context.Context, j *job) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+	var first jobStatus
+	for i := 0; i < maxJobsPerPeer; i++ {
+		status, started, err := js.start(context.Background(), alice, "test.wait", strconv.Itoa(i), wait)
+		if err != nil || !started {
+			t.Fatalf("start() = %+v, %v, %v", status, started, err)
+		}
+		if i == 0 {
+			first = status
+		}
+	}
+
+	// An identical running job is reused, even at the limit.
+	if status, started, err := js.start(context.Background(), alice, "test.wait", "0", wait); err != nil || started || status.Id != first.Id {
+		t.Errorf("start() of identical job = %+v, %v, %v, want job %v", status, started, err, first.Id)
+	}
+	if _, started, err := js.start(context.Background(), alice, "test.wait", "new", wait); err != errTooManyJobs || started {
+		t.Errorf("start() beyond limit = %v, %v, want %v", started, err, errTooManyJobs)
+	}
+	// The limit is per uid and does not apply to admins.
+	if _, started, err := js.start(context.Background(), bob, "test.wait", "0", wait); err != nil || !started {
+		t.Errorf("start() by other peer = %v, %v", started, err)
+	}
+	for i := 0; i <= maxJobsPerPeer; i++ {
+		if _, started, err := js.start(context.Background(), admin, "test.wait", "0", wait); err != nil || !started {
+			t.Errorf("start() by admin = %v, %v", started, err)
+		}
+	}
+
+	close(release)
+	waitJob(t, js, alice, first.Id)
+	if _, started, err := js.start(context.Background(), alice, "test.wait", "0", wait); err != nil || !started {
+		t.Errorf("start() after the identical job finished = %v, %v", started, err)
+	}
+	js.wait()
+}
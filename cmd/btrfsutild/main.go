@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Command btrfsutild offers subvolume operations to unprivileged processes through a JSON-RPC 2.0 API
+// on a unix socket, so that desktop applications and containers can manage their snapshots
+// without CAP_SYS_ADMIN.
+//
+// Usage:
+//
+//	btrfsutild [-socket path] [-group name] [-owner-access=false]
+//
+// Requests and responses are JSON values, one response per line. Callers are identified by
+// SO_PEERCRED. Root and members of the admin group may call every method on every path. Other
+// callers may list the subvolumes below a subvolume and read subvolume information, limited to the
+// subvolumes they could reach with their own credentials, and modify subvolumes whose root directory they own:
+// snapshot them into directories they own, delete them from directories they own and change their
+// read-only flag. Recursive snapshots and deletions and setting the default subvolume are reserved
+// to admins. With -owner-access=false, other callers are limited to reading.
+//
+// The methods and their params are
+//
+//	subvolume.list          {"path", "below"}
+//	subvolume.info          {"path"}
+//	subvolume.snapshot      {"source", "destination", "read_only", "recursive"}
+//	subvolume.delete        {"path", "recursive"}
+//	subvolume.set_read_only {"path", "read_only"}
+//	subvolume.get_default   {"path"}
+//	subvolume.set_default   {"path", "id"}
+//	subvolume.sync          {"path", "ids"}
+//	job.get                 {"id"}
+//	job.list                {}
+//	job.cancel              {"id"}
+//
+// Paths must be absolute. Subvolumes are represented like the JSON output of btrfsutil.
+// subvolume.sync is long-running: it returns a job, whose state and progress are polled with job.get.
+// Callers only see their own jobs. Callers other than admins may run four jobs at once,
+// and get their running job back when they start an identical one.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+func main() {
+	socket := flag.String("socket", "/run/btrfsutild.sock", "unix socket to serve the API on")
+	group := flag.String("group", "", "group whose members may call every method")
+	ownerAccess := flag.Bool("owner-access", true, "allow callers to modify subvolumes they own")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: btrfsutild [-socket path] [-group name] [-owner-access=false]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	pol := policy{adminGid: -1, ownerAccess: *ownerAccess}
+	if *group != "" {
+		g, err := user.LookupGroup(*group)
+		if err != nil {
+			logger.Error("unknown group", "error", err)
+			os.Exit(1)
+		}
+		pol.adminGid, _ = strconv.Atoi(g.Gid)
+	}
+
+	os.Remove(*socket)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: *socket, Net: "unix"})
+	if err != nil {
+		logger.Error("could not listen", "error", err)
+		os.Exit(1)
+	}
+	// Access is authorized per request by the credentials of the caller.
+	if err := os.Chmod(*socket, 0666); err != nil {
+		logger.Error("could not make socket accessible", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	s := &server{policy: pol, jobs: newJobs()}
+	logger.Info("serving", "socket", *socket)
+	err = serve(ctx, listener, s, logger)
+	os.Remove(*socket)
+	s.jobs.wait()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Error("stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// serve accepts connections until the listener is closed.
+func serve(ctx context.Context, listener *net.UnixListener, s *server, logger *slog.Logger) error {
+	methods := s.methods()
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			return err
+		}
+		p, err := s.policy.newPeer(conn)
+		if err != nil {
+			logger.Warn("could not identify peer", "error", err)
+			conn.Close()
+			continue
+		}
+		go serveConn(ctx, conn, p, methods)
+	}
+}
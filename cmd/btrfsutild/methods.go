@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"syscall"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/schema"
+)
+
+// fsTreeObjectid is BTRFS_FS_TREE_OBJECTID, the ID of the top-level subvolume.
+const fsTreeObjectid = 5
+
+// server implements the methods of the API.
+type server struct {
+	policy policy
+	jobs   *jobs
+}
+
+func (s *server) methods() map[string]handler {
+	return map[string]handler{
+		"subvolume.list":          s.list,
+		"subvolume.info":          s.info,
+		"subvolume.snapshot":      s.snapshot,
+		"subvolume.delete":        s.delete,
+		"subvolume.set_read_only": s.setReadOnly,
+		"subvolume.get_default":   s.getDefault,
+		"subvolume.set_default":   s.setDefault,
+		"subvolume.sync":          s.sync,
+		"job.get":                 s.getJob,
+		"job.list":                s.listJobs,
+		"job.cancel":              s.cancelJob,
+	}
+}
+
+var errNotSubvolume = &rpcError{Code: codeInvalidParams, Message: "invalid params: path must be a subvolume"}
+
+type pathParams struct {
+	Path string `json:"path"`
+}
+
+// subvolumeAt returns the JSON representation of the subvolume open as file.
+func subvolumeAt(file uintptr) (*schema.Subvolume, error) {
+	info, err := btrfsutil.GetSubvolumeInfoFd(file, 0)
+	if err != nil {
+		return nil, err
+	}
+	path, err := btrfsutil.SubvolumePathFd(file, 0)
+	if err != nil {
+		return nil, err
+	}
+	return schema.NewSubvolume(path, info), nil
+}
+
+// list returns the subvolumes of the filesystem, or with below set, the subvolumes below path.
+// Peers which are not admins must set below and give the path of a subvolume they can reach,
+// and only get the subvolumes they could reach from it.
+func (s *server) list(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Path  string `json:"path"`
+		Below bool   `json:"below"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	if !params.Below && !p.admin {
+		return nil, errForbidden
+	}
+	dir, err := openDirAs(p, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	if !p.admin {
+		// The paths of the results are relative to the subvolume containing dir.
+		if ok, _ := btrfsutil.IsSubvolumeFd(dir.Fd()); !ok {
+			return nil, errNotSubvolume
+		}
+	}
+
+	top := uint64(fsTreeObjectid)
+	if params.Below {
+		top = 0
+	}
+	it, err := btrfsutil.CreateSubvolumeInfoIteratorFd(dir.Fd(), top, false)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Destroy()
+
+	subvolumes := []*schema.Subvolume{}
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return nil, err
+		}
+		if !p.admin {
+			subvolume, err := walkAs(p, dir, result.Path)
+			if err != nil {
+				continue
+			}
+			subvolume.Close()
+		}
+		subvolumes = append(subvolumes, schema.NewSubvolume(result.Path, result.Info))
+	}
+	return subvolumes, nil
+}
+
+// info returns a subvolume. Peers which are not admins must be able to reach it.
+func (s *server) info(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params pathParams
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	dir, err := openDirAs(p, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return subvolumeAt(dir.Fd())
+}
+
+// snapshot creates a snapshot of the subvolume source at destination. Peers which are not admins
+// must be able to reach and own the source and the parent directory of the destination, and cannot
+// snapshot recursively, as nested subvolumes may belong to others.
+func (s *server) snapshot(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		ReadOnly    bool   `json:"read_only"`
+		Recursive   bool   `json:"recursive"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	if params.Recursive && !p.admin {
+		return nil, errForbidden
+	}
+	parentPath, name, err := splitPath(params.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := openDirAs(p, params.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	// Owning a directory does not make it a subvolume others may snapshot.
+	if ok, _ := btrfsutil.IsSubvolumeFd(source.Fd()); !ok {
+		return nil, errNotSubvolume
+	}
+	if err := s.policy.mayModify(p, source); err != nil {
+		return nil, err
+	}
+	parent, err := openDirAs(p, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+	if err := s.policy.mayModify(p, parent); err != nil {
+		return nil, err
+	}
+
+	if err := btrfsutil.CreateSnapshotFd2(source.Fd(), parent.Fd(), name, params.Recursive, params.ReadOnly); err != nil {
+		return nil, err
+	}
+	snapshot, err := walkAs(p, parent, name)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+	return subvolumeAt(snapshot.Fd())
+}
+
+// delete deletes a subvolume. Peers which are not admins must be able to reach and own it and its
+// parent directory, and cannot delete recursively.
+func (s *server) delete(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Path      string `json:"path"`
+		Recursive bool   `json:"recursive"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	if params.Recursive && !p.admin {
+		return nil, errForbidden
+	}
+	parentPath, name, err := splitPath(params.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := openDirAs(p, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+	if err := s.policy.mayModify(p, parent); err != nil {
+		return nil, err
+	}
+	subvolume, err := walkAs(p, parent, name)
+	if err != nil {
+		return nil, err
+	}
+	err = s.policy.mayModify(p, subvolume)
+	var id uint64
+	if err == nil {
+		id, err = btrfsutil.SubvolumeIdFd(subvolume.Fd())
+	}
+	subvolume.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := btrfsutil.DeleteSubvolumeFd(parent.Fd(), name, params.Recursive); err != nil {
+		return nil, err
+	}
+	return struct {
+		Path string `json:"path"`
+		Id   uint64 `json:"id"`
+	}{params.Path, id}, nil
+}
+
+// setReadOnly sets whether a subvolume is read-only. Peers which are not admins must be able to
+// reach and own it.
+func (s *server) setReadOnly(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Path     string `json:"path"`
+		ReadOnly bool   `json:"read_only"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	dir, err := openDirAs(p, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	if err := s.policy.mayModify(p, dir); err != nil {
+		return nil, err
+	}
+
+	if err := btrfsutil.SetSubvolumeReadOnlyFd(dir.Fd(), params.ReadOnly); err != nil {
+		return nil, err
+	}
+	return subvolumeAt(dir.Fd())
+}
+
+type defaultResult struct {
+	Id uint64 `json:"id"`
+}
+
+func (s *server) getDefault(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params pathParams
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	dir, err := openDir(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	id, err := btrfsutil.GetDefaultSubvolumeFd(dir.Fd())
+	if err != nil {
+		return nil, err
+	}
+	return defaultResult{id}, nil
+}
+
+// setDefault sets the default subvolume to the subvolume with the given ID,
+// or if it is zero, the subvolume containing path. Only admins may call it.
+func (s *server) setDefault(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Path string `json:"path"`
+		Id   uint64 `json:"id"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	if err := requireAdmin(p); err != nil {
+		return nil, err
+	}
+	dir, err := openDir(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	if err := btrfsutil.SetDefaultSubvolumeFd(dir.Fd(), params.Id); err != nil {
+		return nil, err
+	}
+	id, err := btrfsutil.GetDefaultSubvolumeFd(dir.Fd())
+	if err != nil {
+		return nil, err
+	}
+	return defaultResult{id}, nil
+}
+
+// sync starts a job waiting until the given deleted subvolumes, or all currently deleted ones,
+// are cleaned up. Its progress counts the cleaned up subvolumes. Peers which are not admins
+// get their running job if it waits for the same subvolumes.
+func (s *server) sync(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Path string   `json:"path"`
+		Ids  []uint64 `json:"ids"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	dir, err := openDir(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	ids := params.Ids
+	if len(ids) == 0 {
+		if ids, err = btrfsutil.DeletedSubvolumesFd(dir.Fd()); err != nil {
+			dir.Close()
+			return nil, err
+		}
+	}
+
+	// Syncs of the same subvolumes of the same filesystem are identical.
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(dir.Fd()), &stat); err != nil {
+		dir.Close()
+		return nil, err
+	}
+	key := fmt.Sprint(stat.Dev, ids)
+
+	// The job refers to the directory through its file descriptor,
+	// as the path given by the peer may be changed meanwhile.
+	path := "/proc/self/fd/" + strconv.Itoa(int(dir.Fd()))
+	status, started, err := s.jobs.start(ctx, p, "subvolume.sync", key, func(ctx context.Context, j *job) (interface{}, error) {
+		defer dir.Close()
+		total := uint64(len(ids))
+		j.progress(0, total)
+		err := btrfsutil.WaitForSubvolumeCleanup(ctx, path, ids, func(remaining []uint64) {
+			j.progress(total-uint64(len(remaining)), total)
+		})
+		if err != nil {
+			return nil, err
+		}
+		j.progress(total, total)
+		return struct {
+			Cleaned []uint64 `json:"cleaned"`
+		}{ids}, nil
+	})
+	if !started {
+		dir.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (s *server) getJob(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Id string `json:"id"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	j, err := s.jobs.lookup(p, params.Id)
+	if err != nil {
+		return nil, err
+	}
+	return j.get(), nil
+}
+
+func (s *server) listJobs(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	if err := decodeParams(raw, &struct{}{}); err != nil {
+		return nil, err
+	}
+	return s.jobs.list(p), nil
+}
+
+func (s *server) cancelJob(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Id string `json:"id"`
+	}
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	j, err := s.jobs.lookup(p, params.Id)
+	if err != nil {
+		return nil, err
+	}
+	j.cancel()
+	return j.get(), nil
+}
@@ -0,0 +1,364 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/schema"
+)
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		wantDir  string
+		wantName string
+		wantErr  bool
+	}{
+		{"/mnt/snap", "/mnt/", "snap", false},
+		{"/mnt/snap/", "/mnt/", "snap", false},
+		{"/mnt/a/../snap", "/mnt/", "snap", false},
+		{"snap", "", "", true},
+		{"/", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			dir, name, err := splitPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("splitPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if dir != tt.wantDir || name != tt.wantName {
+				t.Errorf("splitPath() = %q, %q, want %q, %q", dir, name, tt.wantDir, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	dir, err := openDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	owner := uint32(os.Getuid())
+
+	tests := []struct {
+		name   string
+		policy policy
+		peer   *peer
+		want   error
+	}{
+		{"owner", policy{ownerAccess: true}, &peer{uid: owner}, nil},
+		{"other", policy{ownerAccess: true}, &peer{uid: owner + 1}, errForbidden},
+		{"admin", policy{}, &peer{uid: owner + 1, admin: true}, nil},
+		{"no owner access", policy{}, &peer{uid: owner}, errForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.mayModify(tt.peer, dir); got != tt.want {
+				t.Errorf("mayModify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := openDir("relative"); err != errRelativePath {
+		t.Errorf("openDir() of relative path error = %v, want %v", err, errRelativePath)
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	os.Symlink(dir.Name(), link)
+	if _, err := openDir(link); err == nil {
+		t.Errorf("openDir() of symbolic link succeeded")
+	}
+}
+
+func TestWalkAs(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "open", "sub"), 0755)
+	os.MkdirAll(filepath.Join(base, "closed", "sub"), 0755)
+	os.Chmod(filepath.Join(base, "closed"), 0700)
+	os.Symlink("open", filepath.Join(base, "link"))
+	dir, err := openDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	owner, other := &peer{uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}, &peer{uid: uint32(os.Getuid()) + 1, gid: 54321}
+
+	tests := []struct {
+		name    string
+		peer    *peer
+		rel     string
+		wantErr bool
+	}{
+		{"open", other, "open/sub", false},
+		{"closed", other, "closed/sub", true},
+		{"closed itself", other, "closed", false},
+		{"closed by owner", owner, "closed/sub", false},
+		{"symbolic link", owner, "link/sub", true},
+		{"missing", owner, "open/missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walkAs(tt.peer, dir, tt.rel)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("walkAs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			defer got.Close()
+			if got.Name() != filepath.Join(base, tt.rel) {
+				t.Errorf("walkAs() = %v, want %v", got.Name(), filepath.Join(base, tt.rel))
+			}
+		})
+	}
+
+	if got, err := openDirAs(other, filepath.Join(base, "closed", "sub")); err != errForbidden {
+		if got != nil {
+			got.Close()
+		}
+		t.Errorf("openDirAs() error = %v, want %v", err, errForbidden)
+	}
+}
+
+func TestNewPeer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "btrfsutild.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := (&policy{adminGid: -1}).newPeer(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.uid != uint32(os.Getuid()) || p.pid != int32(os.Getpid()) || p.admin != (os.Getuid() == 0) {
+		t.Errorf("newPeer() = %+v", p)
+	}
+	if p, _ := (&policy{adminGid: os.Getgid()}).newPeer(conn); !p.admin {
+		t.Errorf("newPeer() of admin group member = %+v, want admin", p)
+	}
+}
+
+// rpcClient calls methods over a connection to btrfsutild.
+type rpcClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	id     int
+}
+
+func (c *rpcClient) call(method string, params interface{}, result interface{}) error {
+	c.id++
+	request, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": c.id})
+	if _, err := c.conn.Write(append(request, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.Unmarshal(line, &response); err != nil {
+		c.t.Fatal(err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result != nil {
+		return json.Unmarshal(response.Result, result)
+	}
+	return nil
+}
+
+func TestDaemon(t *testing.T) {
+	mountpoint := btrfstest.Mount(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &server{policy: policy{adminGid: -1, ownerAccess: true}, jobs: newJobs()}
+	socket := filepath.Join(t.TempDir(), "btrfsutild.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serve(ctx, listener, s, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &rpcClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	volume := filepath.Join(mountpoint, "volume")
+	if err := btrfsutil.CreateSubvolume(volume); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(mountpoint, "snapshot")
+	var subvolume struct {
+		Path     string `json:"path"`
+		Id       uint64 `json:"id"`
+		ReadOnly bool   `json:"read_only"`
+	}
+	err = c.call("subvolume.snapshot", map[string]interface{}{"source": volume, "destination": snapshot, "read_only": true}, &subvolume)
+	if err != nil || subvolume.Path != "snapshot" || !subvolume.ReadOnly {
+		t.Fatalf("subvolume.snapshot = %+v, %v", subvolume, err)
+	}
+	if err := c.call("subvolume.set_read_only", map[string]interface{}{"path": snapshot, "read_only": false}, &subvolume); err != nil || subvolume.ReadOnly {
+		t.Errorf("subvolume.set_read_only = %+v, %v", subvolume, err)
+	}
+
+	var subvolumes []json.RawMessage
+	if err := c.call("subvolume.list", map[string]interface{}{"path": mountpoint}, &subvolumes); err != nil || len(subvolumes) != 2 {
+		t.Errorf("subvolume.list = %s, %v", subvolumes, err)
+	}
+	var def defaultResult
+	if err := c.call("subvolume.get_default", map[string]interface{}{"path": mountpoint}, &def); err != nil || def.Id != fsTreeObjectid {
+		t.Errorf("subvolume.get_default = %+v, %v", def, err)
+	}
+	if err := c.call("subvolume.info", map[string]interface{}{"path": filepath.Join(mountpoint, "missing")}, nil); err == nil || err.(*rpcError).Code != codeNotFound {
+		t.Errorf("subvolume.info of missing path error = %v, want not found", err)
+	}
+
+	// An unprivileged peer may only modify what it owns.
+	user := &peer{uid: 1000}
+	params := func(v interface{}) json.RawMessage {
+		data, _ := json.Marshal(v)
+		return data
+	}
+	if _, err := s.delete(ctx, user, params(map[string]interface{}{"path": snapshot})); !errors.Is(err, errForbidden) {
+		t.Errorf("delete() by other user error = %v, want %v", err, errForbidden)
+	}
+	if _, err := s.setDefault(ctx, user, params(map[string]interface{}{"path": volume})); !errors.Is(err, errForbidden) {
+		t.Errorf("setDefault() by user error = %v, want %v", err, errForbidden)
+	}
+	home := filepath.Join(mountpoint, "home")
+	os.Mkdir(home, 0755)
+	os.Chown(home, 1000, 1000)
+	os.Chown(volume, 1000, 1000)
+	userSnapshot := filepath.Join(home, "snapshot")
+	if _, err := s.snapshot(ctx, user, params(map[string]interface{}{"source": volume, "destination": userSnapshot})); err != nil {
+		t.Errorf("snapshot() by owner error = %v", err)
+	}
+	if _, err := s.snapshot(ctx, user, params(map[string]interface{}{"source": volume, "destination": filepath.Join(mountpoint, "other")})); !errors.Is(err, errForbidden) {
+		t.Errorf("snapshot() into directory of other user error = %v, want %v", err, errForbidden)
+	}
+	if _, err := s.delete(ctx, user, params(map[string]interface{}{"path": userSnapshot})); err != nil {
+		t.Errorf("delete() by owner error = %v", err)
+	}
+	if _, err := s.snapshot(ctx, user, params(map[string]interface{}{"source": home, "destination": userSnapshot})); err == nil || err.(*rpcError).Code != codeInvalidParams {
+		t.Errorf("snapshot() of directory error = %v, want invalid params", err)
+	}
+
+	// An unprivileged peer only sees the subvolumes it could reach.
+	if _, err := s.list(ctx, user, params(map[string]interface{}{"path": mountpoint})); !errors.Is(err, errForbidden) {
+		t.Errorf("list() of filesystem by user error = %v, want %v", err, errForbidden)
+	}
+	private := filepath.Join(mountpoint, "private")
+	os.Mkdir(private, 0700)
+	if err := btrfsutil.CreateSubvolume(filepath.Join(private, "secret")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.list(ctx, user, params(map[string]interface{}{"path": mountpoint, "below": true}))
+	if err != nil {
+		t.Fatalf("list() by user error = %v", err)
+	}
+	for _, subvolume := range result.([]*schema.Subvolume) {
+		if subvolume.Path == "private/secret" {
+			t.Errorf("list() by user includes unreachable subvolume %v", subvolume.Path)
+		}
+	}
+	if _, err := s.info(ctx, user, params(map[string]interface{}{"path": filepath.Join(private, "secret")})); !errors.Is(err, errForbidden) {
+		t.Errorf("info() of unreachable subvolume error = %v, want %v", err, errForbidden)
+	}
+
+	// Owning an unreachable subvolume does not allow modifying it.
+	mine := filepath.Join(private, "mine")
+	if err := btrfsutil.CreateSubvolume(mine); err != nil {
+		t.Fatal(err)
+	}
+	os.Chown(mine, 1000, 1000)
+	if _, err := s.setReadOnly(ctx, user, params(map[string]interface{}{"path": mine, "read_only": true})); !errors.Is(err, errForbidden) {
+		t.Errorf("setReadOnly() of unreachable subvolume error = %v, want %v", err, errForbidden)
+	}
+	if _, err := s.snapshot(ctx, user, params(map[string]interface{}{"source": mine, "destination": filepath.Join(home, "mine")})); !errors.Is(err, errForbidden) {
+		t.Errorf("snapshot() of unreachable subvolume error = %v, want %v", err, errForbidden)
+	}
+	if _, err := s.snapshot(ctx, user, params(map[string]interface{}{"source": volume, "destination": filepath.Join(mine, "snapshot")})); !errors.Is(err, errForbidden) {
+		t.Errorf("snapshot() into unreachable directory error = %v, want %v", err, errForbidden)
+	}
+	if _, err := s.delete(ctx, user, params(map[string]interface{}{"path": mine})); !errors.Is(err, errForbidden) {
+		t.Errorf("delete() of unreachable subvolume error = %v, want %v", err, errForbidden)
+	}
+
+	var deleted struct {
+		Id uint64 `json:"id"`
+	}
+	if err := c.call("subvolume.delete", map[string]interface{}{"path": snapshot}, &deleted); err != nil || deleted.Id == 0 {
+		t.Fatalf("subvolume.delete = %+v, %v", deleted, err)
+	}
+	var status jobStatus
+	if err := c.call("subvolume.sync", map[string]interface{}{"path": mountpoint}, &status); err != nil || status.Id == "" {
+		t.Fatalf("subvolume.sync = %+v, %v", status, err)
+	}
+	for deadline := time.Now().Add(time.Minute); status.State == jobRunning && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		if err := c.call("job.get", map[string]interface{}{"id": status.Id}, &status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status.State != jobDone || status.Done != status.Total {
+		t.Errorf("sync job = %+v", status)
+	}
+	if err := c.call("job.get", map[string]interface{}{"id": "unknown"}, nil); err == nil {
+		t.Errorf("job.get of unknown job succeeded")
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// peer is the process at the other end of a connection, identified by SO_PEERCRED.
+type peer struct {
+	pid int32
+	uid uint32
+	gid uint32
+	// admin is set for root and members of the admin group, who may use every method on every path.
+	admin bool
+}
+
+// policy decides what peers which are not admins may do.
+type policy struct {
+	// adminGid is the group whose members are admins, or -1 for none.
+	adminGid int
+	// ownerAccess allows peers to modify subvolumes whose root directory they own,
+	// and to create snapshots in directories they own.
+	ownerAccess bool
+}
+
+// newPeer returns the credentials of the process connected to conn.
+func (pol *policy) newPeer(conn *net.UnixConn) (*peer, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	p := &peer{pid: cred.Pid, uid: cred.Uid, gid: cred.Gid}
+	p.admin = p.uid == 0 || pol.adminGid >= 0 && p.inGroup(pol.adminGid)
+	return p, nil
+}
+
+// inGroup reports whether the peer is a member of the group, by its primary group
+// or the supplementary groups of its user.
+func (p *peer) inGroup(gid int) bool {
+	if int(p.gid) == gid {
+		return true
+	}
+	u, err := user.LookupId(strconv.FormatUint(uint64(p.uid), 10))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, group := range groups {
+		if group == strconv.Itoa(gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayModify returns errForbidden unless the peer may modify the directory open as file.
+func (pol *policy) mayModify(p *peer, file *os.File) error {
+	if p.admin {
+		return nil
+	}
+	if !pol.ownerAccess {
+		return errForbidden
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != p.uid {
+		return errForbidden
+	}
+	return nil
+}
+
+// requireAdmin returns errForbidden unless the peer is an admin.
+func requireAdmin(p *peer) error {
+	if !p.admin {
+		return errForbidden
+	}
+	return nil
+}
+
+// maySearch reports whether the peer may search the directory described by stat. Like the kernel
+// for a process with the credentials of the peer, but only by the mode bits, without ACLs.
+func (p *peer) maySearch(stat *syscall.Stat_t) bool {
+	switch {
+	case p.admin:
+		return true
+	case stat.Uid == p.uid:
+		return stat.Mode&0100 != 0
+	case p.inGroup(int(stat.Gid)):
+		return stat.Mode&0010 != 0
+	}
+	return stat.Mode&0001 != 0
+}
+
+// walkAs opens the directory rel below base one element at a time and returns errForbidden
+// unless the peer could reach it with its own credentials. Symbolic links are not followed.
+func walkAs(p *peer, base *os.File, rel string) (*os.File, error) {
+	fd, err := syscall.Openat(int(base.Fd()), ".", syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, notFound(base.Name(), err)
+	}
+	path := base.Name()
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		var stat syscall.Stat_t
+		if err := syscall.Fstat(fd, &stat); err != nil || !p.maySearch(&stat) {
+			syscall.Close(fd)
+			return nil, errForbidden
+		}
+		path = filepath.Join(path, elem)
+		next, err := syscall.Openat(fd, elem, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return nil, notFound(path, err)
+		}
+		fd = next
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// openDirAs opens a directory given by a peer like openDir, but for peers which are not admins
+// only if they could reach it with their own credentials.
+func openDirAs(p *peer, path string) (*os.File, error) {
+	if p.admin || !filepath.IsAbs(path) {
+		return openDir(path)
+	}
+	root, err := openDir("/")
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return walkAs(p, root, filepath.Clean(path))
+}
+
+var errRelativePath = &rpcError{Code: codeInvalidParams, Message: "invalid params: path must be absolute"}
+
+// openDir opens a directory given by a peer. All checks and operations use the returned file
+// instead of the path, so that a peer cannot swap the directory after it was checked.
+func openDir(path string) (*os.File, error) {
+	if !filepath.IsAbs(path) {
+		return nil, errRelativePath
+	}
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, notFound(path, err)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+func notFound(path string, err error) error {
+	if errors.Is(err, syscall.ENOENT) {
+		return &rpcError{Code: codeNotFound, Message: path + ": no such file or directory"}
+	}
+	return &os.PathError{Op: "open", Path: path, Err: err}
+}
+
+// splitPath splits an absolute path into its parent directory and a valid last element.
+func splitPath(path string) (string, string, error) {
+	if !filepath.IsAbs(path) {
+		return "", "", errRelativePath
+	}
+	dir, name := filepath.Split(filepath.Clean(path))
+	if name == "" || name == "." || name == ".." {
+		return "", "", &rpcError{Code: codeInvalidParams, Message: "invalid params: invalid path " + path}
+	}
+	return dir, name, nil
+}
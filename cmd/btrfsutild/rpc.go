@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes. Codes from -32099 to -32000 are defined by the daemon.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeFailed         = -32000
+	codeForbidden      = -32001
+	codeNotFound       = -32002
+	codeLimitExceeded  = -32003
+)
+
+// rpcError is a JSON-RPC error object.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+var errForbidden = &rpcError{Code: codeForbidden, Message: "permission denied"}
+
+func invalidParams(err error) *rpcError {
+	return &rpcError{Code: codeInvalidParams, Message: "invalid params: " + err.Error()}
+}
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// handler handles a method call of a peer. A returned *rpcError is sent as is,
+// any other error is reported with codeFailed.
+type handler func(ctx context.Context, p *peer, params json.RawMessage) (interface{}, error)
+
+// decodeParams decodes the params of a call into v, rejecting unknown fields.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		params = []byte("{}")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return invalidParams(err)
+	}
+	return nil
+}
+
+// serveConn reads requests from conn until it is closed and writes a response per line.
+// Batches are supported. Calls of a connection are handled in order.
+func serveConn(ctx context.Context, conn net.Conn, p *peer, methods map[string]handler) {
+	defer conn.Close()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	var mu sync.Mutex
+	write := func(v interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		data, _ := json.Marshal(v)
+		conn.Write(append(data, '\n'))
+	}
+
+	for {
+		var message json.RawMessage
+		if err := decoder.Decode(&message); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				var syntaxErr *json.SyntaxError
+				if errors.As(err, &syntaxErr) {
+					write(&rpcResponse{Version: "2.0", Error: &rpcError{Code: codeParseError, Message: err.Error()}, Id: json.RawMessage("null")})
+				}
+			}
+			return
+		}
+
+		trimmed := bytes.TrimSpace(message)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+				write(&rpcResponse{Version: "2.0", Error: &rpcError{Code: codeInvalidRequest, Message: "invalid batch"}, Id: json.RawMessage("null")})
+				continue
+			}
+			var responses []*rpcResponse
+			for _, call := range batch {
+				if res := handle(ctx, p, methods, call); res != nil {
+					responses = append(responses, res)
+				}
+			}
+			if len(responses) > 0 {
+				write(responses)
+			}
+			continue
+		}
+		if res := handle(ctx, p, methods, message); res != nil {
+			write(res)
+		}
+	}
+}
+
+// handle calls the method of a request. It returns nil for notifications, which have no ID.
+func handle(ctx context.Context, p *peer, methods map[string]handler, message json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(message, &req); err != nil || req.Version != "2.0" || req.Method == "" {
+		return &rpcResponse{Version: "2.0", Error: &rpcError{Code: codeInvalidRequest, Message: "invalid request"}, Id: json.RawMessage("null")}
+	}
+
+	res := &rpcResponse{Version: "2.0", Id: req.Id}
+	if fn, ok := methods[req.Method]; !ok {
+		res.Error = &rpcError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+	} else if result, err := fn(ctx, p, req.Params); err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: codeFailed, Message: err.Error()}
+		}
+		res.Error = rpcErr
+	} else {
+		// A successful response must have a result member.
+		if result == nil {
+			result = struct{}{}
+		}
+		res.Result = result
+	}
+
+	if req.Id == nil {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+)
+
+// call sends a raw message over a connection served with methods and returns the raw response line.
+func call(t *testing.T, methods map[string]handler, messages ...string) []string {
+	client, server := net.Pipe()
+	go serveConn(context.Background(), server, &peer{uid: 1000}, methods)
+	defer client.Close()
+
+	go func() {
+		for _, message := range messages {
+			client.Write([]byte(message))
+		}
+	}()
+	reader := bufio.NewReader(client)
+	var responses []string
+	for range messages {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, line[:len(line)-1])
+	}
+	return responses
+}
+
+func TestServeConn(t *testing.T) {
+	methods := map[string]handler{
+		"echo": func(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+			var params struct {
+				Value string `json:"value"`
+			}
+			if err := decodeParams(raw, &params); err != nil {
+				return nil, err
+			}
+			return params.Value, nil
+		},
+		"void": func(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+			return nil, nil
+		},
+		"fail": func(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+			return nil, errors.New("failed")
+		},
+		"forbidden": func(ctx context.Context, p *peer, raw json.RawMessage) (interface{}, error) {
+			return nil, errForbidden
+		},
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{"result", `{"jsonrpc": "2.0", "method": "echo", "params": {"value": "hi"}, "id": 1}`,
+			`{"jsonrpc":"2.0","result":"hi","id":1}`},
+		{"empty result", `{"jsonrpc": "2.0", "method": "void", "id": "a"}`,
+			`{"jsonrpc":"2.0","result":{},"id":"a"}`},
+		{"error", `{"jsonrpc": "2.0", "method": "fail", "id": 2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"failed"},"id":2}`},
+		{"rpc error", `{"jsonrpc": "2.0", "method": "forbidden", "id": 3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32001,"message":"permission denied"},"id":3}`},
+		{"unknown method", `{"jsonrpc": "2.0", "method": "nope", "id": 4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: nope"},"id":4}`},
+		{"invalid params", `{"jsonrpc": "2.0", "method": "echo", "params": {"other": 1}, "id": 5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: json: unknown field \"other\""},"id":5}`},
+		{"invalid request", `{"method": "echo", "id": 6}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`},
+		{"batch", `[{"jsonrpc": "2.0", "method": "echo", "params": {"value": "a"}, "id": 1}, {"jsonrpc": "2.0", "method": "void"}, {"jsonrpc": "2.0", "method": "fail", "id": 2}]`,
+			`[{"jsonrpc":"2.0","result":"a","id":1},{"jsonrpc":"2.0","error":{"code":-32000,"message":"failed"},"id":2}]`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid batch"},"id":null}`},
+		{"parse error", `{"jsonrpc": ` + "\n}",
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid character '}' looking for beginning of value"},"id":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := call(t, methods, tt.request)[0]; got != tt.want {
+				t.Errorf("response = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Notifications have no response, so the next response belongs to the following call.
+	responses := call(t, methods, `{"jsonrpc": "2.0", "method": "fail"}`+"\n"+`{"jsonrpc": "2.0", "method": "void", "id": 7}`)
+	if responses[0] != `{"jsonrpc":"2.0","result":{},"id":7}` {
+		t.Errorf("response after notification = %s", responses[0])
+	}
+}
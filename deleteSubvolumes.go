@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeleteSubvolumesOptions configures DeleteSubvolumes.
+type DeleteSubvolumesOptions struct {
+	// Concurrency is the number of subvolumes deleted in parallel. Defaults to 1.
+	Concurrency int
+	// Recursive also deletes subvolumes nested below the given subvolumes.
+	Recursive bool
+	// MaxPendingCleanup pauses deleting while at least this many deleted subvolumes
+	// have not been cleaned up yet. Parallel deletions may exceed it by up to Concurrency - 1.
+	// Zero disables throttling.
+	MaxPendingCleanup int
+}
+
+// DeleteSubvolumes deletes many subvolumes or snapshots by their IDs, limiting the
+// number of deleted subvolumes the cleaner has yet to process.
+// It continues after failures and returns a joined error of *fs.PathError values,
+// one for each path which could not be deleted.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func DeleteSubvolumes(ctx context.Context, paths []string, opts *DeleteSubvolumesOptions) error {
+	if opts == nil {
+		opts = &DeleteSubvolumesOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	fail := func(path string, err error) {
+		mu.Lock()
+		errs = append(errs, &fs.PathError{Op: "delete", Path: path, Err: err})
+		mu.Unlock()
+	}
+
+	throttle := &cleanupThrottle{max: opts.MaxPendingCleanup}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				if err := deleteSubvolumeById(ctx, path, opts.Recursive, throttle); err != nil {
+					fail(path, err)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i, path := range paths {
+		select {
+		case <-ctx.Done():
+			for _, path := range paths[i:] {
+				fail(path, ctx.Err())
+			}
+			break dispatch
+		case jobs <- path:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// deleteSubvolumeById deletes the subvolume at path and, if recursive is set,
+// the subvolumes below it with DeleteSubvolumeByIdFd.
+func deleteSubvolumeById(ctx context.Context, path string, recursive bool, throttle *cleanupThrottle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if ok, err := IsSubvolume(path); !ok {
+		if err == nil {
+			err = ErrNotSubvolume
+		}
+		return err
+	}
+	id, err := SubvolumeId(path)
+	if err != nil {
+		return err
+	}
+
+	// Nested subvolumes are deleted children first, relative to their own parent directory.
+	type subvolume struct {
+		path string
+		id   uint64
+	}
+	var subvolumes []subvolume
+	if recursive {
+		it, err := CreateSubvolumeIterator(path, 0, true)
+		if err != nil {
+			return err
+		}
+		for it.HasNext() {
+			result, err := it.GetNext()
+			if err != nil {
+				it.Destroy()
+				return err
+			}
+			subvolumes = append(subvolumes, subvolume{filepath.Join(path, result.Path), result.Id})
+		}
+		it.Destroy()
+	}
+	subvolumes = append(subvolumes, subvolume{path, id})
+
+	for _, subvol := range subvolumes {
+		if err := throttle.wait(ctx, subvol.path); err != nil {
+			return err
+		}
+
+		parent, err := os.Open(filepath.Dir(subvol.path))
+		if err != nil {
+			return ErrOpenFailed
+		}
+		err = DeleteSubvolumeByIdFd(parent.Fd(), subvol.id)
+		parent.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupThrottle blocks deletions while too many deleted subvolumes are pending cleanup.
+type cleanupThrottle struct {
+	max int
+
+	mu sync.Mutex
+}
+
+// wait returns once fewer than max deleted subvolumes of the filesystem containing path are pending cleanup.
+// Callers are serialized, so that only one of them polls DeletedSubvolumes.
+func (t *cleanupThrottle) wait(ctx context.Context, path string) error {
+	if t.max <= 0 {
+		return ctx.Err()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	interval := cleanupPollMin
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		deleted, err := DeletedSubvolumes(path)
+		if err != nil {
+			return err
+		}
+		if len(deleted) < t.max {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > cleanupPollMax {
+			interval = cleanupPollMax
+		}
+	}
+}
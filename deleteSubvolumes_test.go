@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestDeleteSubvolumesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	paths := []string{"/a", "/b", "/c"}
+	err := DeleteSubvolumes(ctx, paths, &DeleteSubvolumesOptions{Concurrency: 2})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DeleteSubvolumes() error = %v, want %v", err, context.Canceled)
+	}
+
+	var failed []string
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) {
+			t.Fatalf("DeleteSubvolumes() error %v is not a *fs.PathError", err)
+		}
+		failed = append(failed, pathErr.Path)
+	}
+	if len(failed) != len(paths) {
+		t.Errorf("DeleteSubvolumes() failed paths = %v, want %v", failed, paths)
+	}
+}
+
+func TestDeleteSubvolumes(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	var paths []string
+	for i := 0; i < 8; i++ {
+		subvol := filepath.Join(mountpoint.path, "subvol"+strconv.Itoa(i))
+		if CreateSubvolume(subvol) != nil {
+			t.Error("Failed to create subvolumes")
+		}
+		paths = append(paths, subvol)
+	}
+	nested := filepath.Join(paths[0], "nested")
+	if CreateSubvolume(nested) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	missing := filepath.Join(mountpoint.path, "missing")
+	paths = append(paths, missing)
+
+	err = DeleteSubvolumes(context.Background(), paths, &DeleteSubvolumesOptions{
+		Concurrency:       4,
+		Recursive:         true,
+		MaxPendingCleanup: 2,
+	})
+
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != missing {
+		t.Errorf("DeleteSubvolumes() error = %v, want error for %v", err, missing)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("DeleteSubvolumes() did not delete %v", path)
+		}
+	}
+}
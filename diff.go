@@ -0,0 +1,603 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ChangeKind is the kind of a Change between two snapshots.
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeModified
+	ChangeDeleted
+	ChangeRenamed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeRenamed:
+		return "renamed"
+	}
+	return "unknown"
+}
+
+// ByteRange is a range of bytes within a file.
+type ByteRange struct {
+	Offset uint64
+	Length uint64
+}
+
+// Change is a single difference between two snapshots.
+type Change struct {
+	// Path is the path relative to the new snapshot, or to the old snapshot for ChangeDeleted.
+	Path string
+	Kind ChangeKind
+	// OldPath is the path relative to the old snapshot if it differs from Path,
+	// e.g. for renamed files or files within a renamed directory.
+	OldPath string
+	// Ranges are the changed byte ranges of a file, if known.
+	Ranges []ByteRange
+}
+
+// DiffIterator iterates over the changes between two snapshots, ordered by path component by component.
+type DiffIterator struct {
+	lastResult *Change
+	lastErr    error
+
+	changes chan *Change
+	err     chan error
+	cancel  context.CancelFunc
+}
+
+// Diff compares two snapshots and returns an iterator over the added, modified,
+// deleted and renamed files.
+// If both snapshots are read-only and newSnap is related to oldSnap (one is a snapshot of
+// the other or they share a parent), changes are derived from a send stream without file data.
+// Otherwise both trees are walked and compared by file type, size, mode and modification time;
+// in that case renames are reported as deletions and additions and Ranges are not set.
+// Changes to timestamps alone are not reported.
+// Changes of a tree walk are returned as they are found. Changes derived from a send stream are
+// returned once the stream has ended, as later commands may still rename earlier paths.
+// The returned DiffIterator must be freed with Destroy(), which also stops a running send.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func Diff(ctx context.Context, oldSnap string, newSnap string) (*DiffIterator, error) {
+	related, err := snapshotsRelated(oldSnap, newSnap)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	it := &DiffIterator{
+		changes: make(chan *Change),
+		err:     make(chan error, 1),
+		cancel:  cancel,
+	}
+
+	go func() {
+		defer close(it.changes)
+		emit := func(change *Change) error {
+			select {
+			case it.changes <- change:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var err error
+		if related {
+			err = streamDiff(ctx, oldSnap, newSnap, emit)
+		} else {
+			err = treeDiff(ctx, oldSnap, newSnap, emit)
+		}
+		if err != nil {
+			it.err <- err
+		}
+	}()
+	return it, nil
+}
+
+// Destroy stops the comparison and releases the DiffIterator.
+func (it *DiffIterator) Destroy() {
+	it.cancel()
+	for range it.changes {
+	}
+}
+
+// HasNext returns true if the DiffIterator has a next value.
+func (it *DiffIterator) HasNext() bool {
+	change, ok := <-it.changes
+	if !ok {
+		it.lastResult = nil
+		select {
+		case it.lastErr = <-it.err:
+		default:
+			it.lastErr = ErrStopIteration
+		}
+		return it.lastErr != ErrStopIteration
+	}
+
+	it.lastResult, it.lastErr = change, nil
+	return true
+}
+
+// GetNext gets the next Change from a DiffIterator.
+func (it *DiffIterator) GetNext() (*Change, error) {
+	if it.lastErr != nil {
+		return nil, it.lastErr
+	}
+	return it.lastResult, it.lastErr
+}
+
+// snapshotsRelated reports whether a send stream can describe the changes from oldSnap to newSnap.
+func snapshotsRelated(oldSnap string, newSnap string) (bool, error) {
+	oldInfo, err := GetSubvolumeInfo(oldSnap, 0)
+	if err != nil {
+		return false, err
+	}
+	newInfo, err := GetSubvolumeInfo(newSnap, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if oldInfo.Flags&C.BTRFS_ROOT_SUBVOL_RDONLY == 0 || newInfo.Flags&C.BTRFS_ROOT_SUBVOL_RDONLY == 0 {
+		return false, nil
+	}
+
+	switch {
+	case oldInfo.UUID == newInfo.UUID:
+		return false, nil
+	case oldInfo.UUID == newInfo.ParentUUID, oldInfo.ParentUUID == newInfo.UUID:
+		return true, nil
+	case !oldInfo.ParentUUID.IsZero() && oldInfo.ParentUUID == newInfo.ParentUUID:
+		return true, nil
+	}
+	return false, nil
+}
+
+// streamDiff derives the changes from an incremental send stream without file data
+// and passes them to emit in path order.
+func streamDiff(ctx context.Context, oldSnap string, newSnap string, emit func(*Change) error) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Send ignores ctx when writing to a file, so closing the read end is what stops it, with EPIPE.
+	done := make(chan error, 1)
+	go func() {
+		done <- Send(ctx, newSnap, w, &SendOptions{Parent: oldSnap, NoData: true})
+		w.Close()
+	}()
+	read := make(chan struct{})
+	defer close(read)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-read:
+		}
+	}()
+
+	diff := newSendDiff()
+	stream := newSendStreamReader(r)
+	for {
+		cmd, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = diff.apply(cmd)
+		}
+		if err != nil {
+			r.Close()
+			<-done
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+	}
+
+	if err := <-done; err != nil {
+		return err
+	}
+	for _, change := range diff.changes() {
+		if err := emit(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntry tracks an inode touched by a send stream under its current path.
+type diffEntry struct {
+	oldPath  string
+	created  bool
+	renamed  bool
+	modified bool
+	ranges   []ByteRange
+}
+
+// sendDiff reconstructs file level changes from the commands of an incremental send stream.
+type sendDiff struct {
+	entries map[string]*diffEntry
+	deleted []string
+}
+
+func newSendDiff() *sendDiff {
+	return &sendDiff{entries: make(map[string]*diffEntry)}
+}
+
+// orphanName matches the temporary names used by send for inodes without a final path yet.
+var orphanName = regexp.MustCompile(`^o\d+-\d+-\d+$`)
+
+// origin returns the path in the old snapshot of a path in the new snapshot
+// and whether it existed in the old snapshot at all.
+func (d *sendDiff) origin(path string) (string, bool) {
+	for dir := path; dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if entry, ok := d.entries[dir]; ok {
+			if entry.created {
+				return "", false
+			}
+			return entry.oldPath + path[len(dir):], true
+		}
+	}
+	return path, true
+}
+
+// entry returns the entry of an existing path, creating it if necessary.
+func (d *sendDiff) entry(path string) *diffEntry {
+	if entry, ok := d.entries[path]; ok {
+		return entry
+	}
+	oldPath, existed := d.origin(path)
+	entry := &diffEntry{oldPath: oldPath, created: !existed}
+	d.entries[path] = entry
+	return entry
+}
+
+func (d *sendDiff) apply(cmd *sendCommand) error {
+	switch cmd.cmd {
+	case sendCmdMkfile, sendCmdMkdir, sendCmdMknod, sendCmdMkfifo, sendCmdMksock, sendCmdSymlink:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		d.entries[path] = &diffEntry{created: true}
+	case sendCmdLink:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		d.entries[path] = &diffEntry{created: true}
+	case sendCmdRename:
+		from, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		to, err := cmd.string(sendAttrPathTo)
+		if err != nil {
+			return err
+		}
+		entry := d.entry(from)
+		if !entry.created {
+			entry.renamed = true
+		}
+		delete(d.entries, from)
+		// Move the entries beneath a renamed directory along with it.
+		for path, child := range d.entries {
+			if strings.HasPrefix(path, from+"/") {
+				delete(d.entries, path)
+				d.entries[to+path[len(from):]] = child
+			}
+		}
+		d.entries[to] = entry
+	case sendCmdUnlink, sendCmdRmdir:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		entry := d.entry(path)
+		delete(d.entries, path)
+		if !entry.created {
+			d.deleted = append(d.deleted, entry.oldPath)
+		}
+	case sendCmdWrite:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		offset, err := cmd.uint64(sendAttrFileOffset)
+		if err != nil {
+			return err
+		}
+		data, err := cmd.bytes(sendAttrData)
+		if err != nil {
+			return err
+		}
+		d.entry(path).addRange(offset, uint64(len(data)))
+	case sendCmdUpdateExtent, sendCmdFallocate:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		offset, err := cmd.uint64(sendAttrFileOffset)
+		if err != nil {
+			return err
+		}
+		size, err := cmd.uint64(sendAttrSize)
+		if err != nil {
+			return err
+		}
+		d.entry(path).addRange(offset, size)
+	case sendCmdClone:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		offset, err := cmd.uint64(sendAttrFileOffset)
+		if err != nil {
+			return err
+		}
+		length, err := cmd.uint64(sendAttrCloneLen)
+		if err != nil {
+			return err
+		}
+		d.entry(path).addRange(offset, length)
+	case sendCmdEncodedWrite:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		offset, err := cmd.uint64(sendAttrFileOffset)
+		if err != nil {
+			return err
+		}
+		length, err := cmd.uint64(sendAttrUnencodedFileLen)
+		if err != nil {
+			return err
+		}
+		d.entry(path).addRange(offset, length)
+	case sendCmdTruncate, sendCmdChmod, sendCmdChown, sendCmdSetXattr, sendCmdRemoveXattr, sendCmdFileattr, sendCmdEnableVerity:
+		path, err := cmd.string(sendAttrPath)
+		if err != nil {
+			return err
+		}
+		d.entry(path).modified = true
+	}
+	return nil
+}
+
+func (e *diffEntry) addRange(offset uint64, length uint64) {
+	e.modified = true
+	if n := len(e.ranges); n > 0 && e.ranges[n-1].Offset+e.ranges[n-1].Length == offset {
+		e.ranges[n-1].Length += length
+		return
+	}
+	e.ranges = append(e.ranges, ByteRange{offset, length})
+}
+
+// changes returns the accumulated changes ordered by path.
+func (d *sendDiff) changes() []*Change {
+	var changes []*Change
+	for _, path := range d.deleted {
+		changes = append(changes, &Change{Path: path, Kind: ChangeDeleted})
+	}
+	for path, entry := range d.entries {
+		if orphanName.MatchString(path) {
+			continue
+		}
+
+		change := &Change{Path: path, Ranges: entry.ranges}
+		switch {
+		case entry.created:
+			change.Kind = ChangeAdded
+		case entry.renamed:
+			change.Kind = ChangeRenamed
+		case entry.modified:
+			change.Kind = ChangeModified
+		default:
+			continue
+		}
+		if !entry.created && entry.oldPath != path {
+			change.OldPath = entry.oldPath
+		}
+		changes = append(changes, change)
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func sortChanges(changes []*Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Path != changes[j].Path {
+			return comparePaths(changes[i].Path, changes[j].Path) < 0
+		}
+		// Report a deleted path before a new file taking its place.
+		return changes[i].Kind == ChangeDeleted && changes[j].Kind != ChangeDeleted
+	})
+}
+
+// comparePaths orders paths component by component, the order in which a tree walk visits them,
+// e.g. "a/b" before "a-c".
+func comparePaths(a string, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		switch {
+		case a[i] == '/':
+			return -1
+		case b[i] == '/':
+			return 1
+		case a[i] < b[i]:
+			return -1
+		}
+		return 1
+	}
+	return len(a) - len(b)
+}
+
+type treeEntry struct {
+	name  string
+	mode  fs.FileMode
+	size  int64
+	mtime int64
+}
+
+// readTreeDir returns the entries of a directory sorted by name.
+func readTreeDir(dir string) ([]treeEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]treeEntry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		info, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, treeEntry{d.Name(), info.Mode(), info.Size(), info.ModTime().UnixNano()})
+	}
+	return entries, nil
+}
+
+// treeDiff compares two directory trees by walking both side by side
+// and passes the changes to emit in path order as they are found.
+func treeDiff(ctx context.Context, oldSnap string, newSnap string, emit func(*Change) error) error {
+	return diffDir(ctx, oldSnap, newSnap, "", emit)
+}
+
+// diffDir compares the directory dir relative to both roots.
+func diffDir(ctx context.Context, oldRoot string, newRoot string, dir string, emit func(*Change) error) error {
+	oldEntries, err := readTreeDir(filepath.Join(oldRoot, dir))
+	if err != nil {
+		return err
+	}
+	newEntries, err := readTreeDir(filepath.Join(newRoot, dir))
+	if err != nil {
+		return err
+	}
+
+	for len(oldEntries) > 0 || len(newEntries) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch {
+		case len(newEntries) == 0 || len(oldEntries) > 0 && oldEntries[0].name < newEntries[0].name:
+			err = emitTree(ctx, oldRoot, filepath.Join(dir, oldEntries[0].name), oldEntries[0].mode, ChangeDeleted, emit)
+			oldEntries = oldEntries[1:]
+		case len(oldEntries) == 0 || newEntries[0].name < oldEntries[0].name:
+			err = emitTree(ctx, newRoot, filepath.Join(dir, newEntries[0].name), newEntries[0].mode, ChangeAdded, emit)
+			newEntries = newEntries[1:]
+		default:
+			err = diffEntries(ctx, oldRoot, newRoot, dir, oldEntries[0], newEntries[0], emit)
+			oldEntries, newEntries = oldEntries[1:], newEntries[1:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries compares an entry present in both trees.
+func diffEntries(ctx context.Context, oldRoot string, newRoot string, dir string, oldEntry treeEntry, newEntry treeEntry, emit func(*Change) error) error {
+	path := filepath.Join(dir, oldEntry.name)
+	if oldEntry.mode.Type() != newEntry.mode.Type() {
+		// The deleted entry is reported before the one taking its place, then the contents of whichever is a directory.
+		if err := emit(&Change{Path: path, Kind: ChangeDeleted}); err != nil {
+			return err
+		}
+		if err := emit(&Change{Path: path, Kind: ChangeAdded}); err != nil {
+			return err
+		}
+		if oldEntry.mode.IsDir() {
+			return emitChildren(ctx, oldRoot, path, ChangeDeleted, emit)
+		}
+		if newEntry.mode.IsDir() {
+			return emitChildren(ctx, newRoot, path, ChangeAdded, emit)
+		}
+		return nil
+	}
+
+	if oldEntry.mode.IsDir() {
+		if oldEntry.mode != newEntry.mode {
+			if err := emit(&Change{Path: path, Kind: ChangeModified}); err != nil {
+				return err
+			}
+		}
+		return diffDir(ctx, oldRoot, newRoot, path, emit)
+	}
+	if oldEntry != newEntry {
+		return emit(&Change{Path: path, Kind: ChangeModified})
+	}
+	return nil
+}
+
+// emitTree reports path and, if it is a directory, everything beneath it as added or deleted.
+func emitTree(ctx context.Context, root string, path string, mode fs.FileMode, kind ChangeKind, emit func(*Change) error) error {
+	if err := emit(&Change{Path: path, Kind: kind}); err != nil {
+		return err
+	}
+	if mode.IsDir() {
+		return emitChildren(ctx, root, path, kind, emit)
+	}
+	return nil
+}
+
+// emitChildren reports everything beneath the directory path as added or deleted.
+func emitChildren(ctx context.Context, root string, path string, kind ChangeKind, emit func(*Change) error) error {
+	dir := filepath.Join(root, path)
+	return filepath.WalkDir(dir, func(walked string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walked == dir {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, walked)
+		return emit(&Change{Path: rel, Kind: kind})
+	})
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSendDiff(t *testing.T) {
+	cmd := func(cmd uint16, attrs ...interface{}) *sendCommand {
+		c := &sendCommand{cmd: cmd, attrs: make(map[uint16][]byte)}
+		for i := 0; i < len(attrs); i += 2 {
+			switch value := attrs[i+1].(type) {
+			case string:
+				c.attrs[uint16(attrs[i].(int))] = []byte(value)
+			case int:
+				c.attrs[uint16(attrs[i].(int))] = binary.LittleEndian.AppendUint64(nil, uint64(value))
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		cmds []*sendCommand
+		want []*Change
+	}{
+		{
+			"added",
+			[]*sendCommand{
+				cmd(sendCmdMkfile, sendAttrPath, "o257-7-0"),
+				cmd(sendCmdRename, sendAttrPath, "o257-7-0", sendAttrPathTo, "new"),
+				cmd(sendCmdUpdateExtent, sendAttrPath, "new", sendAttrFileOffset, 0, sendAttrSize, 4096),
+				cmd(sendCmdChmod, sendAttrPath, "new"),
+				cmd(sendCmdUtimes, sendAttrPath, ""),
+			},
+			[]*Change{{Path: "new", Kind: ChangeAdded, Ranges: []ByteRange{{0, 4096}}}},
+		},
+		{
+			"modified",
+			[]*sendCommand{
+				cmd(sendCmdUpdateExtent, sendAttrPath, "dir/file", sendAttrFileOffset, 0, sendAttrSize, 4096),
+				cmd(sendCmdUpdateExtent, sendAttrPath, "dir/file", sendAttrFileOffset, 4096, sendAttrSize, 4096),
+				cmd(sendCmdUpdateExtent, sendAttrPath, "dir/file", sendAttrFileOffset, 65536, sendAttrSize, 100),
+				cmd(sendCmdUtimes, sendAttrPath, "dir/file"),
+				cmd(sendCmdUtimes, sendAttrPath, "dir"),
+			},
+			[]*Change{{Path: "dir/file", Kind: ChangeModified, Ranges: []ByteRange{{0, 8192}, {65536, 100}}}},
+		},
+		{
+			"deleted",
+			[]*sendCommand{
+				cmd(sendCmdUnlink, sendAttrPath, "dir/file"),
+				cmd(sendCmdRename, sendAttrPath, "dir", sendAttrPathTo, "o258-5-0"),
+				cmd(sendCmdRmdir, sendAttrPath, "o258-5-0"),
+			},
+			[]*Change{
+				{Path: "dir", Kind: ChangeDeleted},
+				{Path: "dir/file", Kind: ChangeDeleted},
+			},
+		},
+		{
+			"renamed directory",
+			[]*sendCommand{
+				cmd(sendCmdRename, sendAttrPath, "a", sendAttrPathTo, "b"),
+				cmd(sendCmdUpdateExtent, sendAttrPath, "b/file", sendAttrFileOffset, 0, sendAttrSize, 10),
+				cmd(sendCmdRename, sendAttrPath, "b/other", sendAttrPathTo, "other"),
+			},
+			[]*Change{
+				{Path: "b", Kind: ChangeRenamed, OldPath: "a"},
+				{Path: "b/file", Kind: ChangeModified, OldPath: "a/file", Ranges: []ByteRange{{0, 10}}},
+				{Path: "other", Kind: ChangeRenamed, OldPath: "a/other"},
+			},
+		},
+		{
+			"replaced",
+			[]*sendCommand{
+				cmd(sendCmdRename, sendAttrPath, "file", sendAttrPathTo, "o259-9-0"),
+				cmd(sendCmdMkfile, sendAttrPath, "o260-9-0"),
+				cmd(sendCmdRename, sendAttrPath, "o260-9-0", sendAttrPathTo, "file"),
+				cmd(sendCmdUnlink, sendAttrPath, "o259-9-0"),
+			},
+			[]*Change{
+				{Path: "file", Kind: ChangeDeleted},
+				{Path: "file", Kind: ChangeAdded},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := newSendDiff()
+			for _, c := range tt.cmds {
+				if err := diff.apply(c); err != nil {
+					t.Fatalf("sendDiff.apply() error = %v", err)
+				}
+			}
+			if got := diff.changes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sendDiff.changes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTreeDiff(t *testing.T) {
+	old, new := t.TempDir(), t.TempDir()
+	mtime := time.Unix(1600000000, 0)
+	write := func(path string, data string) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(data), 0644)
+		os.Chtimes(path, mtime, mtime)
+	}
+	for _, root := range []string{old, new} {
+		write(filepath.Join(root, "a", "same"), "same")
+		write(filepath.Join(root, "a-c"), "same")
+	}
+	write(filepath.Join(old, "a", "b"), "old")
+	write(filepath.Join(new, "a", "b"), "new!")
+	write(filepath.Join(old, "d", "x"), "old")
+	write(filepath.Join(new, "d"), "file")
+	write(filepath.Join(old, "gone", "x"), "old")
+	write(filepath.Join(new, "new", "y"), "new")
+
+	want := []Change{
+		{Path: "a/b", Kind: ChangeModified},
+		{Path: "d", Kind: ChangeDeleted},
+		{Path: "d", Kind: ChangeAdded},
+		{Path: "d/x", Kind: ChangeDeleted},
+		{Path: "gone", Kind: ChangeDeleted},
+		{Path: "gone/x", Kind: ChangeDeleted},
+		{Path: "new", Kind: ChangeAdded},
+		{Path: "new/y", Kind: ChangeAdded},
+	}
+
+	var got []Change
+	var sorted []*Change
+	err := treeDiff(context.Background(), old, new, func(change *Change) error {
+		got = append(got, *change)
+		sorted = append(sorted, change)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("treeDiff() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("treeDiff() = %v, want %v", got, want)
+	}
+	// Changes derived from send streams are sorted into the same order.
+	sortChanges(sorted)
+	for i := range sorted {
+		if !reflect.DeepEqual(*sorted[i], got[i]) {
+			t.Errorf("sortChanges() = %v at %d, want %v", *sorted[i], i, got[i])
+		}
+	}
+
+	// An error from emit stops the walk.
+	errStop := errors.New("stop")
+	count := 0
+	err = treeDiff(context.Background(), old, new, func(change *Change) error {
+		count++
+		return errStop
+	})
+	if err != errStop || count != 1 {
+		t.Errorf("treeDiff() = %v after %d changes, want %v after 1", err, count, errStop)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	subvol := filepath.Join(mountpoint.path, "subvol1")
+	if CreateSubvolume(subvol) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	os.WriteFile(filepath.Join(subvol, "modified"), []byte("old"), 0660)
+	os.WriteFile(filepath.Join(subvol, "deleted"), []byte("old"), 0660)
+	os.WriteFile(filepath.Join(subvol, "renamed"), []byte("old"), 0660)
+
+	old := filepath.Join(mountpoint.path, "old")
+	if CreateSnapshot(subvol, old, false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+
+	os.WriteFile(filepath.Join(subvol, "modified"), []byte("new"), 0660)
+	os.Remove(filepath.Join(subvol, "deleted"))
+	os.Rename(filepath.Join(subvol, "renamed"), filepath.Join(subvol, "moved"))
+	os.WriteFile(filepath.Join(subvol, "added"), []byte("new"), 0660)
+
+	new := filepath.Join(mountpoint.path, "new")
+	if CreateSnapshot(subvol, new, false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+	copy := filepath.Join(mountpoint.path, "copy")
+	if CreateSubvolume(copy) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	os.WriteFile(filepath.Join(copy, "modified"), []byte("new"), 0660)
+
+	tests := []struct {
+		name    string
+		newSnap string
+		want    map[string]ChangeKind
+	}{
+		{
+			"send",
+			new,
+			map[string]ChangeKind{
+				"added":    ChangeAdded,
+				"deleted":  ChangeDeleted,
+				"modified": ChangeModified,
+				"moved":    ChangeRenamed,
+			},
+		},
+		{
+			"tree",
+			copy,
+			map[string]ChangeKind{
+				"deleted":  ChangeDeleted,
+				"modified": ChangeModified,
+				"renamed":  ChangeDeleted,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it, err := Diff(context.Background(), old, tt.newSnap)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			defer it.Destroy()
+
+			got := make(map[string]ChangeKind)
+			for it.HasNext() {
+				change, err := it.GetNext()
+				if err != nil {
+					t.Fatalf("DiffIterator.GetNext() error = %v", err)
+				}
+				got[change.Path] = change.Kind
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
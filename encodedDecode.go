@@ -0,0 +1,276 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var errCorruptEncoded = errors.New("corrupt encoded data")
+
+// decodeEncoded decompresses encoded data as written by BTRFS_IOC_ENCODED_WRITE.
+// It returns the UnencodedLen bytes of decompressed data, of which meta describes the file range.
+func decodeEncoded(data []byte, meta EncodedMeta) ([]byte, error) {
+	if meta.UnencodedLen > encodedMaxLength {
+		return nil, errCorruptEncoded
+	}
+	if meta.Encryption != 0 {
+		return nil, fmt.Errorf("unsupported encryption %d", meta.Encryption)
+	}
+
+	buf := make([]byte, meta.UnencodedLen)
+	switch meta.Compression {
+	case EncodedCompressionZlib:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+	case EncodedCompressionZstd:
+		r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(encodedMaxLength))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+	case EncodedCompressionLZO4K, EncodedCompressionLZO8K, EncodedCompressionLZO16K, EncodedCompressionLZO32K, EncodedCompressionLZO64K:
+		sectorSize := 4096 << (meta.Compression - EncodedCompressionLZO4K)
+		if err := decodeLZO(buf, data, sectorSize); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", meta.Compression)
+	}
+	return buf, nil
+}
+
+// decodeLZO decompresses an LZO compressed extent into dst, which it must fill.
+// Btrfs stores the total length followed by segments of at most one sector of data,
+// each with its length. A segment length never crosses a sector boundary, the sector is
+// padded with zeros instead.
+func decodeLZO(dst []byte, src []byte, sectorSize int) error {
+	if len(src) < 4 {
+		return errCorruptEncoded
+	}
+	total := int(binary.LittleEndian.Uint32(src))
+	if total > len(src) || total < 4 {
+		return errCorruptEncoded
+	}
+
+	in, out := 4, 0
+	for in < total && out < len(dst) {
+		if sectorSize-in%sectorSize < 4 {
+			in += sectorSize - in%sectorSize
+		}
+		if in+4 > total {
+			return errCorruptEncoded
+		}
+		n := int(binary.LittleEndian.Uint32(src[in:]))
+		in += 4
+		if n > total-in {
+			return errCorruptEncoded
+		}
+
+		size := sectorSize
+		if size > len(dst)-out {
+			size = len(dst) - out
+		}
+		segment, err := lzo1xDecompress(src[in:in+n], size)
+		if err != nil {
+			return err
+		}
+		out += copy(dst[out:], segment)
+		in += n
+	}
+	if out != len(dst) {
+		return errCorruptEncoded
+	}
+	return nil
+}
+
+// lzo1xDecompress decompresses an LZO1X block of at most max bytes,
+// as described in Documentation/staging/lzo.rst of Linux.
+func lzo1xDecompress(src []byte, max int) ([]byte, error) {
+	dst := make([]byte, 0, max)
+	ip := 0
+
+	// readByte reads the next input byte.
+	readByte := func() (int, error) {
+		if ip >= len(src) {
+			return 0, errCorruptEncoded
+		}
+		ip++
+		return int(src[ip-1]), nil
+	}
+	// length extends the length of an instruction by its zero bytes and the final non-zero byte.
+	length := func(base int) (int, error) {
+		n := base
+		for {
+			b, err := readByte()
+			if err != nil {
+				return 0, err
+			}
+			if b != 0 {
+				return n + b, nil
+			}
+			n += 255
+			if n > max {
+				return 0, errCorruptEncoded
+			}
+		}
+	}
+	literals := func(n int) error {
+		if n > len(src)-ip || n > max-len(dst) {
+			return errCorruptEncoded
+		}
+		dst = append(dst, src[ip:ip+n]...)
+		ip += n
+		return nil
+	}
+	// match copies n bytes from distance back, byte by byte as they may overlap.
+	match := func(distance int, n int) error {
+		if distance <= 0 || distance > len(dst) || n > max-len(dst) {
+			return errCorruptEncoded
+		}
+		for i := 0; i < n; i++ {
+			dst = append(dst, dst[len(dst)-distance])
+		}
+		return nil
+	}
+	le16 := func() (int, error) {
+		if ip+2 > len(src) {
+			return 0, errCorruptEncoded
+		}
+		ip += 2
+		return int(binary.LittleEndian.Uint16(src[ip-2:])), nil
+	}
+
+	// state is the number of literals copied by the previous instruction, 4 for four or more.
+	state := 0
+	if len(src) > 0 && src[0] > 17 {
+		n := int(src[0]) - 17
+		ip++
+		if err := literals(n); err != nil {
+			return nil, err
+		}
+		state = n
+		if n > 4 {
+			state = 4
+		}
+	} else if len(src) > 0 && src[0] == 17 {
+		// The bitstream version of LZO-RLE, which Btrfs does not use.
+		return nil, errCorruptEncoded
+	}
+
+	for {
+		t, err := readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		var distance, n, next int
+		switch {
+		case t < 16 && state == 0:
+			// A literal run of four or more bytes.
+			n = t + 3
+			if t == 0 {
+				if n, err = length(15 + 3); err != nil {
+					return nil, err
+				}
+			}
+			if err := literals(n); err != nil {
+				return nil, err
+			}
+			state = 4
+			continue
+		case t < 16:
+			h, err := readByte()
+			if err != nil {
+				return nil, err
+			}
+			next = t & 3
+			if state == 4 {
+				distance, n = h<<2+t>>2+2049, 3
+			} else {
+				distance, n = h<<2+t>>2+1, 2
+			}
+		case t < 32:
+			n = t&7 + 2
+			if t&7 == 0 {
+				if n, err = length(7 + 2); err != nil {
+					return nil, err
+				}
+			}
+			d, err := le16()
+			if err != nil {
+				return nil, err
+			}
+			distance = 16384 + (t&8)<<11 + d>>2
+			next = d & 3
+			if distance == 16384 {
+				// End of stream.
+				return dst, nil
+			}
+		case t < 64:
+			n = t&31 + 2
+			if t&31 == 0 {
+				if n, err = length(31 + 2); err != nil {
+					return nil, err
+				}
+			}
+			d, err := le16()
+			if err != nil {
+				return nil, err
+			}
+			distance, next = d>>2+1, d&3
+		default:
+			h, err := readByte()
+			if err != nil {
+				return nil, err
+			}
+			distance, next = h<<3+(t>>2)&7+1, t&3
+			if t < 128 {
+				n = 3 + (t>>5)&1
+			} else {
+				n = 5 + (t>>5)&3
+			}
+		}
+
+		if err := match(distance, n); err != nil {
+			return nil, err
+		}
+		if err := literals(next); err != nil {
+			return nil, err
+		}
+		state = next
+	}
+}
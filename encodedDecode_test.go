@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testLZOExtent wraps LZO1X segments in the extent format of Btrfs.
+func testLZOExtent(sectorSize int, segments ...[]byte) []byte {
+	extent := make([]byte, 4)
+	for _, segment := range segments {
+		if left := sectorSize - len(extent)%sectorSize; left < 4 {
+			extent = append(extent, make([]byte, left)...)
+		}
+		extent = binary.LittleEndian.AppendUint32(extent, uint32(len(segment)))
+		extent = append(extent, segment...)
+	}
+	binary.LittleEndian.PutUint32(extent, uint32(len(extent)))
+	return extent
+}
+
+func TestDecodeEncoded(t *testing.T) {
+	want := bytes.Repeat([]byte("btrfsutil"), 2048)[:16384]
+
+	var zlibData bytes.Buffer
+	zw := zlib.NewWriter(&zlibData)
+	zw.Write(want)
+	zw.Close()
+
+	encoder, _ := zstd.NewWriter(nil)
+	zstdData := encoder.EncodeAll(want, nil)
+	encoder.Close()
+
+	// A literal run of 4063 bytes, a run of 33 bytes and the end marker fill the first sector,
+	// so that the length of the next segment would cross the sector boundary.
+	literals := bytes.Repeat([]byte("0123456789"), 407)[:4063]
+	first := append([]byte{0}, make([]byte, 15)...)
+	first = append(first, 220)
+	first = append(first, literals...)
+	first = append(first, 32|31, 0, 0, 0x11, 0, 0)
+	lzoWant := append(bytes.Clone(literals), bytes.Repeat(literals[4062:], 33)...)
+	// "abc", a copy of two bytes at distance three followed by one literal and a copy of nine bytes at distance four.
+	second := []byte{17 + 3, 'a', 'b', 'c', 2<<2 | 1, 0, 'x', 32 | 7, 3 << 2, 0, 0x11, 0, 0}
+	lzoWant = append(lzoWant, "abcabxcabxcabxc"...)
+	lzoData := testLZOExtent(4096, first, second)
+	if len(lzoData) != 4096+4+len(second) {
+		t.Fatalf("LZO extent of %d bytes is not padded", len(lzoData))
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		meta    EncodedMeta
+		want    []byte
+		wantErr bool
+	}{
+		{"zlib", zlibData.Bytes(), EncodedMeta{UnencodedLen: 16384, Compression: EncodedCompressionZlib}, want, false},
+		{"zstd", zstdData, EncodedMeta{UnencodedLen: 16384, Compression: EncodedCompressionZstd}, want, false},
+		{"lzo", lzoData, EncodedMeta{UnencodedLen: uint64(len(lzoWant)), Compression: EncodedCompressionLZO4K}, lzoWant, false},
+		{"too large", zlibData.Bytes(), EncodedMeta{UnencodedLen: 1 << 40, Compression: EncodedCompressionZlib}, nil, true},
+		{"short", zstdData, EncodedMeta{UnencodedLen: 16385, Compression: EncodedCompressionZstd}, nil, true},
+		{"truncated lzo", lzoData[:len(lzoData)-5], EncodedMeta{UnencodedLen: uint64(len(lzoWant)), Compression: EncodedCompressionLZO4K}, nil, true},
+		{"lzo too long", lzoData, EncodedMeta{UnencodedLen: 100, Compression: EncodedCompressionLZO4K}, nil, true},
+		{"encrypted", zlibData.Bytes(), EncodedMeta{UnencodedLen: 16384, Compression: EncodedCompressionZlib, Encryption: 1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeEncoded(tt.data, tt.meta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeEncoded() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("decodeEncoded() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLZO1XDecompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		want    string
+		wantErr bool
+	}{
+		{"literals", []byte{17 + 5, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0}, "hello", false},
+		{"short match", []byte{17 + 4, 'a', 'b', 'c', 'd', 64 | 2<<2, 0, 0x11, 0, 0}, "abcdbcd", false},
+		{"long match", []byte{17 + 1, 'z', 128 | 3<<5, 0, 0x11, 0, 0}, "zzzzzzzzz", false},
+		{"distance too far", []byte{17 + 1, 'z', 64 | 7<<2, 0, 0x11, 0, 0}, "", true},
+		{"too long", []byte{17 + 1, 'z', 32, 0, 0, 0, 0, 0x11, 0, 0}, "", true},
+		{"no end", []byte{17 + 2, 'a', 'b'}, "", true},
+		{"rle version", []byte{17, 1, 0x11, 0, 0}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lzo1xDecompress(tt.src, 16)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lzo1xDecompress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("lzo1xDecompress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <sys/uio.h>
+// #include <linux/btrfs.h>
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// EncodedCompression is the compression of encoded data as used by
+// BTRFS_IOC_ENCODED_READ/WRITE and version 2 of the send protocol.
+// Its values differ from CompressionType.
+type EncodedCompression uint32
+
+const (
+	EncodedCompressionNone   EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_NONE
+	EncodedCompressionZlib   EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_ZLIB
+	EncodedCompressionZstd   EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_ZSTD
+	EncodedCompressionLZO4K  EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_LZO_4K
+	EncodedCompressionLZO8K  EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_LZO_8K
+	EncodedCompressionLZO16K EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_LZO_16K
+	EncodedCompressionLZO32K EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_LZO_32K
+	EncodedCompressionLZO64K EncodedCompression = C.BTRFS_ENCODED_IO_COMPRESSION_LZO_64K
+)
+
+// EncodedMeta describes encoded data, matching the fields of the send protocol's encoded_write command.
+type EncodedMeta struct {
+	// Len is the length of the file range covered by the data.
+	Len uint64
+	// UnencodedLen is the length of the data after decoding.
+	UnencodedLen uint64
+	// UnencodedOffset is the offset of the file range into the decoded data.
+	UnencodedOffset uint64
+	Compression     EncodedCompression
+	Encryption      uint32
+}
+
+// encodedMaxLength is the maximum length of a compressed extent before and after decompression.
+const encodedMaxLength = 128 * 1024
+
+// encodedReadMaxSize bounds the buffer used by EncodedRead.
+const encodedReadMaxSize = 16 * 1024 * 1024
+
+// EncodedRead reads the extent at a given file offset without decompressing it.
+// It returns the data as stored on disk together with its encoding.
+// Requires Linux 5.18 or newer and appropriate privileges (CAP_SYS_ADMIN).
+func EncodedRead(fd uintptr, offset uint64) ([]byte, *EncodedMeta, error) {
+	// Compressed extents are at most 128 KiB; larger uncompressed extents are read partially.
+	size := 128 * 1024
+	for {
+		data, meta, err := encodedRead(fd, offset, size)
+		if err == syscall.ENOBUFS && size < encodedReadMaxSize {
+			size *= 2
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEncodedReadFailed, err)
+		}
+		return data, meta, nil
+	}
+}
+
+func encodedRead(fd uintptr, offset uint64, size int) ([]byte, *EncodedMeta, error) {
+	data := make([]byte, size)
+	iov := new(C.struct_iovec)
+	iov.iov_base = unsafe.Pointer(&data[0])
+	iov.iov_len = C.size_t(len(data))
+
+	args := new(C.struct_btrfs_ioctl_encoded_io_args)
+	args.iov = iov
+	args.iovcnt = 1
+	args.offset = C.__s64(offset)
+
+	n, err := ioctlRet(fd, C.BTRFS_IOC_ENCODED_READ, unsafe.Pointer(args))
+	runtime.KeepAlive(iov)
+	runtime.KeepAlive(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := EncodedMeta{
+		Len:             uint64(args.len),
+		UnencodedLen:    uint64(args.unencoded_len),
+		UnencodedOffset: uint64(args.unencoded_offset),
+		Compression:     EncodedCompression(args.compression),
+		Encryption:      uint32(args.encryption),
+	}
+	return data[:n], &meta, nil
+}
+
+// EncodedWrite writes encoded data to a given file offset without compressing it again.
+// fd must be opened for writing, offset must be aligned to the sector size of the filesystem
+// and the compression in meta must not be EncodedCompressionNone.
+// Requires Linux 5.18 or newer and appropriate privileges (CAP_SYS_ADMIN).
+func EncodedWrite(fd uintptr, offset uint64, data []byte, meta EncodedMeta) error {
+	if len(data) == 0 {
+		return ErrInvalidArgument
+	}
+
+	iov := new(C.struct_iovec)
+	iov.iov_base = unsafe.Pointer(&data[0])
+	iov.iov_len = C.size_t(len(data))
+
+	args := new(C.struct_btrfs_ioctl_encoded_io_args)
+	args.iov = iov
+	args.iovcnt = 1
+	args.offset = C.__s64(offset)
+	args.len = C.__u64(meta.Len)
+	args.unencoded_len = C.__u64(meta.UnencodedLen)
+	args.unencoded_offset = C.__u64(meta.UnencodedOffset)
+	args.compression = C.__u32(meta.Compression)
+	args.encryption = C.__u32(meta.Encryption)
+
+	_, err := ioctlRet(fd, C.BTRFS_IOC_ENCODED_WRITE, unsafe.Pointer(args))
+	runtime.KeepAlive(iov)
+	runtime.KeepAlive(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEncodedWriteFailed, err)
+	}
+	return nil
+}
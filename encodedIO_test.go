@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodedIO(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	want := bytes.Repeat([]byte("btrfsutil"), 2048)[:16384]
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(want)
+	w.Close()
+
+	file, err := os.OpenFile(filepath.Join(mountpoint.path, "file"), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	meta := EncodedMeta{
+		Len:          uint64(len(want)),
+		UnencodedLen: uint64(len(want)),
+		Compression:  EncodedCompressionZlib,
+	}
+	if err := EncodedWrite(file.Fd(), 0, compressed.Bytes(), meta); err != nil {
+		t.Fatalf("EncodedWrite() error = %v", err)
+	}
+
+	got := make([]byte, len(want))
+	if _, err := file.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("EncodedWrite() wrote unexpected data")
+	}
+
+	data, gotMeta, err := EncodedRead(file.Fd(), 0)
+	if err != nil {
+		t.Fatalf("EncodedRead() error = %v", err)
+	}
+	if *gotMeta != meta {
+		t.Errorf("EncodedRead() meta = %+v, want %+v", *gotMeta, meta)
+	}
+	// Compressed extents are padded to the sector size on disk.
+	if len(data) < compressed.Len() || !bytes.Equal(data[:compressed.Len()], compressed.Bytes()) {
+		t.Errorf("EncodedRead() data differs from written data")
+	}
+}
@@ -50,6 +50,45 @@ var (
 	ErrFsInfoFailed           = errors.New("could not get filesystem information")
 )
 
+// Errors returned by functions which are not backed by libbtrfsutil.
+var (
+	ErrGetFlagsFailed        = errors.New("could not get inode flags")
+	ErrSetFlagsFailed        = errors.New("could not set inode flags")
+	ErrDirectoryNotEmpty     = errors.New("directory is not empty")
+	ErrSpaceInfoFailed       = errors.New("could not get space information")
+	ErrChunkNotFound         = errors.New("could not find chunk for logical address")
+	ErrProfileNotSupported   = errors.New("block group profile not supported")
+	ErrMultipleDevices       = errors.New("filesystem has more than one device")
+	ErrSubvolumeHasSnapshots = errors.New("subvolume has snapshots")
+	ErrSharedExtent          = errors.New("extent is shared")
+	ErrNotSwapfileCompatible = errors.New("file extents are not suitable for swap")
+	ErrEncodedReadFailed     = errors.New("could not read encoded data with BTRFS_IOC_ENCODED_READ")
+	ErrEncodedWriteFailed    = errors.New("could not write encoded data with BTRFS_IOC_ENCODED_WRITE")
+	ErrSendFailed            = errors.New("could not send subvolume with BTRFS_IOC_SEND")
+	ErrInvalidSendStream     = errors.New("invalid send stream")
+	ErrRenameFailed          = errors.New("could not rename")
+	ErrReceiveFailed         = errors.New("could not apply send stream")
+	ErrSetReceivedFailed     = errors.New("could not set received subvolume with BTRFS_IOC_SET_RECEIVED_SUBVOL")
+	ErrSubvolSyncWaitFailed  = errors.New("could not wait for subvolume cleanup with BTRFS_IOC_SUBVOL_SYNC_WAIT")
+	ErrInvalidSnapshotName   = errors.New("snapshot name does not contain a time")
+	ErrDevInfoFailed         = errors.New("could not get device information with BTRFS_IOC_DEV_INFO")
+	ErrDevStatsFailed        = errors.New("could not get device statistics with BTRFS_IOC_GET_DEV_STATS")
+	ErrQuotaNotEnabled       = errors.New("quotas are not enabled")
+	ErrNotRunning            = errors.New("operation is not running")
+	ErrScrubProgressFailed   = errors.New("could not get scrub progress with BTRFS_IOC_SCRUB_PROGRESS")
+	ErrBalanceProgressFailed = errors.New("could not get balance progress with BTRFS_IOC_BALANCE_PROGRESS")
+	ErrQgroupLimitFailed     = errors.New("could not set qgroup limit with BTRFS_IOC_QGROUP_LIMIT")
+	ErrQuotaCtlFailed        = errors.New("could not change quota state with BTRFS_IOC_QUOTA_CTL")
+	ErrSubvolumeNotMounted   = errors.New("subvolume is not accessible through a mount")
+	ErrInvalidArchive        = errors.New("invalid snapshot archive")
+	ErrArchiveChecksum       = errors.New("snapshot archive checksum mismatch")
+	ErrParentNotFound        = errors.New("parent snapshot of incremental stream not found")
+	ErrInvalidSuperblock     = errors.New("invalid superblock")
+	ErrSuperblockChecksum    = errors.New("superblock checksum mismatch")
+	ErrSuperblockMismatch    = errors.New("superblock mirrors differ")
+	ErrUnsupportedChecksum   = errors.New("unsupported checksum algorithm")
+)
+
 var errorMap = map[uint32]error{
 	1:  ErrStopIteration,
 	2:  ErrNoMemory,
@@ -0,0 +1,304 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package exporter exposes metrics of Btrfs filesystems in the Prometheus text exposition format.
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/schema"
+)
+
+// fsTreeObjectid is BTRFS_FS_TREE_OBJECTID, the ID of the top-level subvolume.
+const fsTreeObjectid = 5
+
+// Exporter collects metrics of Btrfs filesystems on every request.
+// Most metrics require appropriate privileges (CAP_SYS_ADMIN).
+type Exporter struct {
+	// Paths are paths in the filesystems to report. They should be mountpoints of the
+	// top-level subvolume, so that subvolume paths are complete.
+	// If empty, all mounted Btrfs filesystems are reported. A filesystem given by several
+	// paths is reported once, for the first of them.
+	Paths []string
+}
+
+// ServeHTTP writes the metrics of all filesystems.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := e.WriteMetrics(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// WriteMetrics collects the metrics of all filesystems and writes them to w.
+// Failures to collect a group of metrics are reported by btrfs_collector_success
+// instead of failing the whole scrape.
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	paths := e.Paths
+	if len(paths) == 0 {
+		var err error
+		if paths, err = btrfsutil.Mountpoints(); err != nil {
+			return err
+		}
+	}
+
+	m := newMetricSet()
+	seen := make(map[btrfsutil.UUID]bool)
+	for _, path := range paths {
+		collectFilesystem(m, path, seen)
+	}
+	return m.writeTo(w)
+}
+
+// collector collects one group of metrics of a filesystem.
+type collector struct {
+	name    string
+	collect func(m *metricSet, path string, fsid string) error
+}
+
+var collectors = []collector{
+	{"space", collectSpace},
+	{"devices", collectDevices},
+	{"subvolumes", collectSubvolumes},
+	{"qgroups", collectQgroups},
+	{"deleted_subvolumes", collectDeletedSubvolumes},
+	{"balance", collectBalance},
+}
+
+// collectFilesystem collects the metrics of the filesystem containing path, unless its FSID is in seen.
+func collectFilesystem(m *metricSet, path string, seen map[btrfsutil.UUID]bool) {
+	info, err := btrfsutil.GetFilesystemInfo(path)
+	if err != nil {
+		m.gauge("btrfs_collector_success", "Whether a group of metrics was collected successfully.", 0,
+			"fsid", "", "path", path, "collector", "filesystem")
+		return
+	}
+	// Metrics of the same filesystem collected twice would be duplicate series.
+	if seen[info.FSID] {
+		return
+	}
+	seen[info.FSID] = true
+	fsid := info.FSID.String()
+
+	m.gauge("btrfs_filesystem_info", "Information about a filesystem, always 1.", 1,
+		"fsid", fsid, "path", path)
+	m.gauge("btrfs_filesystem_devices", "Number of devices of a filesystem.", float64(info.NumDevices),
+		"fsid", fsid)
+	m.gauge("btrfs_filesystem_node_size_bytes", "Size of metadata nodes.", float64(info.NodeSize),
+		"fsid", fsid)
+	m.gauge("btrfs_filesystem_sector_size_bytes", "Minimal allocation unit of a filesystem.", float64(info.SectorSize),
+		"fsid", fsid)
+	if info.Generation != 0 {
+		m.gauge("btrfs_filesystem_generation", "Current transaction ID of a filesystem.", float64(info.Generation),
+			"fsid", fsid)
+	}
+
+	for _, c := range collectors {
+		success := 1.0
+		if err := c.collect(m, path, fsid); err != nil {
+			success = 0
+		}
+		m.gauge("btrfs_collector_success", "Whether a group of metrics was collected successfully.", success,
+			"fsid", fsid, "path", path, "collector", c.name)
+	}
+}
+
+func collectSpace(m *metricSet, path string, fsid string) error {
+	infos, err := btrfsutil.GetSpaceInfo(path)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		labels := []string{"fsid", fsid, "type", info.Type(), "profile", info.Profile()}
+		m.gauge("btrfs_space_total_bytes", "Bytes allocated to block groups of a type and profile.", float64(info.TotalBytes), labels...)
+		m.gauge("btrfs_space_used_bytes", "Bytes used in block groups of a type and profile.", float64(info.UsedBytes), labels...)
+	}
+	return nil
+}
+
+func collectDevices(m *metricSet, path string, fsid string) error {
+	devices, err := btrfsutil.GetDevices(path)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+	for _, device := range devices {
+		devid := strconv.FormatUint(device.Id, 10)
+		labels := []string{"fsid", fsid, "devid", devid, "device", device.Path}
+		m.gauge("btrfs_device_size_bytes", "Size of a device.", float64(device.TotalBytes), labels...)
+		m.gauge("btrfs_device_used_bytes", "Bytes allocated on a device.", float64(device.BytesUsed), labels...)
+
+		if stats, err := btrfsutil.GetDeviceStats(path, device.Id); err == nil {
+			for _, stat := range []struct {
+				typ   string
+				value uint64
+			}{
+				{"write", stats.WriteErrs},
+				{"read", stats.ReadErrs},
+				{"flush", stats.FlushErrs},
+				{"corruption", stats.CorruptionErrs},
+				{"generation", stats.GenerationErrs},
+			} {
+				m.counter("btrfs_device_errors_total", "Errors recorded on a device by type.", float64(stat.value),
+					append(labels, "type", stat.typ)...)
+			}
+		} else {
+			failed = err
+		}
+
+		scrub, err := btrfsutil.GetScrubProgress(path, device.Id)
+		if errors.Is(err, btrfsutil.ErrNotRunning) {
+			m.gauge("btrfs_scrub_running", "Whether a scrub is running on a device.", 0, labels...)
+			continue
+		}
+		if err != nil {
+			failed = err
+			continue
+		}
+		m.gauge("btrfs_scrub_running", "Whether a scrub is running on a device.", 1, labels...)
+		m.gauge("btrfs_scrub_bytes_scrubbed", "Bytes scrubbed on a device by the running scrub.",
+			float64(scrub.DataBytesScrubbed+scrub.TreeBytesScrubbed), labels...)
+		for _, stat := range []struct {
+			typ   string
+			value uint64
+		}{
+			{"read", scrub.ReadErrors},
+			{"csum", scrub.CsumErrors},
+			{"verify", scrub.VerifyErrors},
+			{"super", scrub.SuperErrors},
+			{"uncorrectable", scrub.UncorrectableErrors},
+			{"corrected", scrub.CorrectedErrors},
+		} {
+			m.gauge("btrfs_scrub_errors", "Errors found on a device by the running scrub by type.", float64(stat.value),
+				append(labels, "type", stat.typ)...)
+		}
+	}
+	return failed
+}
+
+func listSubvolumes(path string) (map[uint64]*btrfsutil.SubvolumeInfoIteratorResult, error) {
+	it, err := btrfsutil.CreateSubvolumeInfoIterator(path, fsTreeObjectid, false)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Destroy()
+
+	subvolumes := make(map[uint64]*btrfsutil.SubvolumeInfoIteratorResult)
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return nil, err
+		}
+		subvolumes[result.Info.Id] = result
+	}
+	return subvolumes, nil
+}
+
+func collectSubvolumes(m *metricSet, path string, fsid string) error {
+	subvolumes, err := listSubvolumes(path)
+	if err != nil {
+		return err
+	}
+	for _, subvol := range subvolumes {
+		labels := []string{"fsid", fsid, "uuid", subvol.Info.UUID.String(), "path", subvol.Path}
+		readOnly := 0.0
+		if subvol.Info.Flags&schema.SubvolReadOnly != 0 {
+			readOnly = 1
+		}
+		m.gauge("btrfs_subvolume_generation", "Transaction ID of the last change to a subvolume.", float64(subvol.Info.Generation), labels...)
+		m.gauge("btrfs_subvolume_read_only", "Whether a subvolume is read-only.", readOnly, labels...)
+	}
+	return nil
+}
+
+func collectQgroups(m *metricSet, path string, fsid string) error {
+	qgroups, err := btrfsutil.GetQgroups(path)
+	if err == btrfsutil.ErrQuotaNotEnabled {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	subvolumes, err := listSubvolumes(path)
+	if err != nil {
+		return err
+	}
+
+	for _, qgroup := range qgroups {
+		var uuid, subvolPath string
+		if subvol, ok := subvolumes[qgroup.SubvolumeId()]; ok && qgroup.Level() == 0 {
+			uuid, subvolPath = subvol.Info.UUID.String(), subvol.Path
+		}
+		labels := []string{"fsid", fsid, "qgroupid", qgroup.String(), "uuid", uuid, "path", subvolPath}
+		m.gauge("btrfs_qgroup_referenced_bytes", "Bytes referenced by a qgroup.", float64(qgroup.Referenced), labels...)
+		m.gauge("btrfs_qgroup_exclusive_bytes", "Bytes referenced exclusively by a qgroup.", float64(qgroup.Exclusive), labels...)
+	}
+	return nil
+}
+
+func collectDeletedSubvolumes(m *metricSet, path string, fsid string) error {
+	ids, err := btrfsutil.DeletedSubvolumes(path)
+	if err != nil {
+		return err
+	}
+	m.gauge("btrfs_deleted_subvolumes_pending", "Deleted subvolumes which have not been cleaned up yet.", float64(len(ids)),
+		"fsid", fsid)
+	return nil
+}
+
+func collectBalance(m *metricSet, path string, fsid string) error {
+	balance, err := btrfsutil.GetBalanceProgress(path)
+	if errors.Is(err, btrfsutil.ErrNotRunning) {
+		m.gauge("btrfs_balance_running", "Whether a balance is running.", 0, "fsid", fsid)
+		m.gauge("btrfs_balance_paused", "Whether a balance is paused.", 0, "fsid", fsid)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	running, paused := 0.0, 1.0
+	if balance.Running {
+		running, paused = 1, 0
+	}
+	m.gauge("btrfs_balance_running", "Whether a balance is running.", running, "fsid", fsid)
+	m.gauge("btrfs_balance_paused", "Whether a balance is paused.", paused, "fsid", fsid)
+	for _, stat := range []struct {
+		state string
+		value uint64
+	}{
+		{"expected", balance.Expected},
+		{"considered", balance.Considered},
+		{"completed", balance.Completed},
+	} {
+		m.gauge("btrfs_balance_chunks", "Chunks of the balance by state.", float64(stat.value),
+			"fsid", fsid, "state", stat.state)
+	}
+	return nil
+}
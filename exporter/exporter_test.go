@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+)
+
+func TestExporter(t *testing.T) {
+	mountpoint := btrfstest.Mount(t)
+	if err := btrfsutil.CreateSubvolume(filepath.Join(mountpoint, "subvol")); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	// The subvolume is on the same filesystem, which is reported once.
+	(&Exporter{Paths: []string{mountpoint, filepath.Join(mountpoint, "subvol")}}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, metric := range []string{
+		"btrfs_filesystem_info{",
+		"btrfs_space_total_bytes{",
+		"btrfs_device_errors_total{",
+		`btrfs_subvolume_generation{fsid=`,
+		`path="subvol"`,
+		"btrfs_deleted_subvolumes_pending{",
+		"btrfs_balance_running{",
+		`btrfs_collector_success{fsid=`,
+	} {
+		if !strings.Contains(body, metric) {
+			t.Errorf("ServeHTTP() is missing %v", metric)
+		}
+	}
+	if n := strings.Count(body, "btrfs_filesystem_info{"); n != 1 {
+		t.Errorf("ServeHTTP() reports %d filesystems, want 1", n)
+	}
+	if strings.Contains(body, `collector="space"} 0`) {
+		t.Errorf("ServeHTTP() failed to collect space info")
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricSet(t *testing.T) {
+	m := newMetricSet()
+	m.gauge("btrfs_subvolume_read_only", "Whether a subvolume is read-only.", 1,
+		"fsid", "fs1", "path", `snapshots/"quoted"`)
+	m.counter("btrfs_device_errors_total", "Errors recorded on a device by type.", 3,
+		"fsid", "fs1", "devid", "1", "type", "read")
+	m.gauge("btrfs_subvolume_read_only", "Whether a subvolume is read-only.", 0,
+		"fsid", "fs2", "path", "back\\slash\nnewline")
+	m.gauge("btrfs_filesystem_devices", "Number of devices of a filesystem.", 1.5e10)
+
+	var buf bytes.Buffer
+	if err := m.writeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP btrfs_device_errors_total Errors recorded on a device by type.
+# TYPE btrfs_device_errors_total counter
+btrfs_device_errors_total{fsid="fs1",devid="1",type="read"} 3
+# HELP btrfs_filesystem_devices Number of devices of a filesystem.
+# TYPE btrfs_filesystem_devices gauge
+btrfs_filesystem_devices 1.5e+10
+# HELP btrfs_subvolume_read_only Whether a subvolume is read-only.
+# TYPE btrfs_subvolume_read_only gauge
+btrfs_subvolume_read_only{fsid="fs1",path="snapshots/\"quoted\""} 1
+btrfs_subvolume_read_only{fsid="fs2",path="back\\slash\nnewline"} 0
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeTo() = %v, want %v", got, want)
+	}
+}
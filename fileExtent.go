@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"encoding/binary"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CompressionType is the compression algorithm used for a file extent.
+type CompressionType uint8
+
+const (
+	CompressionNone CompressionType = 0
+	CompressionZlib CompressionType = 1
+	CompressionLZO  CompressionType = 2
+	CompressionZstd CompressionType = 3
+)
+
+func (c CompressionType) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionZlib:
+		return "zlib"
+	case CompressionLZO:
+		return "lzo"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return "unknown"
+}
+
+// FileExtent is a representation of a single extent of a file.
+type FileExtent struct {
+	// Offset is the logical offset of the extent in the file.
+	Offset uint64
+	// Length is the number of bytes of the file covered by the extent.
+	Length uint64
+	// DiskBytenr is the logical address of the extent on disk; zero for holes and inline extents.
+	DiskBytenr uint64
+	// DiskSize is the number of bytes the extent occupies on disk.
+	DiskSize uint64
+	// RamSize is the uncompressed size of the extent.
+	RamSize     uint64
+	Compression CompressionType
+	Inline      bool
+	Prealloc    bool
+}
+
+// Offsets into struct btrfs_file_extent_item.
+const (
+	fileExtentRamBytes     = 8
+	fileExtentCompression  = 16
+	fileExtentType         = 20
+	fileExtentInlineData   = 21
+	fileExtentDiskBytenr   = 21
+	fileExtentDiskNumBytes = 29
+	fileExtentNumBytes     = 45
+	fileExtentItemSize     = 53
+)
+
+func newFileExtent(item *searchItem) (*FileExtent, bool) {
+	if len(item.data) < fileExtentInlineData {
+		return nil, false
+	}
+
+	extent := FileExtent{
+		Offset:      item.offset,
+		RamSize:     binary.LittleEndian.Uint64(item.data[fileExtentRamBytes:]),
+		Compression: CompressionType(item.data[fileExtentCompression]),
+	}
+
+	switch item.data[fileExtentType] {
+	case C.BTRFS_FILE_EXTENT_INLINE:
+		extent.Inline = true
+		extent.Length = extent.RamSize
+		extent.DiskSize = uint64(len(item.data) - fileExtentInlineData)
+		return &extent, true
+	case C.BTRFS_FILE_EXTENT_PREALLOC:
+		extent.Prealloc = true
+	}
+
+	if len(item.data) < fileExtentItemSize {
+		return nil, false
+	}
+	extent.DiskBytenr = binary.LittleEndian.Uint64(item.data[fileExtentDiskBytenr:])
+	extent.DiskSize = binary.LittleEndian.Uint64(item.data[fileExtentDiskNumBytes:])
+	extent.Length = binary.LittleEndian.Uint64(item.data[fileExtentNumBytes:])
+	return &extent, true
+}
+
+// FileExtents returns the extents of a regular file, ordered by their offset.
+// Holes are reported as extents with a DiskBytenr of zero.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func FileExtents(path string) ([]FileExtent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return FileExtentsFd(file.Fd())
+}
+
+// See FileExtents.
+func FileExtentsFd(fd uintptr) ([]FileExtent, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(fd), &stat); err != nil {
+		return nil, ErrStatFailed
+	}
+
+	key := searchKey{
+		minObjectid: stat.Ino,
+		maxObjectid: stat.Ino,
+		minType:     C.BTRFS_EXTENT_DATA_KEY,
+		maxType:     C.BTRFS_EXTENT_DATA_KEY,
+		minOffset:   0,
+		maxOffset:   math.MaxUint64,
+	}
+
+	var extents []FileExtent
+	err := treeSearch(fd, key, func(item *searchItem) error {
+		if item.typ != C.BTRFS_EXTENT_DATA_KEY {
+			return nil
+		}
+		if extent, ok := newFileExtent(item); ok {
+			extents = append(extents, *extent)
+		}
+		return nil
+	})
+	return extents, err
+}
+
+// CompressionUsage accumulates the space used by extents.
+type CompressionUsage struct {
+	// DiskBytes is the space used on disk.
+	DiskBytes uint64
+	// UncompressedBytes is the size of the extents before compression.
+	UncompressedBytes uint64
+	// ReferencedBytes is the amount of file data referring to the extents.
+	ReferencedBytes uint64
+}
+
+// Ratio returns DiskBytes divided by UncompressedBytes.
+func (u CompressionUsage) Ratio() float64 {
+	if u.UncompressedBytes == 0 {
+		return 0
+	}
+	return float64(u.DiskBytes) / float64(u.UncompressedBytes)
+}
+
+func (u *CompressionUsage) add(extent *FileExtent, shared bool) {
+	if !shared {
+		u.DiskBytes += extent.DiskSize
+		u.UncompressedBytes += extent.RamSize
+	}
+	u.ReferencedBytes += extent.Length
+}
+
+// CompressionStatistics is the result of CompressionStats.
+type CompressionStatistics struct {
+	Files   uint64
+	Extents uint64
+	Total   CompressionUsage
+	Types   map[CompressionType]CompressionUsage
+}
+
+// CompressionStats walks all regular files beneath rootPath and reports disk usage
+// versus uncompressed size per compression algorithm, like compsize.
+// Extents shared between files, snapshots or reflinks are only counted once
+// for disk and uncompressed usage. Holes are ignored.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func CompressionStats(rootPath string) (*CompressionStatistics, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+
+	stats := CompressionStatistics{Types: make(map[CompressionType]CompressionUsage)}
+	inodes := make(map[inode]struct{})
+	extents := make(map[uint64]struct{})
+
+	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+		if err != nil {
+			return ErrOpenFailed
+		}
+		defer file.Close()
+
+		var stat syscall.Stat_t
+		if err := syscall.Fstat(int(file.Fd()), &stat); err != nil {
+			return ErrStatFailed
+		}
+		if _, ok := inodes[inode{stat.Dev, stat.Ino}]; ok {
+			return nil
+		}
+		inodes[inode{stat.Dev, stat.Ino}] = struct{}{}
+
+		fileExtents, err := FileExtentsFd(file.Fd())
+		if err != nil {
+			return err
+		}
+
+		stats.Files++
+		for i := range fileExtents {
+			extent := &fileExtents[i]
+			if !extent.Inline && extent.DiskBytenr == 0 {
+				continue
+			}
+
+			shared := false
+			if !extent.Inline {
+				_, shared = extents[extent.DiskBytenr]
+				extents[extent.DiskBytenr] = struct{}{}
+			}
+			if !shared {
+				stats.Extents++
+			}
+
+			usage := stats.Types[extent.Compression]
+			usage.add(extent, shared)
+			stats.Types[extent.Compression] = usage
+			stats.Total.add(extent, shared)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileExtents(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	tests := []struct {
+		name       string
+		size       int
+		wantInline bool
+		wantLength uint64
+	}{
+		{"inline", 100, true, 100},
+		{"regular", 128 * 1024, false, 128 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(mountpoint.path, tt.name)
+			if err := os.WriteFile(path, bytes.Repeat([]byte{'a'}, tt.size), 0660); err != nil {
+				t.Fatal(err)
+			}
+			if err := Sync(mountpoint.path); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := FileExtents(path)
+			if err != nil {
+				t.Errorf("FileExtents() error = %v", err)
+				return
+			}
+			if len(got) != 1 {
+				t.Errorf("FileExtents() = %v, want 1 extent", got)
+				return
+			}
+			if got[0].Offset != 0 || got[0].Inline != tt.wantInline || got[0].Length != tt.wantLength {
+				t.Errorf("FileExtents() = %+v, want offset 0, inline %v, length %d", got[0], tt.wantInline, tt.wantLength)
+			}
+			if got[0].Compression != CompressionNone {
+				t.Errorf("FileExtents() compression = %v, want %v", got[0].Compression, CompressionNone)
+			}
+		})
+	}
+}
+
+func TestCompressionStats(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	data := bytes.Repeat([]byte{'a'}, 128*1024)
+	if err := os.WriteFile(filepath.Join(mountpoint.path, "subvol1/file"), data, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if CreateSnapshot(filepath.Join(mountpoint.path, "subvol1"), filepath.Join(mountpoint.path, "snap1"), false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+	if err := Sync(mountpoint.path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CompressionStats(mountpoint.path)
+	if err != nil {
+		t.Fatalf("CompressionStats() error = %v", err)
+	}
+	if got.Files != 2 {
+		t.Errorf("CompressionStats() files = %d, want 2", got.Files)
+	}
+	want := CompressionUsage{DiskBytes: 128 * 1024, UncompressedBytes: 128 * 1024, ReferencedBytes: 2 * 128 * 1024}
+	if got.Total != want {
+		t.Errorf("CompressionStats() total = %+v, want %+v", got.Total, want)
+	}
+}
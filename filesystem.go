@@ -0,0 +1,306 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// FilesystemInfo is information about a mounted Btrfs filesystem.
+type FilesystemInfo struct {
+	FSID UUID
+	// MetadataUUID differs from FSID if the FSID was changed without rewriting the metadata.
+	// It requires Linux 5.13 or newer and is zero otherwise.
+	MetadataUUID UUID
+	NumDevices   uint64
+	// MaxId is the highest device ID in use.
+	MaxId      uint64
+	NodeSize   uint32
+	SectorSize uint32
+	// Generation requires Linux 5.13 or newer and is zero otherwise.
+	Generation uint64
+}
+
+// GetFilesystemInfo returns information about the filesystem containing path.
+func GetFilesystemInfo(path string) (*FilesystemInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetFilesystemInfoFd(file.Fd())
+}
+
+// See GetFilesystemInfo.
+func GetFilesystemInfoFd(fd uintptr) (*FilesystemInfo, error) {
+	args := new(C.struct_btrfs_ioctl_fs_info_args)
+	args.flags = C.BTRFS_FS_INFO_FLAG_GENERATION | C.BTRFS_FS_INFO_FLAG_METADATA_UUID
+
+	if err := ioctl(fd, C.BTRFS_IOC_FS_INFO, unsafe.Pointer(args)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFsInfoFailed, err)
+	}
+
+	info := &FilesystemInfo{
+		NumDevices: uint64(args.num_devices),
+		MaxId:      uint64(args.max_id),
+		NodeSize:   uint32(args.nodesize),
+		SectorSize: uint32(args.sectorsize),
+	}
+	for i := range info.FSID {
+		info.FSID[i] = byte(args.fsid[i])
+	}
+	// Older kernels clear the flags they do not know.
+	if args.flags&C.BTRFS_FS_INFO_FLAG_GENERATION != 0 {
+		info.Generation = uint64(args.generation)
+	}
+	if args.flags&C.BTRFS_FS_INFO_FLAG_METADATA_UUID != 0 {
+		for i := range info.MetadataUUID {
+			info.MetadataUUID[i] = byte(args.metadata_uuid[i])
+		}
+	}
+	return info, nil
+}
+
+// SpaceInfo is the allocation of a filesystem for one block group type and profile.
+type SpaceInfo struct {
+	// Flags are the BTRFS_BLOCK_GROUP_* flags, see Type and Profile.
+	Flags      uint64
+	TotalBytes uint64
+	UsedBytes  uint64
+}
+
+// Type returns the block group type, "data", "metadata", "system", "mixed" for
+// combined data and metadata or "globalreserve" for the global block reserve.
+func (s SpaceInfo) Type() string {
+	if s.Flags&C.BTRFS_SPACE_INFO_GLOBAL_RSV != 0 {
+		return "globalreserve"
+	}
+	switch s.Flags & C.BTRFS_BLOCK_GROUP_TYPE_MASK {
+	case C.BTRFS_BLOCK_GROUP_DATA:
+		return "data"
+	case C.BTRFS_BLOCK_GROUP_METADATA:
+		return "metadata"
+	case C.BTRFS_BLOCK_GROUP_SYSTEM:
+		return "system"
+	case C.BTRFS_BLOCK_GROUP_DATA | C.BTRFS_BLOCK_GROUP_METADATA:
+		return "mixed"
+	}
+	return "unknown"
+}
+
+// Profile returns the block group profile, e.g. "single", "dup" or "raid1".
+func (s SpaceInfo) Profile() string {
+	profiles := []struct {
+		flag uint64
+		name string
+	}{
+		{C.BTRFS_BLOCK_GROUP_RAID0, "raid0"},
+		{C.BTRFS_BLOCK_GROUP_RAID1, "raid1"},
+		{C.BTRFS_BLOCK_GROUP_DUP, "dup"},
+		{C.BTRFS_BLOCK_GROUP_RAID10, "raid10"},
+		{C.BTRFS_BLOCK_GROUP_RAID5, "raid5"},
+		{C.BTRFS_BLOCK_GROUP_RAID6, "raid6"},
+		{C.BTRFS_BLOCK_GROUP_RAID1C3, "raid1c3"},
+		{C.BTRFS_BLOCK_GROUP_RAID1C4, "raid1c4"},
+	}
+	for _, profile := range profiles {
+		if s.Flags&profile.flag != 0 {
+			return profile.name
+		}
+	}
+	return "single"
+}
+
+// GetSpaceInfo returns the allocation of the filesystem containing path
+// per block group type and profile, like btrfs filesystem df.
+func GetSpaceInfo(path string) ([]SpaceInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetSpaceInfoFd(file.Fd())
+}
+
+// See GetSpaceInfo.
+func GetSpaceInfoFd(fd uintptr) ([]SpaceInfo, error) {
+	var args C.struct_btrfs_ioctl_space_args
+
+	if err := ioctl(fd, C.BTRFS_IOC_SPACE_INFO, unsafe.Pointer(&args)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSpaceInfoFailed, err)
+	}
+
+	// struct btrfs_ioctl_space_args followed by total_spaces struct btrfs_ioctl_space_info.
+	n := int(args.total_spaces)
+	buf := make([]uint64, 2+3*n)
+	buf[0] = uint64(n)
+	if err := ioctl(fd, C.BTRFS_IOC_SPACE_INFO, unsafe.Pointer(&buf[0])); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSpaceInfoFailed, err)
+	}
+
+	n = int(buf[1])
+	if 2+3*n > len(buf) {
+		n = (len(buf) - 2) / 3
+	}
+	infos := make([]SpaceInfo, n)
+	for i := range infos {
+		infos[i] = SpaceInfo{buf[2+3*i], buf[3+3*i], buf[4+3*i]}
+	}
+	return infos, nil
+}
+
+// DeviceInfo is information about a device of a Btrfs filesystem.
+type DeviceInfo struct {
+	Id         uint64
+	UUID       UUID
+	BytesUsed  uint64
+	TotalBytes uint64
+	// Path is empty for a missing device.
+	Path string
+}
+
+// GetDevices returns the devices of the filesystem containing path.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func GetDevices(path string) ([]DeviceInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetDevicesFd(file.Fd())
+}
+
+// See GetDevices.
+func GetDevicesFd(fd uintptr) ([]DeviceInfo, error) {
+	fsInfo, err := GetFilesystemInfoFd(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []DeviceInfo
+	for id := uint64(1); id <= fsInfo.MaxId; id++ {
+		args := new(C.struct_btrfs_ioctl_dev_info_args)
+		args.devid = C.__u64(id)
+		if err := ioctl(fd, C.BTRFS_IOC_DEV_INFO, unsafe.Pointer(args)); err != nil {
+			// Device IDs of removed devices are not reused.
+			if err == syscall.ENODEV {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %v", ErrDevInfoFailed, err)
+		}
+
+		device := DeviceInfo{
+			Id:         uint64(args.devid),
+			BytesUsed:  uint64(args.bytes_used),
+			TotalBytes: uint64(args.total_bytes),
+		}
+		for i := range device.UUID {
+			device.UUID[i] = byte(args.uuid[i])
+		}
+		path := (*[C.BTRFS_DEVICE_PATH_NAME_MAX]byte)(unsafe.Pointer(&args.path[0]))[:]
+		if end := strings.IndexByte(string(path), 0); end >= 0 {
+			path = path[:end]
+		}
+		device.Path = string(path)
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// DeviceStats are the persistent I/O error counters of a device, like btrfs device stats.
+type DeviceStats struct {
+	WriteErrs      uint64
+	ReadErrs       uint64
+	FlushErrs      uint64
+	CorruptionErrs uint64
+	GenerationErrs uint64
+}
+
+// GetDeviceStats returns the error counters of the device with the given ID
+// in the filesystem containing path.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func GetDeviceStats(path string, devid uint64) (*DeviceStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetDeviceStatsFd(file.Fd(), devid)
+}
+
+// See GetDeviceStats.
+func GetDeviceStatsFd(fd uintptr, devid uint64) (*DeviceStats, error) {
+	args := new(C.struct_btrfs_ioctl_get_dev_stats)
+	args.devid = C.__u64(devid)
+	args.nr_items = C.BTRFS_DEV_STAT_VALUES_MAX
+
+	if err := ioctl(fd, C.BTRFS_IOC_GET_DEV_STATS, unsafe.Pointer(args)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDevStatsFailed, err)
+	}
+
+	// Counters beyond nr_items are not supported by the kernel and stay zero.
+	values := make([]uint64, C.BTRFS_DEV_STAT_VALUES_MAX)
+	for i := 0; i < int(args.nr_items) && i < len(values); i++ {
+		values[i] = uint64(args.values[i])
+	}
+	return &DeviceStats{
+		WriteErrs:      values[C.BTRFS_DEV_STAT_WRITE_ERRS],
+		ReadErrs:       values[C.BTRFS_DEV_STAT_READ_ERRS],
+		FlushErrs:      values[C.BTRFS_DEV_STAT_FLUSH_ERRS],
+		CorruptionErrs: values[C.BTRFS_DEV_STAT_CORRUPTION_ERRS],
+		GenerationErrs: values[C.BTRFS_DEV_STAT_GENERATION_ERRS],
+	}, nil
+}
+
+// Mountpoints returns one mountpoint of every mounted Btrfs filesystem.
+// Filesystems mounted more than once are returned with the mountpoint of their top-level subvolume if it is mounted,
+// and otherwise with the first mountpoint.
+func Mountpoints() ([]string, error) {
+	mounts, err := btrfsMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var mountpoints []string
+	index := make(map[string]int)
+	for _, mount := range mounts {
+		i, ok := index[mount.device]
+		if !ok {
+			index[mount.device] = len(mountpoints)
+			mountpoints = append(mountpoints, mount.mountpoint)
+			continue
+		}
+		if mount.root == "/" {
+			mountpoints[i] = mount.mountpoint
+		}
+	}
+	return mountpoints, nil
+}
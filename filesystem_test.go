@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"testing"
+)
+
+func TestSpaceInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		flags       uint64
+		wantType    string
+		wantProfile string
+	}{
+		{"data single", 1 << 0, "data", "single"},
+		{"metadata dup", 1<<2 | 1<<5, "metadata", "dup"},
+		{"system raid1", 1<<1 | 1<<4, "system", "raid1"},
+		{"mixed raid1c3", 1<<0 | 1<<2 | 1<<9, "mixed", "raid1c3"},
+		{"global reserve", 1<<49 | 1<<2, "globalreserve", "single"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := SpaceInfo{Flags: tt.flags}
+			if got := info.Type(); got != tt.wantType {
+				t.Errorf("Type() = %v, want %v", got, tt.wantType)
+			}
+			if got := info.Profile(); got != tt.wantProfile {
+				t.Errorf("Profile() = %v, want %v", got, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func TestFilesystemInfo(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	info, err := GetFilesystemInfo(mountpoint.path)
+	if err != nil {
+		t.Fatalf("GetFilesystemInfo() error = %v", err)
+	}
+	if info.FSID.IsZero() || info.NumDevices != 1 || info.MaxId != 1 || info.SectorSize == 0 || info.NodeSize == 0 {
+		t.Errorf("GetFilesystemInfo() = %+v", info)
+	}
+
+	spaces, err := GetSpaceInfo(mountpoint.path)
+	if err != nil {
+		t.Fatalf("GetSpaceInfo() error = %v", err)
+	}
+	types := make(map[string]bool)
+	for _, space := range spaces {
+		types[space.Type()] = true
+	}
+	if !types["data"] && !types["mixed"] {
+		t.Errorf("GetSpaceInfo() = %+v, want data block groups", spaces)
+	}
+
+	devices, err := GetDevices(mountpoint.path)
+	if err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+	if len(devices) != 1 || devices[0].Id != 1 || devices[0].Path == "" || devices[0].TotalBytes == 0 {
+		t.Fatalf("GetDevices() = %+v", devices)
+	}
+
+	stats, err := GetDeviceStats(mountpoint.path, devices[0].Id)
+	if err != nil {
+		t.Fatalf("GetDeviceStats() error = %v", err)
+	}
+	if *stats != (DeviceStats{}) {
+		t.Errorf("GetDeviceStats() = %+v, want no errors", stats)
+	}
+
+	if _, err := GetScrubProgress(mountpoint.path, devices[0].Id); err != ErrNotRunning {
+		t.Errorf("GetScrubProgress() error = %v, want %v", err, ErrNotRunning)
+	}
+	if _, err := GetBalanceProgress(mountpoint.path); err != ErrNotRunning {
+		t.Errorf("GetBalanceProgress() error = %v, want %v", err, ErrNotRunning)
+	}
+	if _, err := GetQgroups(mountpoint.path); err != ErrQuotaNotEnabled {
+		t.Errorf("GetQgroups() error = %v, want %v", err, ErrQuotaNotEnabled)
+	}
+}
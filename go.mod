@@ -1,3 +1,19 @@
 module github.com/sapphic-kitten/libbtrfsutil-go
 
-go 1.19
+go 1.21
+
+require (
+	github.com/container-storage-interface/spec v1.9.0
+	github.com/klauspost/compress v1.17.4
+	golang.org/x/crypto v0.11.0
+	golang.org/x/sys v0.10.0
+	google.golang.org/grpc v1.58.3
+	google.golang.org/protobuf v1.31.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.12.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230803162519-f966b187b2e5 // indirect
+)
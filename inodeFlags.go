@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/fs.h>
+import "C"
+import (
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// InodeFlags are the attribute flags of an inode as used by chattr(1).
+type InodeFlags uint32
+
+const (
+	// InodeNoCOW disables copy-on-write. It only takes effect on empty files
+	// and is inherited by files created in a directory.
+	InodeNoCOW InodeFlags = C.FS_NOCOW_FL
+	// InodeNoCompress disables compression, overriding the compress mount option.
+	InodeNoCompress InodeFlags = C.FS_NOCOMP_FL
+	// InodeCompress enables compression.
+	InodeCompress   InodeFlags = C.FS_COMPR_FL
+	InodeImmutable  InodeFlags = C.FS_IMMUTABLE_FL
+	InodeAppendOnly InodeFlags = C.FS_APPEND_FL
+	InodeNoDump     InodeFlags = C.FS_NODUMP_FL
+	InodeSync       InodeFlags = C.FS_SYNC_FL
+)
+
+// GetInodeFlags returns the attribute flags of a file or directory.
+func GetInodeFlags(path string) (InodeFlags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetInodeFlagsFd(file.Fd())
+}
+
+// See GetInodeFlags.
+func GetInodeFlagsFd(fd uintptr) (InodeFlags, error) {
+	var flags C.int
+
+	if err := ioctl(fd, C.FS_IOC_GETFLAGS, unsafe.Pointer(&flags)); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrGetFlagsFailed, err)
+	}
+	return InodeFlags(flags), nil
+}
+
+// SetInodeFlags replaces the attribute flags of a file or directory.
+// Flags not supported by Btrfs are rejected, InodeNoCOW is silently ignored
+// by the kernel for regular files that are not empty.
+func SetInodeFlags(path string, flags InodeFlags) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer file.Close()
+
+	return SetInodeFlagsFd(file.Fd(), flags)
+}
+
+// See SetInodeFlags.
+func SetInodeFlagsFd(fd uintptr, flags InodeFlags) error {
+	Cflags := C.int(flags)
+
+	if err := ioctl(fd, C.FS_IOC_SETFLAGS, unsafe.Pointer(&Cflags)); err != nil {
+		return fmt.Errorf("%w: %v", ErrSetFlagsFailed, err)
+	}
+	return nil
+}
+
+// MakeNoCOWDir creates a directory with copy-on-write disabled, so that all files
+// created beneath it are NOCOW from the start.
+// If path already exists it must be an empty directory, otherwise ErrDirectoryNotEmpty
+// is returned, as files already present would not be converted.
+func MakeNoCOWDir(path string) error {
+	if err := os.Mkdir(path, 0777); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer dir.Close()
+
+	if _, err := dir.Readdirnames(1); err != io.EOF {
+		if err == nil {
+			return ErrDirectoryNotEmpty
+		}
+		return err
+	}
+
+	flags, err := GetInodeFlagsFd(dir.Fd())
+	if err != nil {
+		return err
+	}
+	if flags&InodeNoCOW != 0 {
+		return nil
+	}
+
+	flags = flags&^InodeCompress | InodeNoCOW
+	if err := SetInodeFlagsFd(dir.Fd(), flags); err != nil {
+		return err
+	}
+
+	flags, err = GetInodeFlagsFd(dir.Fd())
+	if err != nil {
+		return err
+	}
+	if flags&InodeNoCOW == 0 {
+		return ErrSetFlagsFailed
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInodeFlags(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	tests := []struct {
+		name    string
+		flags   InodeFlags
+		wantErr bool
+	}{
+		{"nocow", InodeNoCOW, false},
+		{"nocompress", InodeNoCompress, false},
+		{"compress", InodeCompress, false},
+		{"nodump", InodeNoDump, false},
+		{"nocow+compress", InodeNoCOW | InodeCompress, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(mountpoint.path, tt.name)
+			if err := os.WriteFile(path, nil, 0660); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := SetInodeFlags(path, tt.flags); (err != nil) != tt.wantErr {
+				t.Errorf("SetInodeFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := GetInodeFlags(path)
+			if err != nil {
+				t.Errorf("GetInodeFlags() error = %v", err)
+				return
+			}
+			if got&tt.flags != tt.flags {
+				t.Errorf("GetInodeFlags() = %#x, want %#x set", got, tt.flags)
+			}
+		})
+	}
+}
+
+func TestMakeNoCOWDir(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	full := filepath.Join(mountpoint.path, "full")
+	os.Mkdir(full, 0770)
+	os.WriteFile(filepath.Join(full, "file"), nil, 0660)
+
+	empty := filepath.Join(mountpoint.path, "empty")
+	os.Mkdir(empty, 0770)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{"new", filepath.Join(mountpoint.path, "new"), nil},
+		{"empty", empty, nil},
+		{"full", full, ErrDirectoryNotEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := MakeNoCOWDir(tt.path); err != tt.wantErr {
+				t.Errorf("MakeNoCOWDir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			file := filepath.Join(tt.path, "file")
+			if err := os.WriteFile(file, []byte("data"), 0660); err != nil {
+				t.Fatal(err)
+			}
+			got, err := GetInodeFlags(file)
+			if err != nil {
+				t.Errorf("GetInodeFlags() error = %v", err)
+				return
+			}
+			if got&InodeNoCOW == 0 {
+				t.Errorf("GetInodeFlags() = %#x, want NoCOW inherited", got)
+			}
+		})
+	}
+}
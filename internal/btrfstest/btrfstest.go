@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package btrfstest provides Btrfs filesystems for the tests of the packages and commands,
+// like mountBtrfs does for the tests of libbtrfsutil-go.
+package btrfstest
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// Image returns the path of a new image file of the given size formatted by mkfs.btrfs,
+// which is called with args before the image. The test is skipped if mkfs.btrfs fails.
+func Image(t testing.TB, size int64, args ...string) string {
+	t.Helper()
+	image, err := os.CreateTemp("", "btrfstest-")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { os.Remove(image.Name()) })
+	err = image.Truncate(size)
+	image.Close()
+	if err != nil {
+		t.Skip(err)
+	}
+	if err := exec.Command("mkfs.btrfs", append(append([]string{"-q"}, args...), image.Name())...).Run(); err != nil {
+		t.Skip(err)
+	}
+	return image.Name()
+}
+
+// Mount returns the mountpoint of a new Btrfs filesystem of 1 GiB, see MountImage.
+func Mount(t testing.TB) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("must be run as root")
+	}
+	return MountImage(t, Image(t, 1024*1024*1024))
+}
+
+// MountImage mounts image on a loop device and returns the mountpoint, which is unmounted
+// when the test ends. The test is skipped unless it runs as root and image can be mounted.
+func MountImage(t testing.TB, image string) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("must be run as root")
+	}
+	mountpoint := t.TempDir()
+	if err := exec.Command("mount", "-o", "loop", image, mountpoint).Run(); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { exec.Command("umount", mountpoint).Run() })
+	return mountpoint
+}
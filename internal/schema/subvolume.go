@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package schema defines the JSON representations shared by the commands,
+// so that their output and the API of btrfsutild stay consistent.
+package schema
+
+import (
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+// SubvolReadOnly is BTRFS_ROOT_SUBVOL_RDONLY of SubvolumeInfo.Flags.
+const SubvolReadOnly = 1 << 0
+
+// Subvolume is the JSON schema of a subvolume, derived from btrfsutil.SubvolumeInfo.
+// Fields are only ever added to it. UUIDs and times which are not set are null, times are in UTC.
+type Subvolume struct {
+	Path         string          `json:"path"`
+	Id           uint64          `json:"id"`
+	ParentId     uint64          `json:"parent_id"`
+	DirId        uint64          `json:"dir_id"`
+	Flags        uint64          `json:"flags"`
+	ReadOnly     bool            `json:"read_only"`
+	UUID         *btrfsutil.UUID `json:"uuid"`
+	ParentUUID   *btrfsutil.UUID `json:"parent_uuid"`
+	ReceivedUUID *btrfsutil.UUID `json:"received_uuid"`
+	Generation   uint64          `json:"generation"`
+	Ctransid     uint64          `json:"ctransid"`
+	Otransid     uint64          `json:"otransid"`
+	Stransid     uint64          `json:"stransid"`
+	Rtransid     uint64          `json:"rtransid"`
+	Ctime        *time.Time      `json:"ctime"`
+	Otime        *time.Time      `json:"otime"`
+	Stime        *time.Time      `json:"stime"`
+	Rtime        *time.Time      `json:"rtime"`
+}
+
+// NewSubvolume returns the JSON representation of the subvolume at path.
+func NewSubvolume(path string, info *btrfsutil.SubvolumeInfo) *Subvolume {
+	return &Subvolume{
+		Path:         path,
+		Id:           info.Id,
+		ParentId:     info.ParentId,
+		DirId:        info.DirId,
+		Flags:        info.Flags,
+		ReadOnly:     info.Flags&SubvolReadOnly != 0,
+		UUID:         jsonUUID(info.UUID),
+		ParentUUID:   jsonUUID(info.ParentUUID),
+		ReceivedUUID: jsonUUID(info.ReceivedUUID),
+		Generation:   info.Generation,
+		Ctransid:     info.Ctransid,
+		Otransid:     info.Otransid,
+		Stransid:     info.Stransid,
+		Rtransid:     info.Rtransid,
+		Ctime:        jsonTime(info.Ctime),
+		Otime:        jsonTime(info.Otime),
+		Stime:        jsonTime(info.Stime),
+		Rtime:        jsonTime(info.Rtime),
+	}
+}
+
+func jsonUUID(uuid btrfsutil.UUID) *btrfsutil.UUID {
+	if uuid.IsZero() {
+		return nil
+	}
+	return &uuid
+}
+
+func jsonTime(t time.Time) *time.Time {
+	if t.IsZero() || t.Unix() == 0 {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
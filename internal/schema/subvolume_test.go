@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+func TestSubvolumeJSON(t *testing.T) {
+	info := &btrfsutil.SubvolumeInfo{
+		Id:         257,
+		ParentId:   5,
+		DirId:      256,
+		Flags:      SubvolReadOnly,
+		UUID:       btrfsutil.UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		Generation: 12,
+		Ctransid:   10,
+		Otransid:   11,
+		Ctime:      time.Date(2022, 6, 15, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		Otime:      time.Date(2022, 6, 15, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		Stime:      time.Unix(0, 0),
+	}
+
+	got, err := json.Marshal(NewSubvolume("snapshots/home", info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"path":"snapshots/home","id":257,"parent_id":5,"dir_id":256,"flags":1,"read_only":true,` +
+		`"uuid":"01234567-89ab-cdef-0123-456789abcdef","parent_uuid":null,"received_uuid":null,` +
+		`"generation":12,"ctransid":10,"otransid":11,"stransid":0,"rtransid":0,` +
+		`"ctime":"2022-06-15T12:30:00Z","otime":"2022-06-15T12:30:00Z","stime":null,"rtime":null}`
+	if string(got) != want {
+		t.Errorf("NewSubvolume() = %s, want %s", got, want)
+	}
+}
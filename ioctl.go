@@ -0,0 +1,41 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// ioctl issues an ioctl on fd and returns the resulting errno, if any.
+// Argument structures are taken from the kernel headers through cgo.
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, err := ioctlRet(fd, req, arg)
+	return err
+}
+
+// ioctlRet is like ioctl but also returns the value returned by the ioctl.
+func ioctlRet(fd uintptr, req uintptr, arg unsafe.Pointer) (uintptr, error) {
+	ret, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return ret, nil
+}
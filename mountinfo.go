@@ -0,0 +1,173 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mountInfo is an entry of /proc/self/mountinfo.
+type mountInfo struct {
+	// device is the major:minor pair of the filesystem.
+	device string
+	// root is the path of the mounted directory within the filesystem.
+	root       string
+	mountpoint string
+	fstype     string
+	source     string
+	options    string
+}
+
+// unescapeMountinfo decodes the octal escapes used for whitespace and backslashes in mountinfo.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func parseMountinfo(r io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+
+		mounts = append(mounts, mountInfo{
+			device:     fields[2],
+			root:       unescapeMountinfo(fields[3]),
+			mountpoint: unescapeMountinfo(fields[4]),
+			fstype:     fields[sep+1],
+			source:     unescapeMountinfo(fields[sep+2]),
+			options:    fields[5],
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// btrfsMounts returns all mounted Btrfs filesystems of the current mount namespace.
+func btrfsMounts() ([]mountInfo, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	mounts, err := parseMountinfo(file)
+	if err != nil {
+		return nil, err
+	}
+
+	btrfs := mounts[:0]
+	for _, mount := range mounts {
+		if mount.fstype == "btrfs" {
+			btrfs = append(btrfs, mount)
+		}
+	}
+	return btrfs, nil
+}
+
+// findMount returns the Btrfs mount containing path.
+func findMount(mounts []mountInfo, path string) (*mountInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	var found *mountInfo
+	for i := range mounts {
+		mount := &mounts[i]
+		if path != mount.mountpoint && !strings.HasPrefix(path, strings.TrimSuffix(mount.mountpoint, "/")+"/") {
+			continue
+		}
+		// Later entries over-mount earlier ones.
+		if found == nil || len(mount.mountpoint) >= len(found.mountpoint) {
+			found = mount
+		}
+	}
+	if found == nil {
+		return nil, ErrNotBtrfs
+	}
+	return found, nil
+}
+
+// mountedPath returns a path through which the subvolume at subvolPath, relative to the
+// top-level subvolume, is accessible. The mount containing path is preferred over other
+// mounts of the same filesystem.
+func mountedPath(path string, subvolPath string) (string, error) {
+	mounts, err := btrfsMounts()
+	if err != nil {
+		return "", err
+	}
+	mount, err := findMount(mounts, path)
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []mountInfo{*mount}
+	for _, other := range mounts {
+		if other.device == mount.device && other.mountpoint != mount.mountpoint {
+			candidates = append(candidates, other)
+		}
+	}
+
+	subvolPath = "/" + strings.Trim(subvolPath, "/")
+	for _, candidate := range candidates {
+		root := strings.TrimSuffix(candidate.root, "/")
+		if subvolPath != root && !strings.HasPrefix(subvolPath, root+"/") {
+			continue
+		}
+		mounted := filepath.Join(candidate.mountpoint, subvolPath[len(root):])
+		if _, err := os.Lstat(mounted); err == nil {
+			return mounted, nil
+		}
+	}
+	return "", ErrSubvolumeNotMounted
+}
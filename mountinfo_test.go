@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMountinfo = `22 1 0:21 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+35 22 0:32 /@home /home rw,noatime shared:12 - btrfs /dev/sdb1 rw,space_cache=v2,subvol=/@home
+36 22 0:32 / /mnt/pool rw,noatime shared:13 - btrfs /dev/sdb1 rw,space_cache=v2,subvolid=5,subvol=/
+37 35 0:33 /snap\040shots /home/with\040space rw master:2 - btrfs /dev/sdc1 rw
+garbage
+`
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatalf("parseMountinfo() error = %v", err)
+	}
+
+	want := []mountInfo{
+		{"0:21", "/", "/", "ext4", "/dev/sda1", "rw,relatime"},
+		{"0:32", "/@home", "/home", "btrfs", "/dev/sdb1", "rw,noatime"},
+		{"0:32", "/", "/mnt/pool", "btrfs", "/dev/sdb1", "rw,noatime"},
+		{"0:33", "/snap shots", "/home/with space", "btrfs", "/dev/sdc1", "rw"},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("parseMountinfo() = %v, want %v", mounts, want)
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatalf("parseMountinfo() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"mountpoint", "/home", "/home", false},
+		{"below mountpoint", "/home/user/file", "/home", false},
+		{"nested mount", "/home/with space/dir", "/home/with space", false},
+		{"prefix of mountpoint", "/homework", "", true},
+		{"not btrfs", "/etc", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var btrfs []mountInfo
+			for _, mount := range mounts {
+				if mount.fstype == "btrfs" {
+					btrfs = append(btrfs, mount)
+				}
+			}
+
+			got, err := findMount(btrfs, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findMount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.mountpoint != tt.want {
+				t.Errorf("findMount() = %v, want %v", got.mountpoint, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	chunkItemSize = 48
+	stripeSize    = 32
+)
+
+// Block group types and profiles of chunks, see linux/btrfs_tree.h.
+const (
+	blockGroupData     = C.BTRFS_BLOCK_GROUP_DATA
+	blockGroupSystem   = C.BTRFS_BLOCK_GROUP_SYSTEM
+	blockGroupMetadata = C.BTRFS_BLOCK_GROUP_METADATA
+	blockGroupRaid0    = C.BTRFS_BLOCK_GROUP_RAID0
+	blockGroupRaid1    = C.BTRFS_BLOCK_GROUP_RAID1
+	blockGroupDup      = C.BTRFS_BLOCK_GROUP_DUP
+	blockGroupRaid1c3  = C.BTRFS_BLOCK_GROUP_RAID1C3
+	blockGroupRaid1c4  = C.BTRFS_BLOCK_GROUP_RAID1C4
+	blockGroupProfiles = C.BTRFS_BLOCK_GROUP_PROFILE_MASK
+
+	// mirrorProfiles store a full copy of the chunk in each stripe.
+	mirrorProfiles = blockGroupDup | blockGroupRaid1 | blockGroupRaid1c3 | blockGroupRaid1c4
+)
+
+// stripe is a copy of a chunk on a device, see struct btrfs_stripe.
+type stripe struct {
+	devid  uint64
+	offset uint64
+}
+
+// chunk maps a range of logical addresses to the devices, see struct btrfs_chunk.
+type chunk struct {
+	logical uint64
+	length  uint64
+	typ     uint64
+	stripes []stripe
+}
+
+// parseChunk decodes the struct btrfs_chunk of the chunk at logical and returns its size on disk.
+func parseChunk(logical uint64, data []byte) (chunk, int, error) {
+	if len(data) < chunkItemSize {
+		return chunk{}, 0, ErrInvalidChunk
+	}
+	le := binary.LittleEndian
+	c := chunk{
+		logical: logical,
+		length:  le.Uint64(data[0:]),
+		typ:     le.Uint64(data[24:]),
+	}
+	n := int(le.Uint16(data[44:]))
+	size := chunkItemSize + n*stripeSize
+	if n == 0 || len(data) < size || c.length == 0 {
+		return chunk{}, 0, fmt.Errorf("%w at %d", ErrInvalidChunk, logical)
+	}
+	for i := 0; i < n; i++ {
+		s := data[chunkItemSize+i*stripeSize:]
+		c.stripes = append(c.stripes, stripe{devid: le.Uint64(s[0:]), offset: le.Uint64(s[8:])})
+	}
+	return c, size, nil
+}
+
+// addChunk adds a chunk to the chunk map unless it is already known.
+func (fs *Filesystem) addChunk(c chunk) {
+	i := sort.Search(len(fs.chunks), func(i int) bool { return fs.chunks[i].logical >= c.logical })
+	if i < len(fs.chunks) && fs.chunks[i].logical == c.logical {
+		return
+	}
+	fs.chunks = append(fs.chunks, chunk{})
+	copy(fs.chunks[i+1:], fs.chunks[i:])
+	fs.chunks[i] = c
+}
+
+// loadChunks bootstraps the chunk map from the system chunks in the superblock,
+// which map the chunk tree, and then reads the chunk tree.
+func (fs *Filesystem) loadChunks() error {
+	array := fs.Superblock.SysChunkArray
+	for len(array) > 0 {
+		if len(array) < diskKeySize {
+			return fmt.Errorf("%w: truncated sys_chunk_array", ErrInvalidChunk)
+		}
+		key := parseKey(array)
+		if key.Type != ChunkItemKey {
+			return fmt.Errorf("%w: key %v in sys_chunk_array", ErrInvalidChunk, key)
+		}
+		c, size, err := parseChunk(key.Offset, array[diskKeySize:])
+		if err != nil {
+			return err
+		}
+		fs.addChunk(c)
+		array = array[diskKeySize+size:]
+	}
+
+	min := Key{FirstChunkTreeObjectid, ChunkItemKey, 0}
+	max := Key{FirstChunkTreeObjectid, ChunkItemKey, ^uint64(0)}
+	return fs.ChunkTree().Search(min, max, func(item *Item) error {
+		c, _, err := parseChunk(item.Offset, item.Data)
+		if err != nil {
+			return err
+		}
+		fs.addChunk(c)
+		return nil
+	})
+}
+
+// Physical returns the offsets on the device of all copies of the given logical address.
+// It returns ErrDeviceMissing if no copy is stored on the device the filesystem was opened from.
+func (fs *Filesystem) Physical(logical uint64) ([]uint64, error) {
+	i := sort.Search(len(fs.chunks), func(i int) bool { return fs.chunks[i].logical > logical }) - 1
+	if i < 0 || logical-fs.chunks[i].logical >= fs.chunks[i].length {
+		return nil, fmt.Errorf("%w: %d", ErrNotMapped, logical)
+	}
+	c := fs.chunks[i]
+	if profile := c.typ & blockGroupProfiles; profile != 0 && profile&mirrorProfiles == 0 {
+		return nil, fmt.Errorf("%w: %#x", ErrUnsupportedProfile, profile)
+	}
+
+	var physical []uint64
+	for _, s := range c.stripes {
+		if s.devid == fs.Superblock.Device.Devid {
+			physical = append(physical, s.offset+logical-c.logical)
+		}
+	}
+	if len(physical) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrDeviceMissing, logical)
+	}
+	return physical, nil
+}
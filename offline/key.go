@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import "fmt"
+
+// Object IDs of the trees and items referred to by this package, see linux/btrfs_tree.h.
+const (
+	RootTreeObjectid       = C.BTRFS_ROOT_TREE_OBJECTID
+	ExtentTreeObjectid     = C.BTRFS_EXTENT_TREE_OBJECTID
+	ChunkTreeObjectid      = C.BTRFS_CHUNK_TREE_OBJECTID
+	DevTreeObjectid        = C.BTRFS_DEV_TREE_OBJECTID
+	FsTreeObjectid         = C.BTRFS_FS_TREE_OBJECTID
+	RootTreeDirObjectid    = C.BTRFS_ROOT_TREE_DIR_OBJECTID
+	CsumTreeObjectid       = C.BTRFS_CSUM_TREE_OBJECTID
+	UUIDTreeObjectid       = C.BTRFS_UUID_TREE_OBJECTID
+	FirstFreeObjectid      = C.BTRFS_FIRST_FREE_OBJECTID
+	LastFreeObjectid       = C.BTRFS_LAST_FREE_OBJECTID
+	FirstChunkTreeObjectid = C.BTRFS_FIRST_CHUNK_TREE_OBJECTID
+)
+
+// Item types referred to by this package, see linux/btrfs_tree.h.
+const (
+	InodeItemKey   = C.BTRFS_INODE_ITEM_KEY
+	InodeRefKey    = C.BTRFS_INODE_REF_KEY
+	DirItemKey     = C.BTRFS_DIR_ITEM_KEY
+	DirIndexKey    = C.BTRFS_DIR_INDEX_KEY
+	RootItemKey    = C.BTRFS_ROOT_ITEM_KEY
+	RootBackrefKey = C.BTRFS_ROOT_BACKREF_KEY
+	RootRefKey     = C.BTRFS_ROOT_REF_KEY
+	DevItemKey     = C.BTRFS_DEV_ITEM_KEY
+	ChunkItemKey   = C.BTRFS_CHUNK_ITEM_KEY
+)
+
+// Key is the key of an item in a tree, see struct btrfs_disk_key.
+// Keys sort by object ID, type and offset.
+type Key struct {
+	Objectid uint64
+	Type     uint8
+	Offset   uint64
+}
+
+// MinKey and MaxKey bound the keys of all items.
+var (
+	MinKey = Key{}
+	MaxKey = Key{^uint64(0), ^uint8(0), ^uint64(0)}
+)
+
+// Compare returns -1, 0 or 1 if k sorts before, equal to or after other.
+func (k Key) Compare(other Key) int {
+	switch {
+	case k.Objectid != other.Objectid:
+		return compare(k.Objectid, other.Objectid)
+	case k.Type != other.Type:
+		return compare(uint64(k.Type), uint64(other.Type))
+	}
+	return compare(k.Offset, other.Offset)
+}
+
+func compare(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// String returns the key in the form "(objectid type offset)" like btrfs inspect-internal.
+func (k Key) String() string {
+	return fmt.Sprintf("(%d %d %d)", k.Objectid, k.Type, k.Offset)
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+const (
+	headerSize  = 101
+	itemSize    = 25
+	keyPtrSize  = 33
+	diskKeySize = 17
+	// maxLevel is BTRFS_MAX_LEVEL, the maximum height of a tree.
+	maxLevel = 8
+)
+
+// Header is the header of a tree node, see struct btrfs_header.
+type Header struct {
+	Checksum      [btrfsutil.ChecksumSize]byte
+	Fsid          btrfsutil.UUID
+	Bytenr        uint64
+	Flags         uint64
+	ChunkTreeUUID btrfsutil.UUID
+	Generation    uint64
+	Owner         uint64
+	Nritems       uint32
+	Level         uint8
+}
+
+// Item is an item in a leaf.
+type Item struct {
+	Key
+	// Data holds the item as stored on disk, i.e. in little-endian byte order.
+	Data []byte
+}
+
+// KeyPtr points from an internal node to a child node, whose items sort at or after Key.
+type KeyPtr struct {
+	Key
+	Blockptr   uint64
+	Generation uint64
+}
+
+// Node is a tree node. Leaves, at level 0, hold Items, all other nodes KeyPtrs.
+type Node struct {
+	Header
+	Items []Item
+	Ptrs  []KeyPtr
+}
+
+func parseKey(data []byte) Key {
+	return Key{
+		Objectid: binary.LittleEndian.Uint64(data[0:]),
+		Type:     data[8],
+		Offset:   binary.LittleEndian.Uint64(data[9:]),
+	}
+}
+
+// ReadNode reads and verifies the tree node at a logical address.
+// Each copy of the node on the device is tried until one verifies.
+func (fs *Filesystem) ReadNode(logical uint64) (*Node, error) {
+	physical, err := fs.Physical(logical)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, fs.Superblock.Nodesize)
+	for _, offset := range physical {
+		if _, err = fs.r.ReadAt(data, int64(offset)); err != nil {
+			err = fmt.Errorf("%w at %d: %v", ErrInvalidNode, logical, err)
+			continue
+		}
+		var node *Node
+		if node, err = fs.parseNode(data, logical); err == nil {
+			return node, nil
+		}
+	}
+	return nil, err
+}
+
+// parseNode verifies and decodes a node read from logical.
+func (fs *Filesystem) parseNode(data []byte, logical uint64) (*Node, error) {
+	sum, err := fs.Superblock.ChecksumType.Sum(data[btrfsutil.ChecksumSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	node := &Node{Header: Header{
+		Bytenr:     le.Uint64(data[48:]),
+		Flags:      le.Uint64(data[56:]),
+		Generation: le.Uint64(data[80:]),
+		Owner:      le.Uint64(data[88:]),
+		Nritems:    le.Uint32(data[96:]),
+		Level:      data[100],
+	}}
+	copy(node.Checksum[:], data[0:32])
+	copy(node.Fsid[:], data[32:48])
+	copy(node.ChunkTreeUUID[:], data[64:80])
+
+	if sum != node.Checksum {
+		return nil, fmt.Errorf("%w at %d", ErrNodeChecksum, logical)
+	}
+	if node.Bytenr != logical || node.Fsid != fs.Superblock.MetadataUUID || node.Level >= maxLevel {
+		return nil, fmt.Errorf("%w at %d", ErrInvalidNode, logical)
+	}
+
+	n := int(node.Nritems)
+	if node.Level > 0 {
+		if headerSize+n*keyPtrSize > len(data) {
+			return nil, fmt.Errorf("%w at %d: %d key pointers", ErrInvalidNode, logical, n)
+		}
+		node.Ptrs = make([]KeyPtr, n)
+		for i := range node.Ptrs {
+			ptr := data[headerSize+i*keyPtrSize:]
+			node.Ptrs[i] = KeyPtr{
+				Key:        parseKey(ptr),
+				Blockptr:   le.Uint64(ptr[diskKeySize:]),
+				Generation: le.Uint64(ptr[diskKeySize+8:]),
+			}
+		}
+		return node, nil
+	}
+
+	if headerSize+n*itemSize > len(data) {
+		return nil, fmt.Errorf("%w at %d: %d items", ErrInvalidNode, logical, n)
+	}
+	node.Items = make([]Item, n)
+	for i := range node.Items {
+		item := data[headerSize+i*itemSize:]
+		offset := le.Uint32(item[diskKeySize:])
+		size := le.Uint32(item[diskKeySize+4:])
+		if uint64(offset)+uint64(size) > uint64(len(data)-headerSize) {
+			return nil, fmt.Errorf("%w at %d: item %d out of bounds", ErrInvalidNode, logical, i)
+		}
+		start := headerSize + int(offset)
+		node.Items[i] = Item{Key: parseKey(item), Data: data[start : start+int(size) : start+int(size)]}
+	}
+	return node, nil
+}
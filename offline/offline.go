@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package offline reads Btrfs filesystems from images and block devices which are not mounted.
+// It needs neither a mount nor privileges beyond read access to the device.
+//
+// Opening a filesystem reads the superblock and bootstraps the chunk map from the system chunks
+// in the superblock and the chunk tree, which translates the logical addresses of tree nodes into
+// offsets on the device. Only the single, DUP and RAID1 profiles are supported, and only copies
+// on the opened device are read. Every node is verified against its checksum before it is used.
+package offline
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+var (
+	ErrInvalidChunk       = errors.New("invalid chunk item")
+	ErrNotMapped          = errors.New("logical address is not mapped by any chunk")
+	ErrUnsupportedProfile = errors.New("unsupported block group profile")
+	ErrDeviceMissing      = errors.New("no copy of the block group on this device")
+	ErrInvalidNode        = errors.New("invalid tree node")
+	ErrNodeChecksum       = errors.New("tree node checksum mismatch")
+	ErrTransidMismatch    = errors.New("tree node generation mismatch")
+	ErrTreeNotFound       = errors.New("tree not found")
+	ErrInvalidItem        = errors.New("invalid tree item")
+)
+
+// Filesystem is a Btrfs filesystem on a device or image which is read without mounting it.
+type Filesystem struct {
+	// Superblock is the superblock the filesystem was opened with.
+	Superblock *btrfsutil.Superblock
+
+	r      io.ReaderAt
+	closer io.Closer
+	chunks []chunk
+}
+
+// Open opens the Btrfs image or block device at path read-only.
+func Open(path string) (*Filesystem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := New(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fs.closer = file
+	return fs, nil
+}
+
+// New reads the Btrfs filesystem of a device or image from r.
+// It uses the primary superblock, or the first mirror which verifies if the primary is damaged.
+func New(r io.ReaderAt) (*Filesystem, error) {
+	supers, err := btrfsutil.ReadSuperblockMirrors(r)
+	fs := &Filesystem{r: r}
+	for _, super := range supers {
+		if super != nil {
+			fs.Superblock = super
+			break
+		}
+	}
+	if fs.Superblock == nil {
+		return nil, err
+	}
+
+	if err := fs.loadChunks(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Close closes the device if the filesystem was opened with Open.
+func (fs *Filesystem) Close() error {
+	if fs.closer == nil {
+		return nil
+	}
+	return fs.closer.Close()
+}
@@ -0,0 +1,341 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+)
+
+const (
+	testNodesize   = 4096
+	testGeneration = 9
+	testFsid       = 0x42
+	// The system chunk is DUP at 128 KiB and 192 KiB, the metadata chunk single at 256 KiB.
+	testSysLogical  = 1 << 20
+	testMetaLogical = 2 << 20
+	testMetaOffset  = 256 << 10
+)
+
+func putKey(data []byte, key Key) {
+	binary.LittleEndian.PutUint64(data[0:], key.Objectid)
+	data[8] = key.Type
+	binary.LittleEndian.PutUint64(data[9:], key.Offset)
+}
+
+// testChunk encodes a struct btrfs_chunk of the given type with one stripe per offset on devid.
+func testChunk(length, typ, devid uint64, offsets ...uint64) []byte {
+	data := make([]byte, chunkItemSize+len(offsets)*stripeSize)
+	binary.LittleEndian.PutUint64(data[0:], length)
+	binary.LittleEndian.PutUint64(data[24:], typ)
+	binary.LittleEndian.PutUint16(data[44:], uint16(len(offsets)))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint64(data[chunkItemSize+i*stripeSize:], devid)
+		binary.LittleEndian.PutUint64(data[chunkItemSize+i*stripeSize+8:], offset)
+	}
+	return data
+}
+
+// testRootItem encodes a struct btrfs_root_item of a tree rooted at bytenr.
+func testRootItem(bytenr uint64, level uint8, generation uint64) []byte {
+	data := make([]byte, 439)
+	binary.LittleEndian.PutUint64(data[160:], generation)
+	binary.LittleEndian.PutUint64(data[176:], bytenr)
+	data[238] = level
+	return data
+}
+
+// testNode encodes a checksummed tree node.
+func testNode(t *testing.T, logical uint64, generation uint64, level uint8, items []Item, ptrs []KeyPtr) []byte {
+	data := make([]byte, testNodesize)
+	le := binary.LittleEndian
+	data[32] = testFsid
+	le.PutUint64(data[48:], logical)
+	le.PutUint64(data[80:], generation)
+	data[100] = level
+	if level > 0 {
+		le.PutUint32(data[96:], uint32(len(ptrs)))
+		for i, ptr := range ptrs {
+			p := data[headerSize+i*keyPtrSize:]
+			putKey(p, ptr.Key)
+			le.PutUint64(p[diskKeySize:], ptr.Blockptr)
+			le.PutUint64(p[diskKeySize+8:], ptr.Generation)
+		}
+	} else {
+		le.PutUint32(data[96:], uint32(len(items)))
+		end := testNodesize - headerSize
+		for i, item := range items {
+			end -= len(item.Data)
+			p := data[headerSize+i*itemSize:]
+			putKey(p, item.Key)
+			le.PutUint32(p[diskKeySize:], uint32(end))
+			le.PutUint32(p[diskKeySize+4:], uint32(len(item.Data)))
+			copy(data[headerSize+end:], item.Data)
+		}
+	}
+	sum, err := btrfsutil.ChecksumCRC32C.Sum(data[btrfsutil.ChecksumSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data, sum[:])
+	return data
+}
+
+// testTreeLogical returns the logical address of the i-th tree built by buildImage besides the root tree.
+func testTreeLogical(i int) uint64 {
+	return testMetaLogical + uint64(8+i)*testNodesize
+}
+
+// buildImage returns an image with a chunk tree, a root tree of two levels with the given leaves
+// and a leaf for each of trees at testTreeLogical.
+func buildImage(t *testing.T, rootLeaves [][]Item, trees [][]Item) []byte {
+	image := make([]byte, 1<<20)
+	le := binary.LittleEndian
+
+	sysChunk := testChunk(64<<10, blockGroupSystem|blockGroupDup, 1, 128<<10, 192<<10)
+	chunks := []Item{
+		{Key{FirstChunkTreeObjectid, ChunkItemKey, testSysLogical}, sysChunk},
+		{Key{FirstChunkTreeObjectid, ChunkItemKey, testMetaLogical}, testChunk(64<<10, blockGroupMetadata, 1, testMetaOffset)},
+		{Key{FirstChunkTreeObjectid, ChunkItemKey, 4 << 20}, testChunk(64<<10, blockGroupData|blockGroupRaid0, 1, 512<<10, 576<<10)},
+		{Key{FirstChunkTreeObjectid, ChunkItemKey, 5 << 20}, testChunk(64<<10, blockGroupData, 2, 0)},
+	}
+	chunkTree := testNode(t, testSysLogical, testGeneration-1, 0, chunks, nil)
+	// The first copy is damaged, so the second must be used.
+	copy(image[192<<10:], chunkTree)
+	image[128<<10] = 1
+
+	var ptrs []KeyPtr
+	for i, leaf := range rootLeaves {
+		logical := testMetaLogical + uint64(i+1)*testNodesize
+		ptrs = append(ptrs, KeyPtr{leaf[0].Key, logical, testGeneration})
+		copy(image[testMetaOffset+(i+1)*testNodesize:], testNode(t, logical, testGeneration, 0, leaf, nil))
+	}
+	copy(image[testMetaOffset:], testNode(t, testMetaLogical, testGeneration, 1, nil, ptrs))
+	for i, tree := range trees {
+		offset := testMetaOffset + testTreeLogical(i) - testMetaLogical
+		copy(image[offset:], testNode(t, testTreeLogical(i), testGeneration, 0, tree, nil))
+	}
+
+	super := image[btrfsutil.SuperblockOffset(0):]
+	super[32] = testFsid
+	le.PutUint64(super[48:], uint64(btrfsutil.SuperblockOffset(0)))
+	copy(super[64:], "_BHRfS_M")
+	le.PutUint64(super[72:], testGeneration)
+	le.PutUint64(super[80:], testMetaLogical)
+	le.PutUint64(super[88:], testSysLogical)
+	le.PutUint32(super[144:], 4096)
+	le.PutUint32(super[148:], testNodesize)
+	le.PutUint32(super[160:], uint32(diskKeySize+len(sysChunk)))
+	le.PutUint64(super[164:], testGeneration-1)
+	super[198] = 1
+	le.PutUint64(super[201:], 1)
+	putKey(super[811:], chunks[0].Key)
+	copy(super[811+diskKeySize:], sysChunk)
+	sum, _ := btrfsutil.ChecksumCRC32C.Sum(super[btrfsutil.ChecksumSize:btrfsutil.SuperblockSize])
+	copy(super, sum[:])
+	return image
+}
+
+// testImage returns an image with a root tree of two leaves and a filesystem tree.
+func testImage(t *testing.T) []byte {
+	leaves := [][]Item{
+		{
+			{Key{ExtentTreeObjectid, RootItemKey, 0}, testRootItem(0, 0, 0)},
+			{Key{FsTreeObjectid, RootItemKey, 0}, testRootItem(testTreeLogical(0), 0, testGeneration)},
+		},
+		{
+			{Key{RootTreeDirObjectid, InodeItemKey, 0}, make([]byte, 160)},
+			{Key{FirstFreeObjectid, RootItemKey, 0}, testRootItem(0, 0, 0)},
+			{Key{FirstFreeObjectid, RootItemKey, 12}, testRootItem(testTreeLogical(0), 0, testGeneration)},
+		},
+	}
+	fsTree := []Item{
+		{Key{256, InodeItemKey, 0}, make([]byte, 160)},
+		{Key{256, InodeRefKey, 256}, []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, '.', '.'}},
+	}
+	return buildImage(t, leaves, [][]Item{fsTree})
+}
+
+func TestPhysical(t *testing.T) {
+	fs, err := New(bytes.NewReader(testImage(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		logical uint64
+		want    []uint64
+		wantErr error
+	}{
+		{"dup", testSysLogical + 100, []uint64{128<<10 + 100, 192<<10 + 100}, nil},
+		{"single", testMetaLogical + 4096, []uint64{testMetaOffset + 4096}, nil},
+		{"end of chunk", testMetaLogical + 64<<10, nil, ErrNotMapped},
+		{"before first chunk", 4096, nil, ErrNotMapped},
+		{"raid0", 4 << 20, nil, ErrUnsupportedProfile},
+		{"other device", 5 << 20, nil, ErrDeviceMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fs.Physical(tt.logical)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Physical() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Physical() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	fs, err := New(bytes.NewReader(testImage(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	fsTree, err := fs.Tree(FsTreeObjectid)
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+	subvol, err := fs.Tree(FirstFreeObjectid)
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		tree     *Tree
+		min, max Key
+		want     []Key
+	}{
+		{"all", fs.RootTree(), MinKey, MaxKey, []Key{
+			{ExtentTreeObjectid, RootItemKey, 0},
+			{FsTreeObjectid, RootItemKey, 0},
+			{RootTreeDirObjectid, InodeItemKey, 0},
+			{FirstFreeObjectid, RootItemKey, 0},
+			{FirstFreeObjectid, RootItemKey, 12},
+		}},
+		{"across leaves", fs.RootTree(), Key{FsTreeObjectid, 0, 0}, Key{RootTreeDirObjectid, MaxKey.Type, 0}, []Key{
+			{FsTreeObjectid, RootItemKey, 0},
+			{RootTreeDirObjectid, InodeItemKey, 0},
+		}},
+		{"second leaf", fs.RootTree(), Key{FirstFreeObjectid, 0, 0}, MaxKey, []Key{
+			{FirstFreeObjectid, RootItemKey, 0},
+			{FirstFreeObjectid, RootItemKey, 12},
+		}},
+		{"empty", fs.RootTree(), Key{100, 0, 0}, Key{200, 0, 0}, nil},
+		{"chunk tree", fs.ChunkTree(), Key{FirstChunkTreeObjectid, ChunkItemKey, testMetaLogical}, Key{FirstChunkTreeObjectid, ChunkItemKey, testMetaLogical}, []Key{
+			{FirstChunkTreeObjectid, ChunkItemKey, testMetaLogical},
+		}},
+		{"fs tree", fsTree, MinKey, MaxKey, []Key{{256, InodeItemKey, 0}, {256, InodeRefKey, 256}}},
+		{"latest root item", subvol, Key{256, InodeRefKey, 0}, MaxKey, []Key{{256, InodeRefKey, 256}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Key
+			err := tt.tree.Search(tt.min, tt.max, func(item *Item) error {
+				got = append(got, item.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errStop := errors.New("stop")
+	count := 0
+	err = fs.RootTree().Search(MinKey, MaxKey, func(item *Item) error {
+		count++
+		return errStop
+	})
+	if err != errStop || count != 1 {
+		t.Errorf("Search() = %v after %d items, want %v after 1", err, count, errStop)
+	}
+
+	if _, err := fs.Tree(1000); !errors.Is(err, ErrTreeNotFound) {
+		t.Errorf("Tree() error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestSearchCorrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(image []byte)
+		wantErr error
+	}{
+		{"checksum", func(image []byte) { image[testMetaOffset+2*testNodesize+500] ^= 1 }, ErrNodeChecksum},
+		{"generation", func(image []byte) {
+			copy(image[testMetaOffset+2*testNodesize:], testNode(t, testMetaLogical+2*testNodesize, testGeneration-1, 0, nil, nil))
+		}, ErrTransidMismatch},
+		{"bytenr", func(image []byte) {
+			copy(image[testMetaOffset+2*testNodesize:], testNode(t, testMetaLogical+testNodesize, testGeneration, 0, nil, nil))
+		}, ErrInvalidNode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := testImage(t)
+			tt.corrupt(image)
+			fs, err := New(bytes.NewReader(image))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			err = fs.RootTree().Search(MinKey, MaxKey, func(item *Item) error { return nil })
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Search() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	image := btrfstest.Image(t, 256*1024*1024, "-L", "offline")
+
+	fs, err := Open(image)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer fs.Close()
+	if fs.Superblock.Label != "offline" {
+		t.Errorf("Open() label = %q, want offline", fs.Superblock.Label)
+	}
+
+	fsTree, err := fs.Tree(FsTreeObjectid)
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+	found := false
+	err = fsTree.Search(Key{FirstFreeObjectid, InodeItemKey, 0}, Key{FirstFreeObjectid, InodeItemKey, 0}, func(item *Item) error {
+		found = true
+		return nil
+	})
+	if err != nil || !found {
+		t.Errorf("Search() of top-level directory = %v, %v", found, err)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+// rootItemSize is the size of a struct btrfs_root_item. Filesystems created before Linux 3.5
+// may have shorter items without UUIDs and times.
+const rootItemSize = 439
+
+// errStop ends a search early.
+var errStop = errors.New("stop search")
+
+// Subvolumes returns all subvolumes beneath the top-level subvolume in pre-order, with paths relative
+// to the top-level subvolume, like a SubvolumeInfoIterator created for the top-level subvolume.
+// Deleted subvolumes which have not been cleaned up yet are not returned.
+func (fs *Filesystem) Subvolumes() ([]*btrfsutil.SubvolumeInfoIteratorResult, error) {
+	var results []*btrfsutil.SubvolumeInfoIteratorResult
+	visited := map[uint64]bool{FsTreeObjectid: true}
+	if err := fs.subvolumes(FsTreeObjectid, "", visited, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// OfflineSubvolumes opens the Btrfs image or block device at image and returns its subvolumes,
+// see Filesystem.Subvolumes.
+func OfflineSubvolumes(image string) ([]*btrfsutil.SubvolumeInfoIteratorResult, error) {
+	fs, err := Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+
+	return fs.Subvolumes()
+}
+
+// rootRef is a reference from a subvolume to a subvolume within it, see struct btrfs_root_ref.
+type rootRef struct {
+	id    uint64
+	dirid uint64
+	name  string
+}
+
+// subvolumes appends the subvolumes beneath the subvolume with the given ID and path to results.
+// Every subvolume has a single reference, so one seen before in visited means a loop.
+func (fs *Filesystem) subvolumes(id uint64, dir string, visited map[uint64]bool, results *[]*btrfsutil.SubvolumeInfoIteratorResult) error {
+	var refs []rootRef
+	err := fs.RootTree().Search(Key{id, RootRefKey, 0}, Key{id, RootRefKey, ^uint64(0)}, func(item *Item) error {
+		name, err := refName(item.Data, 16)
+		if err != nil {
+			return fmt.Errorf("%w: root ref %v", err, item.Key)
+		}
+		refs = append(refs, rootRef{item.Offset, binary.LittleEndian.Uint64(item.Data), name})
+		return nil
+	})
+	if err != nil || len(refs) == 0 {
+		return err
+	}
+
+	tree, err := fs.Tree(id)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if visited[ref.id] {
+			return fmt.Errorf("%w: subvolume loop at subvolume %d", ErrInvalidItem, ref.id)
+		}
+		visited[ref.id] = true
+		root, err := fs.rootItem(ref.id)
+		if err != nil {
+			return err
+		}
+		dirPath, err := inodePath(tree, ref.dirid)
+		if err != nil {
+			return err
+		}
+
+		info := parseRootItem(root)
+		info.Id, info.ParentId, info.DirId = ref.id, id, ref.dirid
+		subvolPath := path.Join(dir, dirPath, ref.name)
+		*results = append(*results, &btrfsutil.SubvolumeInfoIteratorResult{Path: subvolPath, Info: info})
+		if err := fs.subvolumes(ref.id, subvolPath, visited, results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refName returns the name of a struct btrfs_root_ref or btrfs_inode_ref,
+// whose name length is at the given offset and followed by the name.
+func refName(data []byte, offset int) (string, error) {
+	if len(data) < offset+2 {
+		return "", ErrInvalidItem
+	}
+	n := int(binary.LittleEndian.Uint16(data[offset:]))
+	if len(data) < offset+2+n {
+		return "", ErrInvalidItem
+	}
+	return string(data[offset+2 : offset+2+n]), nil
+}
+
+// inodePath returns the path of a directory relative to the root directory of its subvolume.
+func inodePath(tree *Tree, ino uint64) (string, error) {
+	var names []string
+	for ino != FirstFreeObjectid {
+		if len(names) > 4096 {
+			return "", fmt.Errorf("%w: directory loop at inode %d", ErrInvalidItem, ino)
+		}
+		var parent uint64
+		var name string
+		err := tree.Search(Key{ino, InodeRefKey, 0}, Key{ino, InodeRefKey, ^uint64(0)}, func(item *Item) error {
+			var err error
+			parent = item.Offset
+			if name, err = refName(item.Data, 8); err != nil {
+				return fmt.Errorf("%w: inode ref %v", err, item.Key)
+			}
+			// Directories have a single reference.
+			return errStop
+		})
+		if err != nil && err != errStop {
+			return "", err
+		}
+		if name == "" {
+			return "", fmt.Errorf("%w: no reference to inode %d", ErrInvalidItem, ino)
+		}
+		names = append(names, name)
+		ino = parent
+	}
+
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return path.Join(names...), nil
+}
+
+// parseRootItem decodes the fields of a struct btrfs_root_item which SubvolumeInfo holds.
+func parseRootItem(data []byte) *btrfsutil.SubvolumeInfo {
+	le := binary.LittleEndian
+	info := &btrfsutil.SubvolumeInfo{
+		Generation: le.Uint64(data[160:]),
+		Flags:      le.Uint64(data[208:]),
+	}
+	if len(data) < rootItemSize {
+		return info
+	}
+
+	timespec := func(data []byte) time.Time {
+		return time.Unix(int64(le.Uint64(data)), int64(le.Uint32(data[8:])))
+	}
+	copy(info.UUID[:], data[247:263])
+	copy(info.ParentUUID[:], data[263:279])
+	copy(info.ReceivedUUID[:], data[279:295])
+	info.Ctransid = le.Uint64(data[295:])
+	info.Otransid = le.Uint64(data[303:])
+	info.Stransid = le.Uint64(data[311:])
+	info.Rtransid = le.Uint64(data[319:])
+	info.Ctime = timespec(data[327:])
+	info.Otime = timespec(data[339:])
+	info.Stime = timespec(data[351:])
+	info.Rtime = timespec(data[363:])
+	return info
+}
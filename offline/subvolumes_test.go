@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+)
+
+// testSubvolumeItem encodes a struct btrfs_root_item of a subvolume rooted at bytenr.
+func testSubvolumeItem(bytenr uint64, info *btrfsutil.SubvolumeInfo) []byte {
+	data := testRootItem(bytenr, 0, info.Generation)
+	le := binary.LittleEndian
+	timespec := func(data []byte, t time.Time) {
+		le.PutUint64(data, uint64(t.Unix()))
+		le.PutUint32(data[8:], uint32(t.Nanosecond()))
+	}
+	le.PutUint64(data[208:], info.Flags)
+	copy(data[247:], info.UUID[:])
+	copy(data[263:], info.ParentUUID[:])
+	copy(data[279:], info.ReceivedUUID[:])
+	le.PutUint64(data[295:], info.Ctransid)
+	le.PutUint64(data[303:], info.Otransid)
+	le.PutUint64(data[311:], info.Stransid)
+	le.PutUint64(data[319:], info.Rtransid)
+	timespec(data[327:], info.Ctime)
+	timespec(data[339:], info.Otime)
+	timespec(data[351:], info.Stime)
+	timespec(data[363:], info.Rtime)
+	return data
+}
+
+// testRef encodes a struct btrfs_root_ref or btrfs_inode_ref with a name length at offset.
+func testRef(value uint64, offset int, name string) []byte {
+	data := make([]byte, offset+2+len(name))
+	binary.LittleEndian.PutUint64(data, value)
+	binary.LittleEndian.PutUint16(data[offset:], uint16(len(name)))
+	copy(data[offset+2:], name)
+	return data
+}
+
+func TestSubvolumes(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+	want := []*btrfsutil.SubvolumeInfoIteratorResult{
+		{Path: "@", Info: &btrfsutil.SubvolumeInfo{
+			Id: 256, ParentId: FsTreeObjectid, DirId: FirstFreeObjectid, UUID: btrfsutil.UUID{1},
+			Generation: testGeneration, Ctransid: 8, Otransid: 6, Ctime: now, Otime: now, Stime: time.Unix(0, 0), Rtime: time.Unix(0, 0),
+		}},
+		{Path: "@/.snapshots/1/snapshot", Info: &btrfsutil.SubvolumeInfo{
+			Id: 258, ParentId: 256, DirId: 258, Flags: 1, UUID: btrfsutil.UUID{3}, ParentUUID: btrfsutil.UUID{1},
+			Generation: testGeneration, Ctransid: 7, Otransid: 7, Ctime: now, Otime: now.Add(time.Hour), Stime: time.Unix(0, 0), Rtime: time.Unix(0, 0),
+		}},
+		{Path: "@home", Info: &btrfsutil.SubvolumeInfo{
+			Id: 257, ParentId: FsTreeObjectid, DirId: FirstFreeObjectid, UUID: btrfsutil.UUID{2}, ReceivedUUID: btrfsutil.UUID{9},
+			Generation: testGeneration, Ctransid: 6, Otransid: 6, Stransid: 4, Rtransid: 5, Ctime: now, Otime: now, Stime: now, Rtime: now,
+		}},
+	}
+	info := func(i int) *btrfsutil.SubvolumeInfo { return want[i].Info }
+
+	rootLeaves := [][]Item{
+		{
+			{Key{FsTreeObjectid, RootItemKey, 0}, testRootItem(testTreeLogical(1), 0, testGeneration)},
+			{Key{FsTreeObjectid, RootRefKey, 256}, testRef(FirstFreeObjectid, 16, "@")},
+			{Key{FsTreeObjectid, RootRefKey, 257}, testRef(FirstFreeObjectid, 16, "@home")},
+			{Key{256, RootItemKey, 0}, testSubvolumeItem(testTreeLogical(0), info(0))},
+			{Key{256, RootBackrefKey, FsTreeObjectid}, testRef(FirstFreeObjectid, 16, "@")},
+			{Key{256, RootRefKey, 258}, testRef(258, 16, "snapshot")},
+		},
+		{
+			{Key{257, RootItemKey, 0}, testSubvolumeItem(testTreeLogical(1), info(2))},
+			{Key{257, RootBackrefKey, FsTreeObjectid}, testRef(FirstFreeObjectid, 16, "@home")},
+			{Key{258, RootItemKey, 7}, testSubvolumeItem(testTreeLogical(0), info(1))},
+			{Key{258, RootBackrefKey, 256}, testRef(258, 16, "snapshot")},
+			// A deleted subvolume, which has no references left.
+			{Key{259, RootItemKey, 0}, testSubvolumeItem(testTreeLogical(1), &btrfsutil.SubvolumeInfo{})},
+		},
+	}
+	trees := [][]Item{
+		// The tree of @, with the directories .snapshots/1.
+		{
+			{Key{257, InodeRefKey, 256}, testRef(2, 8, ".snapshots")},
+			{Key{258, InodeRefKey, 257}, testRef(2, 8, "1")},
+		},
+		// An empty tree, shared by the top-level subvolume and @home.
+		{},
+	}
+
+	fs, err := New(bytes.NewReader(buildImage(t, rootLeaves, trees)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	got, err := fs.Subvolumes()
+	if err != nil {
+		t.Fatalf("Subvolumes() error = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Subvolumes() = %d subvolumes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("Subvolumes()[%d] = %v %+v, want %v %+v", i, got[i].Path, got[i].Info, want[i].Path, want[i].Info)
+		}
+	}
+
+	// A reference from the snapshot back to @ loops.
+	loop := append([]Item(nil), rootLeaves[1]...)
+	loop = append(loop[:4], append([]Item{{Key{258, RootRefKey, 256}, testRef(FirstFreeObjectid, 16, "loop")}}, loop[4:]...)...)
+	fs, err = New(bytes.NewReader(buildImage(t, [][]Item{rootLeaves[0], loop}, trees)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Subvolumes(); !errors.Is(err, ErrInvalidItem) || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("Subvolumes() error = %v, want %v", err, ErrInvalidItem)
+	}
+
+	// The directory of the snapshot cannot be resolved without the tree of @.
+	trees[0] = trees[0][:1]
+	fs, err = New(bytes.NewReader(buildImage(t, rootLeaves, trees)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Subvolumes(); !errors.Is(err, ErrInvalidItem) {
+		t.Errorf("Subvolumes() error = %v, want %v", err, ErrInvalidItem)
+	}
+}
+
+func TestOfflineSubvolumes(t *testing.T) {
+	image := btrfstest.Image(t, 1024*1024*1024)
+	mountpoint := btrfstest.MountImage(t, image)
+
+	for _, path := range []string{"@", "@home", "@/.snapshots"} {
+		if err := btrfsutil.CreateSubvolume(filepath.Join(mountpoint, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(mountpoint, "@/.snapshots/1"), 0755)
+	if err := btrfsutil.CreateSnapshot(filepath.Join(mountpoint, "@"), filepath.Join(mountpoint, "@/.snapshots/1/snapshot"), false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []*btrfsutil.SubvolumeInfoIteratorResult
+	it, err := btrfsutil.CreateSubvolumeInfoIterator(mountpoint, FsTreeObjectid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, result)
+	}
+	it.Destroy()
+	// Unmount before reading the image, so that it is complete.
+	exec.Command("umount", mountpoint).Run()
+
+	got, err := OfflineSubvolumes(image)
+	if err != nil {
+		t.Fatalf("OfflineSubvolumes() error = %v", err)
+	}
+	if len(got) != 4 || !reflect.DeepEqual(got, want) {
+		for i := range got {
+			t.Logf("got %v %+v", got[i].Path, got[i].Info)
+		}
+		for i := range want {
+			t.Logf("want %v %+v", want[i].Path, want[i].Info)
+		}
+		t.Errorf("OfflineSubvolumes() differs from SubvolumeInfoIterator")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Tree is a B-tree of a filesystem given by its root node.
+type Tree struct {
+	fs         *Filesystem
+	bytenr     uint64
+	level      uint8
+	generation uint64
+}
+
+// RootTree returns the tree of tree roots.
+func (fs *Filesystem) RootTree() *Tree {
+	super := fs.Superblock
+	return &Tree{fs, super.Root, super.RootLevel, super.Generation}
+}
+
+// ChunkTree returns the tree of chunks, which maps logical addresses to devices.
+func (fs *Filesystem) ChunkTree() *Tree {
+	super := fs.Superblock
+	return &Tree{fs, super.ChunkRoot, super.ChunkRootLevel, super.ChunkRootGeneration}
+}
+
+// Tree returns the tree with the given ID as recorded in the root tree, e.g. FsTreeObjectid
+// or the ID of a subvolume. It returns ErrTreeNotFound if there is no such tree.
+func (fs *Filesystem) Tree(id uint64) (*Tree, error) {
+	if id == RootTreeObjectid {
+		return fs.RootTree(), nil
+	}
+	if id == ChunkTreeObjectid {
+		return fs.ChunkTree(), nil
+	}
+
+	root, err := fs.rootItem(id)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	return &Tree{fs, le.Uint64(root[176:]), root[238], le.Uint64(root[160:])}, nil
+}
+
+// rootItem returns the struct btrfs_root_item of the tree with the given ID.
+func (fs *Filesystem) rootItem(id uint64) ([]byte, error) {
+	// Relocation may leave several root items, the current one has the highest offset.
+	var root []byte
+	err := fs.RootTree().Search(Key{id, RootItemKey, 0}, Key{id, RootItemKey, ^uint64(0)}, func(item *Item) error {
+		root = item.Data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The item must extend at least to the level of the root node.
+	if len(root) < 239 {
+		return nil, fmt.Errorf("%w: %d", ErrTreeNotFound, id)
+	}
+	return root, nil
+}
+
+// Search calls fn for every item of the tree with a key between min and max, inclusive, in key order.
+// Iteration stops at the first error returned by fn.
+func (t *Tree) Search(min, max Key, fn func(item *Item) error) error {
+	_, err := t.search(t.bytenr, t.level, t.generation, min, max, fn)
+	return err
+}
+
+// search visits the subtree rooted at the node at bytenr and returns whether its last key exceeded max.
+func (t *Tree) search(bytenr uint64, level uint8, generation uint64, min, max Key, fn func(item *Item) error) (bool, error) {
+	node, err := t.fs.ReadNode(bytenr)
+	if err != nil {
+		return false, err
+	}
+	if node.Level != level {
+		return false, fmt.Errorf("%w at %d: level %d, want %d", ErrInvalidNode, bytenr, node.Level, level)
+	}
+	if node.Generation != generation {
+		return false, fmt.Errorf("%w at %d: generation %d, want %d", ErrTransidMismatch, bytenr, node.Generation, generation)
+	}
+
+	if node.Level == 0 {
+		for i := range node.Items {
+			item := &node.Items[i]
+			if item.Key.Compare(min) < 0 {
+				continue
+			}
+			if item.Key.Compare(max) > 0 {
+				return true, nil
+			}
+			if err := fn(item); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	for i, ptr := range node.Ptrs {
+		if ptr.Key.Compare(max) > 0 {
+			return true, nil
+		}
+		// The child only holds keys before the key of the next pointer.
+		if i+1 < len(node.Ptrs) && node.Ptrs[i+1].Key.Compare(min) <= 0 {
+			continue
+		}
+		done, err := t.search(ptr.Blockptr, level-1, ptr.Generation, min, max, fn)
+		if done || err != nil {
+			return done, err
+		}
+	}
+	return false, nil
+}
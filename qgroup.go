@@ -41,13 +41,15 @@ func CreateQgroupInherit() (*QgroupInherit, error) {
 }
 
 // Destroy destroyes the qgroup inheritance specifier.
-func (q QgroupInherit) Destroy() {
+// It has a pointer receiver, so that the destroyed specifier is cleared in q.
+func (q *QgroupInherit) Destroy() {
 	C.btrfs_util_destroy_qgroup_inherit(q.inherit)
 	q.inherit = nil
 }
 
 // AddGroup adds an inheritance from a qgroup with the given ID to a qgroup inheritance specifier.
-func (q QgroupInherit) AddGroup(groupid uint64) error {
+// It has a pointer receiver, as libbtrfsutil may reallocate the specifier, which a copy of q would lose.
+func (q *QgroupInherit) AddGroup(groupid uint64) error {
 	err := getError(C.btrfs_util_qgroup_inherit_add_group(&q.inherit, C.uint64_t(groupid)))
 	return err
 }
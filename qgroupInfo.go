@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// QgroupInfo is the accounting of a qgroup.
+type QgroupInfo struct {
+	// Id is the qgroup ID, composed of the level in the upper 16 bits
+	// and, for level 0, the subvolume ID in the lower 48 bits.
+	Id                   uint64
+	Generation           uint64
+	Referenced           uint64
+	ReferencedCompressed uint64
+	Exclusive            uint64
+	ExclusiveCompressed  uint64
+	// Limit is the limit set on the qgroup, if any.
+	Limit QgroupLimit
+}
+
+// Level returns the level of the qgroup.
+func (q QgroupInfo) Level() uint16 {
+	return uint16(q.Id >> 48)
+}
+
+// SubvolumeId returns the lower 48 bits of the qgroup ID,
+// which are the ID of the subvolume for qgroups of level 0.
+func (q QgroupInfo) SubvolumeId() uint64 {
+	return q.Id & (1<<48 - 1)
+}
+
+// String returns the qgroup ID in the form "level/id".
+func (q QgroupInfo) String() string {
+	return fmt.Sprintf("%d/%d", q.Level(), q.SubvolumeId())
+}
+
+// GetQgroups returns the accounting of all qgroups of the filesystem containing path.
+// It returns ErrQuotaNotEnabled if quotas are not enabled.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func GetQgroups(path string) ([]QgroupInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetQgroupsFd(file.Fd())
+}
+
+// See GetQgroups.
+func GetQgroupsFd(fd uintptr) ([]QgroupInfo, error) {
+	var qgroups []QgroupInfo
+	index := make(map[uint64]int)
+	key := searchKey{
+		treeId:    C.BTRFS_QUOTA_TREE_OBJECTID,
+		minType:   C.BTRFS_QGROUP_INFO_KEY,
+		maxType:   C.BTRFS_QGROUP_LIMIT_KEY,
+		maxOffset: ^uint64(0),
+	}
+	err := treeSearch(fd, key, func(item *searchItem) error {
+		switch item.typ {
+		case C.BTRFS_QGROUP_INFO_KEY:
+			// struct btrfs_qgroup_info_item
+			if len(item.data) < 40 {
+				return ErrSearchFailed
+			}
+			index[item.offset] = len(qgroups)
+			qgroups = append(qgroups, QgroupInfo{
+				Id:                   item.offset,
+				Generation:           binary.LittleEndian.Uint64(item.data[0:]),
+				Referenced:           binary.LittleEndian.Uint64(item.data[8:]),
+				ReferencedCompressed: binary.LittleEndian.Uint64(item.data[16:]),
+				Exclusive:            binary.LittleEndian.Uint64(item.data[24:]),
+				ExclusiveCompressed:  binary.LittleEndian.Uint64(item.data[32:]),
+			})
+		case C.BTRFS_QGROUP_LIMIT_KEY:
+			// struct btrfs_qgroup_limit_item, which sorts after all info items.
+			if len(item.data) < 24 {
+				return ErrSearchFailed
+			}
+			i, ok := index[item.offset]
+			if !ok {
+				return nil
+			}
+			qgroups[i].Limit = parseQgroupLimit(item.data)
+		}
+		return nil
+	})
+	// The quota tree only exists while quotas are enabled.
+	if errors.Is(err, syscall.ENOENT) {
+		return nil, ErrQuotaNotEnabled
+	}
+	return qgroups, err
+}
+
+// parseQgroupLimit decodes the flags, max_rfer and max_excl fields of a struct btrfs_qgroup_limit_item.
+func parseQgroupLimit(data []byte) QgroupLimit {
+	var limit QgroupLimit
+	flags := binary.LittleEndian.Uint64(data)
+	if flags&C.BTRFS_QGROUP_LIMIT_MAX_RFER != 0 {
+		limit.MaxReferenced = binary.LittleEndian.Uint64(data[8:])
+	}
+	if flags&C.BTRFS_QGROUP_LIMIT_MAX_EXCL != 0 {
+		limit.MaxExclusive = binary.LittleEndian.Uint64(data[16:])
+	}
+	// A limit of all ones is no limit, see SetQgroupLimit.
+	if limit.MaxReferenced == ^uint64(0) {
+		limit.MaxReferenced = 0
+	}
+	if limit.MaxExclusive == ^uint64(0) {
+		limit.MaxExclusive = 0
+	}
+	return limit
+}
+
+// QgroupLimit limits the space a qgroup may reference. Zero means no limit.
+type QgroupLimit struct {
+	MaxReferenced uint64
+	MaxExclusive  uint64
+}
+
+// SetQgroupLimit sets the limits of the qgroup with the given ID in the filesystem containing path.
+// A qgroupid of zero refers to the qgroup of the subvolume containing path.
+// Quotas must be enabled. Appropriate privileges are required (CAP_SYS_ADMIN).
+func SetQgroupLimit(path string, qgroupid uint64, limit QgroupLimit) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer file.Close()
+
+	return SetQgroupLimitFd(file.Fd(), qgroupid, limit)
+}
+
+// See SetQgroupLimit.
+func SetQgroupLimitFd(fd uintptr, qgroupid uint64, limit QgroupLimit) error {
+	// The kernel removes a limit which is set to all ones.
+	value := func(limit uint64) C.__u64 {
+		if limit == 0 {
+			return C.__u64(^uint64(0))
+		}
+		return C.__u64(limit)
+	}
+
+	args := new(C.struct_btrfs_ioctl_qgroup_limit_args)
+	args.qgroupid = C.__u64(qgroupid)
+	args.lim.flags = C.BTRFS_QGROUP_LIMIT_MAX_RFER | C.BTRFS_QGROUP_LIMIT_MAX_EXCL
+	args.lim.max_rfer = value(limit.MaxReferenced)
+	args.lim.max_excl = value(limit.MaxExclusive)
+
+	if err := ioctl(fd, C.BTRFS_IOC_QGROUP_LIMIT, unsafe.Pointer(args)); err != nil {
+		if err == syscall.ENOTCONN {
+			return ErrQuotaNotEnabled
+		}
+		return fmt.Errorf("%w: %v", ErrQgroupLimitFailed, err)
+	}
+	return nil
+}
+
+// SetQuotaEnabled enables or disables quotas on the filesystem containing path.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func SetQuotaEnabled(path string, enabled bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer file.Close()
+
+	return SetQuotaEnabledFd(file.Fd(), enabled)
+}
+
+// See SetQuotaEnabled.
+func SetQuotaEnabledFd(fd uintptr, enabled bool) error {
+	args := new(C.struct_btrfs_ioctl_quota_ctl_args)
+	args.cmd = C.BTRFS_QUOTA_CTL_DISABLE
+	if enabled {
+		args.cmd = C.BTRFS_QUOTA_CTL_ENABLE
+	}
+
+	if err := ioctl(fd, C.BTRFS_IOC_QUOTA_CTL, unsafe.Pointer(args)); err != nil {
+		return fmt.Errorf("%w: %v", ErrQuotaCtlFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestQgroupInfo(t *testing.T) {
+	tests := []struct {
+		id         uint64
+		wantLevel  uint16
+		wantSubvol uint64
+		wantString string
+	}{
+		{256, 0, 256, "0/256"},
+		{1<<48 | 100, 1, 100, "1/100"},
+		{5, 0, 5, "0/5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantString, func(t *testing.T) {
+			qgroup := QgroupInfo{Id: tt.id}
+			if got := qgroup.Level(); got != tt.wantLevel {
+				t.Errorf("Level() = %v, want %v", got, tt.wantLevel)
+			}
+			if got := qgroup.SubvolumeId(); got != tt.wantSubvol {
+				t.Errorf("SubvolumeId() = %v, want %v", got, tt.wantSubvol)
+			}
+			if got := qgroup.String(); got != tt.wantString {
+				t.Errorf("String() = %v, want %v", got, tt.wantString)
+			}
+		})
+	}
+}
+
+func TestParseQgroupLimit(t *testing.T) {
+	item := func(flags, rfer, excl uint64) []byte {
+		data := make([]byte, 40)
+		binary.LittleEndian.PutUint64(data, flags)
+		binary.LittleEndian.PutUint64(data[8:], rfer)
+		binary.LittleEndian.PutUint64(data[16:], excl)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want QgroupLimit
+	}{
+		{"none", item(0, 1<<30, 1<<20), QgroupLimit{}},
+		{"referenced", item(1, 1<<30, 1<<20), QgroupLimit{MaxReferenced: 1 << 30}},
+		{"both", item(3, 1<<30, 1<<20), QgroupLimit{MaxReferenced: 1 << 30, MaxExclusive: 1 << 20}},
+		{"removed", item(3, ^uint64(0), ^uint64(0)), QgroupLimit{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseQgroupLimit(tt.data); got != tt.want {
+				t.Errorf("parseQgroupLimit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
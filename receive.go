@@ -0,0 +1,748 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #define _GNU_SOURCE
+// #include <stdlib.h>
+// #include <fcntl.h>
+// #include <sys/stat.h>
+// #include <sys/xattr.h>
+// #include <linux/fs.h>
+// #include <linux/btrfs.h>
+import "C"
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Receive applies the send stream read from r below dstDir, like btrfs receive.
+// Every subvolume of the stream is created in dstDir, marked as received and set read-only.
+// It returns the paths of the received subvolumes.
+// A partially received subvolume is deleted if an error occurs.
+//
+// Paths of the stream are resolved beneath the subvolume being received without following
+// symlinks, so a stream cannot modify files outside of it.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func Receive(ctx context.Context, r io.Reader, dstDir string) ([]string, error) {
+	dstDir, err := filepath.Abs(dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &receiver{dstDir: dstDir}
+	stream := newSendStreamReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			rc.abort()
+			return rc.received, err
+		}
+
+		cmd, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = rc.apply(cmd)
+		}
+		if err != nil {
+			rc.abort()
+			return rc.received, err
+		}
+	}
+
+	if rc.subvol != "" {
+		// The stream ended without an end command.
+		rc.abort()
+		return rc.received, ErrInvalidSendStream
+	}
+	return rc.received, nil
+}
+
+// receiver holds the state of Receive between commands.
+type receiver struct {
+	dstDir string
+
+	// subvol is the path of the subvolume being received and root is its opened directory.
+	subvol   string
+	root     *os.File
+	uuid     UUID
+	ctransid uint64
+
+	// file caches the file of consecutive write commands.
+	file     *os.File
+	filePath string
+
+	received []string
+}
+
+// resolve resolves a path of the stream beneath the subvolume being received.
+// It returns the opened parent directory, which the caller must close, and the last component.
+func (rc *receiver) resolve(cmd *sendCommand, attr uint16) (int, string, error) {
+	path, err := cmd.string(attr)
+	if err != nil {
+		return -1, "", err
+	}
+	if rc.root == nil {
+		return -1, "", ErrInvalidSendStream
+	}
+	return openBeneath(rc.root, path)
+}
+
+// openBeneath opens the parent directory of path beneath root and returns it with the last component of path.
+// The empty path refers to root itself. Paths which are absolute, contain ".." or pass through
+// a symlink are rejected.
+func openBeneath(root *os.File, path string) (int, string, error) {
+	if path == "" {
+		path = "."
+	}
+	if filepath.IsAbs(path) {
+		return -1, "", ErrInvalidSendStream
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if elem == ".." {
+			return -1, "", ErrInvalidSendStream
+		}
+	}
+	dir, name := filepath.Split(path)
+	if name == "" {
+		return -1, "", ErrInvalidSendStream
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	how := &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS | unix.RESOLVE_NO_SYMLINKS,
+	}
+	fd, err := unix.Openat2(int(root.Fd()), dir, how)
+	if err == unix.ENOSYS {
+		// openat2 was added in Linux 5.6.
+		fd, err = walkBeneath(int(root.Fd()), dir)
+	}
+	if err != nil {
+		return -1, "", fmt.Errorf("%w: %s: %v", ErrReceiveFailed, path, err)
+	}
+	return fd, name, nil
+}
+
+// walkBeneath opens the directory dir below root one component at a time without following symlinks.
+func walkBeneath(root int, dir string) (int, error) {
+	fd, err := unix.Openat(root, ".", unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		if elem == ".." {
+			unix.Close(fd)
+			return -1, unix.EXDEV
+		}
+		// With O_NOFOLLOW a symlink is opened itself, which O_DIRECTORY rejects.
+		next, err := unix.Openat(fd, elem, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return -1, err
+		}
+		fd = next
+	}
+	return fd, nil
+}
+
+// openRegular opens the regular file name in dir. Symlinks, devices and fifos are rejected.
+func openRegular(dir int, name string, flag int) (*os.File, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstatat(dir, name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, err
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+		return nil, fmt.Errorf("%s: not a regular file", name)
+	}
+
+	// O_NONBLOCK keeps a fifo which replaced the file in the meantime from blocking the open.
+	fd, err := unix.Openat(dir, name, flag|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Fstat(fd, &stat); err != nil || stat.Mode&unix.S_IFMT != unix.S_IFREG {
+		unix.Close(fd)
+		return nil, fmt.Errorf("%s: not a regular file", name)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// openFile opens the file of a write command, reusing the file of the previous one.
+func (rc *receiver) openFile(cmd *sendCommand) (*os.File, error) {
+	path, err := cmd.string(sendAttrPath)
+	if err != nil {
+		return nil, err
+	}
+	if rc.file != nil && rc.filePath == path {
+		return rc.file, nil
+	}
+	rc.closeFile()
+
+	dir, name, err := rc.resolve(cmd, sendAttrPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dir)
+
+	file, err := openRegular(dir, name, unix.O_WRONLY)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	rc.file, rc.filePath = file, path
+	return file, nil
+}
+
+func (rc *receiver) closeFile() {
+	if rc.file != nil {
+		rc.file.Close()
+		rc.file, rc.filePath = nil, ""
+	}
+}
+
+// abort deletes the subvolume being received.
+func (rc *receiver) abort() {
+	rc.closeFile()
+	if rc.root != nil {
+		rc.root.Close()
+		rc.root = nil
+	}
+	if rc.subvol != "" {
+		DeleteSubvolume(rc.subvol, false)
+		rc.subvol = ""
+	}
+}
+
+func (rc *receiver) apply(cmd *sendCommand) error {
+	switch cmd.cmd {
+	case sendCmdSubvol, sendCmdSnapshot:
+		return rc.beginSubvolume(cmd)
+	case sendCmdEnd:
+		return rc.finishSubvolume()
+	case sendCmdWrite:
+		return rc.write(cmd)
+	case sendCmdClone:
+		return rc.clone(cmd)
+	case sendCmdEncodedWrite:
+		return rc.encodedWrite(cmd)
+	case sendCmdFallocate:
+		return rc.fallocate(cmd)
+	case sendCmdTruncate:
+		return rc.truncate(cmd)
+	}
+
+	// Any other command may rename or replace the cached file.
+	rc.closeFile()
+	dir, name, err := rc.resolve(cmd, sendAttrPath)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dir)
+
+	switch cmd.cmd {
+	case sendCmdMkfile:
+		fd, err := unix.Openat(dir, name, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+		return unix.Close(fd)
+	case sendCmdMkdir:
+		err = unix.Mkdirat(dir, name, 0700)
+	case sendCmdMknod:
+		mode, err := cmd.uint64(sendAttrMode)
+		if err != nil {
+			return err
+		}
+		rdev, err := cmd.uint64(sendAttrRdev)
+		if err != nil {
+			return err
+		}
+		err = unix.Mknodat(dir, name, uint32(mode&syscall.S_IFMT), int(rdev))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+	case sendCmdMkfifo:
+		err = unix.Mknodat(dir, name, unix.S_IFIFO|0600, 0)
+	case sendCmdMksock:
+		err = unix.Mknodat(dir, name, unix.S_IFSOCK|0600, 0)
+	case sendCmdSymlink:
+		target, err := cmd.string(sendAttrPathLink)
+		if err != nil {
+			return err
+		}
+		if err := unix.Symlinkat(target, dir, name); err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+	case sendCmdRename:
+		toDir, toName, err := rc.resolve(cmd, sendAttrPathTo)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(toDir)
+		if err := unix.Renameat(dir, name, toDir, toName); err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+	case sendCmdLink:
+		targetDir, targetName, err := rc.resolve(cmd, sendAttrPathLink)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(targetDir)
+		// Without AT_SYMLINK_FOLLOW a symlink is linked itself.
+		if err := unix.Linkat(targetDir, targetName, dir, name, 0); err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+	case sendCmdUnlink:
+		err = unix.Unlinkat(dir, name, 0)
+	case sendCmdRmdir:
+		err = unix.Unlinkat(dir, name, unix.AT_REMOVEDIR)
+	case sendCmdSetXattr:
+		return rc.setXattr(cmd, dir, name)
+	case sendCmdRemoveXattr:
+		return rc.removeXattr(cmd, dir, name)
+	case sendCmdChmod:
+		mode, err := cmd.uint64(sendAttrMode)
+		if err != nil {
+			return err
+		}
+		if err := chmodat(dir, name, uint32(mode&07777)); err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+	case sendCmdChown:
+		uid, err := cmd.uint64(sendAttrUid)
+		if err != nil {
+			return err
+		}
+		gid, err := cmd.uint64(sendAttrGid)
+		if err != nil {
+			return err
+		}
+		if err := unix.Fchownat(dir, name, int(uid), int(gid), unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+		}
+	case sendCmdUtimes:
+		return rc.utimes(cmd, dir, name)
+	case sendCmdUpdateExtent, sendCmdFileattr:
+		// Streams without file data only describe changed ranges and
+		// file attributes are not applied by btrfs receive either.
+	default:
+		// This includes enable_verity, which cannot be applied without the signature support of btrfs-progs.
+		return fmt.Errorf("%w: unsupported command %d", ErrReceiveFailed, cmd.cmd)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+// chmodat changes the mode of name in dir without following a symlink.
+// The mode of a symlink cannot be changed on Linux and is left as is.
+func chmodat(dir int, name string, mode uint32) error {
+	Cname := C.CString(name)
+	defer C.free(unsafe.Pointer(Cname))
+
+	ret, err := C.fchmodat(C.int(dir), Cname, C.mode_t(mode), C.AT_SYMLINK_NOFOLLOW)
+	if ret == 0 {
+		return nil
+	}
+	if err != syscall.EOPNOTSUPP {
+		return err
+	}
+
+	// The C library does not support AT_SYMLINK_NOFOLLOW or name is a symlink.
+	var stat unix.Stat_t
+	if err := unix.Fstatat(dir, name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return err
+	}
+	if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return nil
+	}
+	return unix.Fchmodat(dir, name, mode, 0)
+}
+
+func (rc *receiver) beginSubvolume(cmd *sendCommand) error {
+	if rc.subvol != "" {
+		return ErrInvalidSendStream
+	}
+
+	name, err := cmd.string(sendAttrPath)
+	if err != nil {
+		return err
+	}
+	uuid, err := cmd.uuid(sendAttrUUID)
+	if err != nil {
+		return err
+	}
+	ctransid, err := cmd.uint64(sendAttrCtransid)
+	if err != nil {
+		return err
+	}
+	// The subvolume is created directly in dstDir.
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return ErrInvalidSendStream
+	}
+	path := filepath.Join(rc.dstDir, name)
+
+	if cmd.cmd == sendCmdSubvol {
+		err = CreateSubvolume(path)
+	} else {
+		var parentUUID UUID
+		var parentCtransid uint64
+		if parentUUID, err = cmd.uuid(sendAttrCloneUUID); err != nil {
+			return err
+		}
+		if parentCtransid, err = cmd.uint64(sendAttrCloneCtransid); err != nil {
+			return err
+		}
+		parent, err := rc.findSubvolume(parentUUID, parentCtransid)
+		if err != nil {
+			return err
+		}
+		err = CreateSnapshot(parent, path, false, false)
+	}
+	if err != nil {
+		return err
+	}
+	rc.subvol, rc.uuid, rc.ctransid = path, uuid, ctransid
+
+	root, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	rc.root = root
+	return nil
+}
+
+// finishSubvolume marks the subvolume being received as received and sets it read-only.
+func (rc *receiver) finishSubvolume() error {
+	rc.closeFile()
+	if rc.subvol == "" {
+		return ErrInvalidSendStream
+	}
+
+	args := new(C.struct_btrfs_ioctl_received_subvol_args)
+	for i, b := range rc.uuid {
+		args.uuid[i] = C.char(b)
+	}
+	args.stransid = C.__u64(rc.ctransid)
+	if err := ioctl(rc.root.Fd(), C.BTRFS_IOC_SET_RECEIVED_SUBVOL, unsafe.Pointer(args)); err != nil {
+		return fmt.Errorf("%w: %v", ErrSetReceivedFailed, err)
+	}
+	if err := SetSubvolumeReadOnlyFd(rc.root.Fd(), true); err != nil {
+		return err
+	}
+
+	rc.root.Close()
+	rc.received = append(rc.received, rc.subvol)
+	rc.subvol, rc.root = "", nil
+	return nil
+}
+
+// findSubvolume returns the path of a subvolume in the destination filesystem which corresponds to
+// the subvolume with the given UUID and ctransid on the sending side.
+// Like btrfs receive, received subvolumes are preferred over the original subvolume.
+func (rc *receiver) findSubvolume(uuid UUID, ctransid uint64) (string, error) {
+	if uuid == rc.uuid && rc.subvol != "" {
+		return rc.subvol, nil
+	}
+
+	results, err := FindSubvolumesByReceivedUUID(rc.dstDir, uuid)
+	if err != nil {
+		return "", err
+	}
+	for _, result := range results {
+		if result.Info.Stransid == ctransid {
+			return mountedPath(rc.dstDir, result.Path)
+		}
+	}
+
+	result, err := FindSubvolumeByUUID(rc.dstDir, uuid)
+	if err != nil {
+		return "", err
+	}
+	return mountedPath(rc.dstDir, result.Path)
+}
+
+func (rc *receiver) write(cmd *sendCommand) error {
+	offset, err := cmd.uint64(sendAttrFileOffset)
+	if err != nil {
+		return err
+	}
+	data, err := cmd.bytes(sendAttrData)
+	if err != nil {
+		return err
+	}
+
+	file, err := rc.openFile(cmd)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, int64(offset)); err != nil {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+func (rc *receiver) clone(cmd *sendCommand) error {
+	offset, err := cmd.uint64(sendAttrFileOffset)
+	if err != nil {
+		return err
+	}
+	length, err := cmd.uint64(sendAttrCloneLen)
+	if err != nil {
+		return err
+	}
+	cloneUUID, err := cmd.uuid(sendAttrCloneUUID)
+	if err != nil {
+		return err
+	}
+	cloneCtransid, err := cmd.uint64(sendAttrCloneCtransid)
+	if err != nil {
+		return err
+	}
+	clonePath, err := cmd.string(sendAttrClonePath)
+	if err != nil {
+		return err
+	}
+	cloneOffset, err := cmd.uint64(sendAttrCloneOffset)
+	if err != nil {
+		return err
+	}
+
+	subvol, err := rc.findSubvolume(cloneUUID, cloneCtransid)
+	if err != nil {
+		return err
+	}
+	sourceRoot, err := os.OpenFile(subvol, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer sourceRoot.Close()
+
+	// The clone source is resolved beneath its subvolume like the paths of the stream.
+	dir, name, err := openBeneath(sourceRoot, clonePath)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dir)
+	source, err := openRegular(dir, name, unix.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("%w: clone: %v", ErrReceiveFailed, err)
+	}
+	defer source.Close()
+
+	file, err := rc.openFile(cmd)
+	if err != nil {
+		return err
+	}
+
+	args := new(C.struct_file_clone_range)
+	args.src_fd = C.__s64(source.Fd())
+	args.src_offset = C.__u64(cloneOffset)
+	args.src_length = C.__u64(length)
+	args.dest_offset = C.__u64(offset)
+	if err := ioctl(file.Fd(), C.FICLONERANGE, unsafe.Pointer(args)); err != nil {
+		return fmt.Errorf("%w: clone: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+func (rc *receiver) encodedWrite(cmd *sendCommand) error {
+	offset, err := cmd.uint64(sendAttrFileOffset)
+	if err != nil {
+		return err
+	}
+	var meta EncodedMeta
+	if meta.Len, err = cmd.uint64(sendAttrUnencodedFileLen); err != nil {
+		return err
+	}
+	if meta.UnencodedLen, err = cmd.uint64(sendAttrUnencodedLen); err != nil {
+		return err
+	}
+	if meta.UnencodedOffset, err = cmd.uint64(sendAttrUnencodedOffset); err != nil {
+		return err
+	}
+	compression, err := cmd.uint64(sendAttrCompression)
+	if err != nil {
+		return err
+	}
+	meta.Compression = EncodedCompression(compression)
+	if _, ok := cmd.attrs[sendAttrEncryption]; ok {
+		encryption, err := cmd.uint64(sendAttrEncryption)
+		if err != nil {
+			return err
+		}
+		meta.Encryption = uint32(encryption)
+	}
+	data, err := cmd.bytes(sendAttrData)
+	if err != nil {
+		return err
+	}
+	if meta.UnencodedLen > encodedMaxLength || meta.UnencodedOffset > meta.UnencodedLen || meta.Len > meta.UnencodedLen-meta.UnencodedOffset {
+		return ErrInvalidSendStream
+	}
+
+	file, err := rc.openFile(cmd)
+	if err != nil {
+		return err
+	}
+	if err := EncodedWrite(file.Fd(), offset, data, meta); err == nil || meta.Encryption != 0 {
+		return err
+	}
+
+	// Fall back to writing the decompressed data, e.g. if the destination does not support encoded writes.
+	buf, err := decodeEncoded(data, meta)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	if _, err := file.WriteAt(buf[meta.UnencodedOffset:meta.UnencodedOffset+meta.Len], int64(offset)); err != nil {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+func (rc *receiver) fallocate(cmd *sendCommand) error {
+	mode, err := cmd.uint64(sendAttrFallocateMode)
+	if err != nil {
+		return err
+	}
+	offset, err := cmd.uint64(sendAttrFileOffset)
+	if err != nil {
+		return err
+	}
+	size, err := cmd.uint64(sendAttrSize)
+	if err != nil {
+		return err
+	}
+
+	file, err := rc.openFile(cmd)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Fallocate(int(file.Fd()), uint32(mode), int64(offset), int64(size)); err != nil {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+func (rc *receiver) truncate(cmd *sendCommand) error {
+	size, err := cmd.uint64(sendAttrSize)
+	if err != nil {
+		return err
+	}
+
+	file, err := rc.openFile(cmd)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+// procPath returns a path of name in dir through /proc, for the syscalls without an *at variant.
+// Only the last component is subject to the no-follow semantics of the syscall.
+func procPath(dir int, name string) string {
+	return fmt.Sprintf("/proc/self/fd/%d/%s", dir, name)
+}
+
+func (rc *receiver) setXattr(cmd *sendCommand, dir int, name string) error {
+	attr, err := cmd.string(sendAttrXattrName)
+	if err != nil {
+		return err
+	}
+	data, err := cmd.bytes(sendAttrXattrData)
+	if err != nil {
+		return err
+	}
+
+	Cpath := C.CString(procPath(dir, name))
+	defer C.free(unsafe.Pointer(Cpath))
+	Cname := C.CString(attr)
+	defer C.free(unsafe.Pointer(Cname))
+
+	var value unsafe.Pointer
+	if len(data) > 0 {
+		value = C.CBytes(data)
+		defer C.free(value)
+	}
+	if ret, err := C.lsetxattr(Cpath, Cname, value, C.size_t(len(data)), 0); ret != 0 {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+func (rc *receiver) removeXattr(cmd *sendCommand, dir int, name string) error {
+	attr, err := cmd.string(sendAttrXattrName)
+	if err != nil {
+		return err
+	}
+
+	Cpath := C.CString(procPath(dir, name))
+	defer C.free(unsafe.Pointer(Cpath))
+	Cname := C.CString(attr)
+	defer C.free(unsafe.Pointer(Cname))
+
+	if ret, err := C.lremovexattr(Cpath, Cname); ret != 0 {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
+
+func (rc *receiver) utimes(cmd *sendCommand, dir int, name string) error {
+	atime, err := cmd.time(sendAttrAtime)
+	if err != nil {
+		return err
+	}
+	mtime, err := cmd.time(sendAttrMtime)
+	if err != nil {
+		return err
+	}
+
+	Cname := C.CString(name)
+	defer C.free(unsafe.Pointer(Cname))
+
+	times := (*[2]C.struct_timespec)(C.malloc(C.size_t(unsafe.Sizeof([2]C.struct_timespec{}))))
+	defer C.free(unsafe.Pointer(times))
+	times[0].tv_sec = C.long(atime.Unix())
+	times[0].tv_nsec = C.long(atime.Nanosecond())
+	times[1].tv_sec = C.long(mtime.Unix())
+	times[1].tv_nsec = C.long(mtime.Nanosecond())
+
+	if ret, err := C.utimensat(C.int(dir), Cname, &times[0], C.AT_SYMLINK_NOFOLLOW); ret != 0 {
+		return fmt.Errorf("%w: %v", ErrReceiveFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenBeneath(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "dir"), 0755)
+	os.Symlink("/", filepath.Join(dir, "abs"))
+	os.Symlink("dir", filepath.Join(dir, "rel"))
+	root, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		wantName string
+		wantErr  bool
+	}{
+		{"root", "", ".", false},
+		{"file", "file", "file", false},
+		{"nested", "dir/file", "file", false},
+		{"orphan", "o257-7-0", "o257-7-0", false},
+		{"symlink itself", "abs", "abs", false},
+		{"absolute", "/etc/passwd", "", true},
+		{"parent", "../file", "", true},
+		{"nested parent", "dir/../../file", "", true},
+		{"absolute symlink", "abs/etc/passwd", "", true},
+		{"relative symlink", "rel/file", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fd, name, err := openBeneath(root, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("openBeneath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			unix.Close(fd)
+			if name != tt.wantName {
+				t.Errorf("openBeneath() name = %v, want %v", name, tt.wantName)
+			}
+
+			// Kernels without openat2 walk the path instead.
+			if fd, err = walkBeneath(int(root.Fd()), filepath.Dir(tt.path)); err != nil {
+				t.Errorf("walkBeneath() error = %v", err)
+				return
+			}
+			unix.Close(fd)
+		})
+	}
+	for _, path := range []string{"abs/etc", "rel", "dir/../abs"} {
+		if fd, err := walkBeneath(int(root.Fd()), path); err == nil {
+			unix.Close(fd)
+			t.Errorf("walkBeneath(%v) succeeded, want error", path)
+		}
+	}
+}
+
+// symlinkEscapeStream returns the commands of a stream which plants symlinks to outside
+// and then tries to modify files through them. Each attack must fail.
+func symlinkEscapeStream(outside string) (setup [][]byte, attacks [][]byte) {
+	attr := func(attr uint16, value string) testSendAttr {
+		return testSendAttr{attr, []byte(value)}
+	}
+	u64 := func(attr uint16, value uint64) testSendAttr {
+		return testSendAttr{attr, binary.LittleEndian.AppendUint64(nil, value)}
+	}
+	timespec := make([]byte, 12)
+
+	setup = [][]byte{
+		encodeSendCommand(1, sendCmdSymlink, attr(sendAttrPath, "dir"), attr(sendAttrPathLink, outside)),
+		encodeSendCommand(1, sendCmdSymlink, attr(sendAttrPath, "file"), attr(sendAttrPathLink, filepath.Join(outside, "file"))),
+		encodeSendCommand(1, sendCmdMkfifo, attr(sendAttrPath, "fifo")),
+		// These apply to the symlink itself.
+		encodeSendCommand(1, sendCmdChmod, attr(sendAttrPath, "file"), u64(sendAttrMode, 0777)),
+		encodeSendCommand(1, sendCmdUtimes, attr(sendAttrPath, "file"), testSendAttr{sendAttrAtime, timespec}, testSendAttr{sendAttrMtime, timespec}, testSendAttr{sendAttrCtime, timespec}),
+		encodeSendCommand(1, sendCmdChown, attr(sendAttrPath, "file"), u64(sendAttrUid, uint64(os.Getuid())), u64(sendAttrGid, uint64(os.Getgid()))),
+	}
+	attacks = [][]byte{
+		encodeSendCommand(1, sendCmdMkfile, attr(sendAttrPath, "dir/new")),
+		encodeSendCommand(1, sendCmdMkdir, attr(sendAttrPath, "dir/new")),
+		encodeSendCommand(1, sendCmdSymlink, attr(sendAttrPath, "dir/new"), attr(sendAttrPathLink, "x")),
+		encodeSendCommand(1, sendCmdWrite, attr(sendAttrPath, "dir/file"), u64(sendAttrFileOffset, 0), attr(sendAttrData, "pwned")),
+		encodeSendCommand(1, sendCmdWrite, attr(sendAttrPath, "file"), u64(sendAttrFileOffset, 0), attr(sendAttrData, "pwned")),
+		encodeSendCommand(1, sendCmdWrite, attr(sendAttrPath, "fifo"), u64(sendAttrFileOffset, 0), attr(sendAttrData, "pwned")),
+		encodeSendCommand(1, sendCmdTruncate, attr(sendAttrPath, "file"), u64(sendAttrSize, 0)),
+		encodeSendCommand(1, sendCmdTruncate, attr(sendAttrPath, "dir/file"), u64(sendAttrSize, 0)),
+		encodeSendCommand(1, sendCmdChmod, attr(sendAttrPath, "dir/file"), u64(sendAttrMode, 0777)),
+		encodeSendCommand(1, sendCmdUtimes, attr(sendAttrPath, "dir/file"), testSendAttr{sendAttrAtime, timespec}, testSendAttr{sendAttrMtime, timespec}, testSendAttr{sendAttrCtime, timespec}),
+		encodeSendCommand(1, sendCmdRename, attr(sendAttrPath, "dir/file"), attr(sendAttrPathTo, "stolen")),
+		encodeSendCommand(1, sendCmdRename, attr(sendAttrPath, "fifo"), attr(sendAttrPathTo, "dir/file")),
+		encodeSendCommand(1, sendCmdLink, attr(sendAttrPath, "dir/new"), attr(sendAttrPathLink, "fifo")),
+		encodeSendCommand(1, sendCmdUnlink, attr(sendAttrPath, "dir/file")),
+		encodeSendCommand(1, sendCmdSetXattr, attr(sendAttrPath, "dir/file"), attr(sendAttrXattrName, "user.x"), attr(sendAttrXattrData, "x")),
+	}
+	return setup, attacks
+}
+
+// checkOutside fails if the file in outside was modified.
+func checkOutside(t *testing.T, outside string, mtime time.Time) {
+	t.Helper()
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 {
+		t.Errorf("outside directory has %d entries, want 1", len(entries))
+	}
+	info, err := os.Stat(filepath.Join(outside, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "file")); string(data) != "host" {
+		t.Errorf("outside file content = %q, want host", data)
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("outside file mode = %v, mtime = %v", info.Mode(), info.ModTime())
+	}
+}
+
+func TestReceiverSymlinkEscape(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(outside, "file"), []byte("host"), 0600)
+	mtime := time.Unix(1600000000, 0)
+	os.Chtimes(filepath.Join(outside, "file"), mtime, mtime)
+
+	root, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &receiver{subvol: dir, root: root}
+	defer rc.closeFile()
+	defer root.Close()
+
+	setup, attacks := symlinkEscapeStream(outside)
+	apply := func(encoded []byte) error {
+		stream := newSendStreamReader(bytes.NewReader(append(encodeSendStreamHeader(1), encoded...)))
+		cmd, err := stream.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rc.apply(cmd)
+	}
+	for _, c := range setup {
+		if err := apply(c); err != nil {
+			t.Fatalf("receiver.apply() error = %v", err)
+		}
+	}
+	checkOutside(t, outside, mtime)
+	for i, c := range attacks {
+		if err := apply(c); err == nil {
+			t.Errorf("receiver.apply() of attack %d succeeded, want error", i)
+		}
+		checkOutside(t, outside, mtime)
+	}
+}
+
+func TestReceiverEncodedWriteBounds(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file"), nil, 0644)
+	root, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	rc := &receiver{subvol: dir, root: root}
+	defer rc.closeFile()
+
+	u64 := func(attr uint16, value uint64) testSendAttr {
+		return testSendAttr{attr, binary.LittleEndian.AppendUint64(nil, value)}
+	}
+	tests := []struct {
+		name                                string
+		fileLen, unencodedLen, unencodedOff uint64
+	}{
+		{"huge", 4096, 1 << 62, 0},
+		{"offset beyond data", 4096, 4096, 8192},
+		{"range beyond data", 4096, 8192, 8192 - 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeSendCommand(2, sendCmdEncodedWrite,
+				testSendAttr{sendAttrPath, []byte("file")},
+				u64(sendAttrFileOffset, 0),
+				u64(sendAttrUnencodedFileLen, tt.fileLen),
+				u64(sendAttrUnencodedLen, tt.unencodedLen),
+				u64(sendAttrUnencodedOffset, tt.unencodedOff),
+				u64(sendAttrCompression, uint64(EncodedCompressionZlib)),
+				testSendAttr{sendAttrData, []byte("data")})
+			cmd, err := newSendStreamReader(bytes.NewReader(append(encodeSendStreamHeader(2), encoded...))).next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rc.apply(cmd); err != ErrInvalidSendStream {
+				t.Errorf("receiver.apply() error = %v, want %v", err, ErrInvalidSendStream)
+			}
+		})
+	}
+}
+
+func TestReceive(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	subvol := filepath.Join(mountpoint.path, "subvol1")
+	if CreateSubvolume(subvol) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	os.WriteFile(filepath.Join(subvol, "file"), bytes.Repeat([]byte("data"), 10000), 0640)
+	os.Mkdir(filepath.Join(subvol, "dir"), 0750)
+	os.Symlink("../file", filepath.Join(subvol, "dir", "link"))
+
+	src := filepath.Join(mountpoint.path, "src")
+	dst := filepath.Join(mountpoint.path, "dst")
+	os.Mkdir(src, 0755)
+	os.Mkdir(dst, 0755)
+
+	snap1 := filepath.Join(src, "snap1")
+	if CreateSnapshot(subvol, snap1, false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+	os.WriteFile(filepath.Join(subvol, "file"), []byte("changed"), 0640)
+	snap2 := filepath.Join(src, "snap2")
+	if CreateSnapshot(subvol, snap2, false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+
+	tests := []struct {
+		name     string
+		snapshot string
+		parent   string
+		want     string
+	}{
+		{"full", snap1, "", string(bytes.Repeat([]byte("data"), 10000))},
+		{"incremental", snap2, snap1, "changed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			if err := Send(context.Background(), tt.snapshot, &stream, &SendOptions{Parent: tt.parent}); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			received, err := Receive(context.Background(), &stream, dst)
+			if err != nil {
+				t.Fatalf("Receive() error = %v", err)
+			}
+			want := filepath.Join(dst, filepath.Base(tt.snapshot))
+			if len(received) != 1 || received[0] != want {
+				t.Fatalf("Receive() = %v, want [%v]", received, want)
+			}
+
+			if data, _ := os.ReadFile(filepath.Join(want, "file")); string(data) != tt.want {
+				t.Errorf("Receive() file content mismatch")
+			}
+			if target, _ := os.Readlink(filepath.Join(want, "dir", "link")); target != "../file" {
+				t.Errorf("Receive() symlink = %v, want ../file", target)
+			}
+
+			srcInfo, err := GetSubvolumeInfo(tt.snapshot, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := GetSubvolumeInfo(want, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.ReceivedUUID != srcInfo.UUID {
+				t.Errorf("Receive() received UUID = %v, want %v", info.ReceivedUUID, srcInfo.UUID)
+			}
+			if ro, _ := GetSubvolumeReadOnly(want); !ro {
+				t.Errorf("Receive() subvolume is not read-only")
+			}
+		})
+	}
+}
+
+func TestReceiveSymlinkEscape(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	dst := filepath.Join(mountpoint.path, "dst")
+	outside := filepath.Join(mountpoint.path, "outside")
+	os.Mkdir(dst, 0755)
+	os.Mkdir(outside, 0755)
+	os.WriteFile(filepath.Join(outside, "file"), []byte("host"), 0600)
+	mtime := time.Unix(1600000000, 0)
+	os.Chtimes(filepath.Join(outside, "file"), mtime, mtime)
+
+	setup, attacks := symlinkEscapeStream(outside)
+	for i, attack := range attacks {
+		stream := encodeSendStreamHeader(1)
+		stream = append(stream, encodeSendCommand(1, sendCmdSubvol,
+			testSendAttr{sendAttrPath, []byte("evil")},
+			testSendAttr{sendAttrUUID, make([]byte, 16)},
+			testSendAttr{sendAttrCtransid, binary.LittleEndian.AppendUint64(nil, 1)})...)
+		for _, c := range setup {
+			stream = append(stream, c...)
+		}
+		stream = append(stream, attack...)
+		stream = append(stream, encodeSendCommand(1, sendCmdEnd)...)
+
+		received, err := Receive(context.Background(), bytes.NewReader(stream), dst)
+		if err == nil || len(received) != 0 {
+			t.Errorf("Receive() of attack %d = %v, %v, want error", i, received, err)
+		}
+		checkOutside(t, outside, mtime)
+		if _, err := os.Lstat(filepath.Join(dst, "evil")); !os.IsNotExist(err) {
+			t.Fatalf("Receive() of attack %d left the partial subvolume", i)
+		}
+	}
+}
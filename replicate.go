@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ReplicateOptions configures Replicate.
+type ReplicateOptions struct {
+	// LatestOnly transfers only the newest source snapshot instead of every
+	// snapshot newer than the newest snapshot common to source and destination.
+	LatestOnly bool
+	// Prune deletes received snapshots in the destination whose source snapshot no longer exists,
+	// so that the destination follows the retention of the source.
+	// dstDir must then only contain snapshots replicated from srcSnapshotDir.
+	Prune bool
+	// Compressed transfers compressed extents without decompressing them.
+	// Requires Linux 6.0 or newer on both sides.
+	Compressed bool
+}
+
+// ReplicatedSnapshot is a snapshot transferred by Replicate.
+type ReplicatedSnapshot struct {
+	Source      string
+	Destination string
+	// Parent is the source snapshot the transfer was incremental to. It is empty for a full transfer.
+	Parent string
+	// Bytes is the size of the transferred send stream.
+	Bytes uint64
+}
+
+// ReplicationResult is the result of Replicate.
+type ReplicationResult struct {
+	Transferred []*ReplicatedSnapshot
+	// Pruned are the paths of the destination snapshots deleted because of ReplicateOptions.Prune.
+	Pruned []string
+}
+
+// replicationStep is a transfer planned by Replicate.
+type replicationStep struct {
+	source *RetentionSnapshot
+	parent *RetentionSnapshot
+}
+
+// Replicate transfers the read-only snapshots in srcSnapshotDir to dstDir, which may be on another Btrfs filesystem.
+// Snapshots are matched by the received UUID of the destination, and each snapshot is sent incrementally
+// to the newest snapshot already present on both sides, or in full if there is none.
+// Snapshots older than the newest common snapshot are not transferred.
+// If an error occurs, the snapshots transferred so far are reported together with the error.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func Replicate(ctx context.Context, srcSnapshotDir string, dstDir string, opts *ReplicateOptions) (*ReplicationResult, error) {
+	if opts == nil {
+		opts = &ReplicateOptions{}
+	}
+
+	src, err := listSnapshots(srcSnapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := listSnapshots(dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, prune := planReplication(src, dst, opts.LatestOnly)
+
+	result := &ReplicationResult{}
+	for _, step := range steps {
+		replicated, err := replicateSnapshot(ctx, step, dstDir, opts)
+		if err != nil {
+			return result, err
+		}
+		result.Transferred = append(result.Transferred, replicated)
+	}
+
+	if opts.Prune {
+		for _, snapshot := range prune {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if err := DeleteSubvolume(snapshot.Path, false); err != nil {
+				return result, err
+			}
+			result.Pruned = append(result.Pruned, snapshot.Path)
+		}
+	}
+	return result, nil
+}
+
+// replicationKey returns the UUID a snapshot is known by in a received copy of it.
+// A snapshot which was received itself is sent with its received UUID.
+func replicationKey(info *SubvolumeInfo) UUID {
+	if !info.ReceivedUUID.IsZero() {
+		return info.ReceivedUUID
+	}
+	return info.UUID
+}
+
+// planReplication returns the transfers needed to bring dst up to date with src,
+// and the snapshots of dst which no longer have a source.
+func planReplication(src []*RetentionSnapshot, dst []*RetentionSnapshot, latestOnly bool) ([]replicationStep, []*RetentionSnapshot) {
+	readOnly := make([]*RetentionSnapshot, 0, len(src))
+	for _, snapshot := range src {
+		if snapshot.Info.Flags&C.BTRFS_ROOT_SUBVOL_RDONLY != 0 {
+			readOnly = append(readOnly, snapshot)
+		}
+	}
+	src = readOnly
+	sort.SliceStable(src, func(i, j int) bool {
+		return src[i].Info.Otime.Before(src[j].Info.Otime)
+	})
+
+	received := make(map[UUID]bool)
+	for _, snapshot := range dst {
+		if !snapshot.Info.ReceivedUUID.IsZero() {
+			received[snapshot.Info.ReceivedUUID] = true
+		}
+	}
+
+	common := -1
+	for i, snapshot := range src {
+		if received[replicationKey(snapshot.Info)] {
+			common = i
+		}
+	}
+
+	var steps []replicationStep
+	pending := src[common+1:]
+	if latestOnly && len(pending) > 1 {
+		pending = pending[len(pending)-1:]
+	}
+	var parent *RetentionSnapshot
+	if common >= 0 {
+		parent = src[common]
+	}
+	for _, snapshot := range pending {
+		steps = append(steps, replicationStep{source: snapshot, parent: parent})
+		parent = snapshot
+	}
+
+	// Without any snapshot in common, the destination cannot be related to the source.
+	var prune []*RetentionSnapshot
+	if parent == nil {
+		return steps, prune
+	}
+	sources := make(map[UUID]bool)
+	for _, snapshot := range src {
+		sources[replicationKey(snapshot.Info)] = true
+	}
+	for _, snapshot := range dst {
+		if !snapshot.Info.ReceivedUUID.IsZero() && !sources[snapshot.Info.ReceivedUUID] {
+			prune = append(prune, snapshot)
+		}
+	}
+	return steps, prune
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+func replicateSnapshot(ctx context.Context, step replicationStep, dstDir string, opts *ReplicateOptions) (*ReplicatedSnapshot, error) {
+	sendOpts := &SendOptions{Compressed: opts.Compressed}
+	if opts.Compressed {
+		sendOpts.Protocol = 2
+	}
+	replicated := &ReplicatedSnapshot{Source: step.source.Path}
+	if step.parent != nil {
+		sendOpts.Parent = step.parent.Path
+		replicated.Parent = step.parent.Path
+	}
+
+	pr, pw := io.Pipe()
+	sendErr := make(chan error, 1)
+	go func() {
+		err := Send(ctx, step.source.Path, pw, sendOpts)
+		pw.CloseWithError(err)
+		sendErr <- err
+	}()
+
+	stream := &countingReader{r: pr}
+	received, err := Receive(ctx, stream, dstDir)
+	// Unblock the sender if receiving stopped early.
+	pr.CloseWithError(io.ErrClosedPipe)
+	// A failed send truncates the stream, so its error is the cause of an invalid stream.
+	if serr := <-sendErr; serr != nil && (err == nil || errors.Is(err, ErrInvalidSendStream)) {
+		err = serr
+	}
+	if err == nil && len(received) != 1 {
+		err = fmt.Errorf("%w: expected one subvolume, got %d", ErrInvalidSendStream, len(received))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	replicated.Destination = received[0]
+	replicated.Bytes = stream.n
+	return replicated, nil
+}
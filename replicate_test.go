@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanReplication(t *testing.T) {
+	base := time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)
+	uuid := func(i byte) UUID {
+		return UUID{15: i}
+	}
+	// Source snapshots 1 to 4, read-only except for 4.
+	source := func() []*RetentionSnapshot {
+		var snapshots []*RetentionSnapshot
+		for i := byte(4); i >= 1; i-- {
+			info := &SubvolumeInfo{UUID: uuid(i), Otime: base.Add(time.Duration(i) * time.Hour)}
+			if i != 4 {
+				info.Flags = 1
+			}
+			snapshots = append(snapshots, &RetentionSnapshot{Path: "src" + string('0'+i), Info: info})
+		}
+		return snapshots
+	}
+	destination := func(received ...byte) []*RetentionSnapshot {
+		var snapshots []*RetentionSnapshot
+		for _, i := range received {
+			info := &SubvolumeInfo{UUID: uuid(100 + i), ReceivedUUID: uuid(i)}
+			snapshots = append(snapshots, &RetentionSnapshot{Path: "dst" + string('0'+i), Info: info})
+		}
+		return snapshots
+	}
+
+	type step struct{ source, parent string }
+	tests := []struct {
+		name       string
+		dst        []*RetentionSnapshot
+		latestOnly bool
+		want       []step
+		wantPrune  []string
+	}{
+		{"empty", nil, false, []step{{"src1", ""}, {"src2", "src1"}, {"src3", "src2"}}, nil},
+		{"empty latest", nil, true, []step{{"src3", ""}}, nil},
+		{"incremental", destination(1), false, []step{{"src2", "src1"}, {"src3", "src2"}}, nil},
+		{"newest common", destination(2, 1), true, []step{{"src3", "src2"}}, nil},
+		{"up to date", destination(3), false, nil, nil},
+		{"prune", destination(9, 2), false, []step{{"src3", "src2"}}, []string{"dst9"}},
+		{"unrelated", destination(9), false, []step{{"src1", ""}, {"src2", "src1"}, {"src3", "src2"}}, []string{"dst9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, prune := planReplication(source(), tt.dst, tt.latestOnly)
+
+			var got []step
+			for _, s := range steps {
+				parent := ""
+				if s.parent != nil {
+					parent = s.parent.Path
+				}
+				got = append(got, step{s.source.Path, parent})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planReplication() = %v, want %v", got, tt.want)
+			}
+
+			var gotPrune []string
+			for _, snapshot := range prune {
+				gotPrune = append(gotPrune, snapshot.Path)
+			}
+			if !reflect.DeepEqual(gotPrune, tt.wantPrune) {
+				t.Errorf("planReplication() prune = %v, want %v", gotPrune, tt.wantPrune)
+			}
+		})
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	subvol := filepath.Join(mountpoint.path, "subvol1")
+	if CreateSubvolume(subvol) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	src := filepath.Join(mountpoint.path, "src")
+	dst := filepath.Join(mountpoint.path, "dst")
+	os.Mkdir(src, 0755)
+	os.Mkdir(dst, 0755)
+
+	for _, name := range []string{"snap1", "snap2"} {
+		os.WriteFile(filepath.Join(subvol, name), []byte(name), 0644)
+		if CreateSnapshot(subvol, filepath.Join(src, name), false, true) != nil {
+			t.Error("Failed to create snapshots")
+		}
+	}
+
+	result, err := Replicate(context.Background(), src, dst, nil)
+	if err != nil {
+		t.Fatalf("Replicate() error = %v", err)
+	}
+	if len(result.Transferred) != 2 || result.Transferred[0].Parent != "" || result.Transferred[1].Parent != filepath.Join(src, "snap1") {
+		t.Fatalf("Replicate() transferred = %v", result.Transferred)
+	}
+	for _, replicated := range result.Transferred {
+		if replicated.Bytes == 0 {
+			t.Errorf("Replicate() bytes of %v = 0", replicated.Source)
+		}
+	}
+
+	// Deleting a source snapshot prunes its copy, but nothing else is transferred.
+	if DeleteSubvolume(filepath.Join(src, "snap1"), false) != nil {
+		t.Error("Failed to delete subvolumes")
+	}
+	result, err = Replicate(context.Background(), src, dst, &ReplicateOptions{Prune: true})
+	if err != nil {
+		t.Fatalf("Replicate() error = %v", err)
+	}
+	if len(result.Transferred) != 0 {
+		t.Errorf("Replicate() transferred = %v, want none", result.Transferred)
+	}
+	if want := []string{filepath.Join(dst, "snap1")}; !reflect.DeepEqual(result.Pruned, want) {
+		t.Errorf("Replicate() pruned = %v, want %v", result.Pruned, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "snap2", "snap2")); string(data) != "snap2" {
+		t.Errorf("Replicate() file content mismatch")
+	}
+}
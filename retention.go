@@ -0,0 +1,284 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Retention is a snapshot retention policy.
+// A snapshot is kept if any rule selects it, all other snapshots are deleted.
+// Bucket rules keep the newest snapshot of each of the given number of most recent
+// hours, days, ISO weeks, months or years that contain a snapshot.
+type Retention struct {
+	// KeepLast keeps the given number of newest snapshots.
+	KeepLast int
+	Hourly   int
+	Daily    int
+	Weekly   int
+	Monthly  int
+	Yearly   int
+	// MinAge keeps all snapshots younger than the given duration.
+	MinAge time.Duration
+	// MaxCount limits the number of kept snapshots, dropping the oldest first.
+	// Protected snapshots are kept regardless. Zero means no limit.
+	MaxCount int
+	// Location is the time zone used for bucketing. If nil, time.Local is used.
+	Location *time.Location
+	// Held reports whether a snapshot is held and must not be deleted. May be nil.
+	Held func(path string, info *SubvolumeInfo) bool
+	// Recursive makes Apply delete snapshots together with the subvolumes nested in them,
+	// such as those taken by recursive snapshots.
+	Recursive bool
+}
+
+// RetentionSnapshot is a snapshot considered by a Retention policy.
+type RetentionSnapshot struct {
+	Path string
+	Info *SubvolumeInfo
+	// Reasons lists the rules keeping the snapshot, e.g. "daily" or "held".
+	Reasons []string
+	// Protected is set for held snapshots, parents of received snapshots
+	// and the newest received snapshot.
+	Protected bool
+}
+
+// RetentionPlan is the outcome of applying a Retention policy, ordered from newest to oldest.
+type RetentionPlan struct {
+	Keep   []*RetentionSnapshot
+	Delete []*RetentionSnapshot
+}
+
+// Plan returns which snapshots directly beneath snapshotDir a call to Apply would keep
+// and delete, without deleting anything.
+// Snapshots which are the parent of a received snapshot anywhere in the filesystem are protected,
+// as is the newest received snapshot, as they are needed to receive further incremental send streams.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func (r *Retention) Plan(snapshotDir string) (*RetentionPlan, error) {
+	snapshots, err := listSnapshots(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	parents, err := receivedParents(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	protectReceived(snapshots, parents)
+	for _, snapshot := range snapshots {
+		if r.Held != nil && r.Held(snapshot.Path, snapshot.Info) {
+			snapshot.Protected = true
+			snapshot.Reasons = append(snapshot.Reasons, "held")
+		}
+	}
+	return r.plan(snapshots, time.Now()), nil
+}
+
+// Apply deletes the snapshots directly beneath snapshotDir which are not kept by the policy.
+// It returns the executed plan; on error, the snapshots before the failing one have been deleted.
+// See Plan.
+func (r *Retention) Apply(ctx context.Context, snapshotDir string) (*RetentionPlan, error) {
+	plan, err := r.Plan(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range plan.Delete {
+		if err := ctx.Err(); err != nil {
+			return plan, err
+		}
+		if err := DeleteSubvolume(snapshot.Path, r.Recursive); err != nil {
+			return plan, fmt.Errorf("%s: %w", snapshot.Path, err)
+		}
+	}
+	return plan, nil
+}
+
+// plan sorts the snapshots by Otime and decides which are kept.
+func (r *Retention) plan(snapshots []*RetentionSnapshot, now time.Time) *RetentionPlan {
+	loc := r.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Info.Otime.After(snapshots[j].Info.Otime)
+	})
+
+	for i, snapshot := range snapshots {
+		if i < r.KeepLast {
+			snapshot.Reasons = append(snapshot.Reasons, "last")
+		}
+		if r.MinAge > 0 && now.Sub(snapshot.Info.Otime) < r.MinAge {
+			snapshot.Reasons = append(snapshot.Reasons, "min-age")
+		}
+	}
+
+	buckets := []struct {
+		name  string
+		count int
+		key   func(t time.Time) string
+	}{
+		{"hourly", r.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{"daily", r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", r.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", r.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, bucket := range buckets {
+		last := ""
+		kept := 0
+		for _, snapshot := range snapshots {
+			if kept >= bucket.count {
+				break
+			}
+			// Snapshots are sorted newest first, so the first one seen in a bucket is its newest.
+			key := bucket.key(snapshot.Info.Otime.In(loc))
+			if key == last {
+				continue
+			}
+			last = key
+			kept++
+			snapshot.Reasons = append(snapshot.Reasons, bucket.name)
+		}
+	}
+
+	plan := new(RetentionPlan)
+	for _, snapshot := range snapshots {
+		if len(snapshot.Reasons) > 0 {
+			plan.Keep = append(plan.Keep, snapshot)
+		} else {
+			plan.Delete = append(plan.Delete, snapshot)
+		}
+	}
+
+	if r.MaxCount > 0 && len(plan.Keep) > r.MaxCount {
+		keep := plan.Keep[:0]
+		var excess []*RetentionSnapshot
+		for _, snapshot := range plan.Keep {
+			if len(keep) < r.MaxCount || snapshot.Protected {
+				keep = append(keep, snapshot)
+			} else {
+				excess = append(excess, snapshot)
+			}
+		}
+		plan.Keep = keep
+		plan.Delete = append(plan.Delete, excess...)
+		sort.SliceStable(plan.Delete, func(i, j int) bool {
+			return plan.Delete[i].Info.Otime.After(plan.Delete[j].Info.Otime)
+		})
+	}
+	return plan
+}
+
+// protectReceived protects the snapshots whose UUID is in parents and the newest received snapshot.
+func protectReceived(snapshots []*RetentionSnapshot, parents map[UUID]bool) {
+	var newest *RetentionSnapshot
+	for _, snapshot := range snapshots {
+		if parents[snapshot.Info.UUID] {
+			snapshot.Protected = true
+			snapshot.Reasons = append(snapshot.Reasons, "received parent")
+		}
+		if !snapshot.Info.ReceivedUUID.IsZero() && (newest == nil || snapshot.Info.Otime.After(newest.Info.Otime)) {
+			newest = snapshot
+		}
+	}
+	if newest != nil {
+		newest.Protected = true
+		newest.Reasons = append(newest.Reasons, "newest received")
+	}
+}
+
+// listSnapshots returns the subvolumes which are direct children of dir.
+func listSnapshots(dir string) ([]*RetentionSnapshot, error) {
+	// The iterator lists subvolumes relative to a subvolume, so start at the one containing dir.
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	rel := "."
+	for {
+		ok, err := IsSubvolume(root)
+		if err != nil && !errors.Is(err, ErrNotSubvolume) {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return nil, ErrNotSubvolume
+		}
+		rel = filepath.Join(filepath.Base(root), rel)
+		root = parent
+	}
+
+	it, err := CreateSubvolumeInfoIterator(root, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Destroy()
+
+	var snapshots []*RetentionSnapshot
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Dir(result.Path) != rel {
+			continue
+		}
+		snapshots = append(snapshots, &RetentionSnapshot{Path: filepath.Join(root, result.Path), Info: result.Info})
+	}
+	return snapshots, nil
+}
+
+// receivedParents returns the UUIDs of all subvolumes in the filesystem
+// which are the parent of a received subvolume.
+func receivedParents(path string) (map[UUID]bool, error) {
+	it, err := CreateSubvolumeInfoIterator(path, C.BTRFS_FS_TREE_OBJECTID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Destroy()
+
+	parents := make(map[UUID]bool)
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return nil, err
+		}
+		if !result.Info.ReceivedUUID.IsZero() && !result.Info.ParentUUID.IsZero() {
+			parents[result.Info.ParentUUID] = true
+		}
+	}
+	return parents, nil
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionPlan(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 30, 0, 0, time.UTC)
+
+	// One snapshot every 6 hours over the last 60 days.
+	newSnapshots := func() []*RetentionSnapshot {
+		var snapshots []*RetentionSnapshot
+		for i := 0; i < 60*4; i++ {
+			otime := now.Add(-time.Duration(i) * 6 * time.Hour)
+			snapshots = append(snapshots, &RetentionSnapshot{
+				Path: fmt.Sprint(i),
+				Info: &SubvolumeInfo{Otime: otime},
+			})
+		}
+		return snapshots
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		protected []string
+		want      []string
+	}{
+		{"keep-last", Retention{KeepLast: 3}, nil, []string{"0", "1", "2"}},
+		{"hourly", Retention{Hourly: 2}, nil, []string{"0", "1"}},
+		{"daily", Retention{Daily: 3}, nil, []string{"0", "3", "7"}},
+		{"weekly", Retention{Weekly: 2}, nil, []string{"0", "11"}},
+		{"monthly", Retention{Monthly: 3}, nil, []string{"0", "59", "183"}},
+		{"yearly", Retention{Yearly: 2}, nil, []string{"0"}},
+		{"min-age", Retention{MinAge: 13 * time.Hour}, nil, []string{"0", "1", "2"}},
+		{"max-count", Retention{Daily: 5, MaxCount: 2}, nil, []string{"0", "3"}},
+		{"max-count protected", Retention{Daily: 5, MaxCount: 2}, []string{"10"}, []string{"0", "3", "10"}},
+		{"protected", Retention{}, []string{"7"}, []string{"7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.retention.Location = time.UTC
+
+			snapshots := newSnapshots()
+			for _, snapshot := range snapshots {
+				for _, path := range tt.protected {
+					if snapshot.Path == path {
+						snapshot.Protected = true
+						snapshot.Reasons = append(snapshot.Reasons, "held")
+					}
+				}
+			}
+
+			plan := tt.retention.plan(snapshots, now)
+
+			var got []string
+			for _, snapshot := range plan.Keep {
+				got = append(got, snapshot.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Retention.plan() keep = %v, want %v", got, tt.want)
+			}
+			if len(plan.Keep)+len(plan.Delete) != len(snapshots) {
+				t.Errorf("Retention.plan() keep %d + delete %d != %d", len(plan.Keep), len(plan.Delete), len(snapshots))
+			}
+		})
+	}
+}
+
+func TestProtectReceived(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 30, 0, 0, time.UTC)
+	snapshot := func(path string, age time.Duration, uuid, received UUID) *RetentionSnapshot {
+		return &RetentionSnapshot{Path: path, Info: &SubvolumeInfo{Otime: now.Add(-age), UUID: uuid, ReceivedUUID: received}}
+	}
+
+	tests := []struct {
+		name      string
+		snapshots []*RetentionSnapshot
+		parents   map[UUID]bool
+		want      []string
+	}{
+		{"none received", []*RetentionSnapshot{snapshot("a", 0, UUID{1}, UUID{})}, nil, nil},
+		{"newest received", []*RetentionSnapshot{
+			snapshot("a", 2*time.Hour, UUID{1}, UUID{11}),
+			snapshot("b", time.Hour, UUID{2}, UUID{12}),
+			snapshot("c", 0, UUID{3}, UUID{}),
+		}, nil, []string{"b"}},
+		{"received parent", []*RetentionSnapshot{
+			snapshot("a", 2*time.Hour, UUID{1}, UUID{11}),
+			snapshot("b", time.Hour, UUID{2}, UUID{12}),
+		}, map[UUID]bool{{1}: true}, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protectReceived(tt.snapshots, tt.parents)
+			var got []string
+			for _, snapshot := range tt.snapshots {
+				if snapshot.Protected {
+					got = append(got, snapshot.Path)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("protectReceived() protected = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionApply(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	snapshots := filepath.Join(mountpoint.path, "snapshots")
+	os.Mkdir(snapshots, 0770)
+
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	for i := 0; i < 4; i++ {
+		snap := filepath.Join(snapshots, fmt.Sprint("snap", i))
+		if CreateSnapshot(filepath.Join(mountpoint.path, "subvol1"), snap, false, true) != nil {
+			t.Error("Failed to create snapshots")
+		}
+	}
+
+	retention := Retention{
+		KeepLast: 2,
+		Held: func(path string, info *SubvolumeInfo) bool {
+			return filepath.Base(path) == "snap0"
+		},
+	}
+
+	plan, err := retention.Plan(snapshots)
+	if err != nil {
+		t.Fatalf("Retention.Plan() error = %v", err)
+	}
+	if len(plan.Keep) != 3 || len(plan.Delete) != 1 {
+		t.Errorf("Retention.Plan() keep %d, delete %d, want 3, 1", len(plan.Keep), len(plan.Delete))
+	}
+	if _, err := os.Stat(filepath.Join(snapshots, "snap1")); err != nil {
+		t.Errorf("Retention.Plan() deleted snapshots")
+	}
+
+	if _, err := retention.Apply(context.Background(), snapshots); err != nil {
+		t.Fatalf("Retention.Apply() error = %v", err)
+	}
+	for i, want := range []bool{true, false, true, true} {
+		_, err := os.Stat(filepath.Join(snapshots, fmt.Sprint("snap", i)))
+		if got := err == nil; got != want {
+			t.Errorf("snap%d exists = %v, want %v", i, got, want)
+		}
+	}
+}
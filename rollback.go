@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #define _GNU_SOURCE
+// #include <stdlib.h>
+// #include <stdio.h>
+// #include <fcntl.h>
+import "C"
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+	"unsafe"
+)
+
+// RollbackOptions configures Rollback.
+type RollbackOptions struct {
+	// SafetyPath is where the read-only safety snapshot of the live subvolume is created.
+	// Defaults to "<livePath>.pre-rollback-<unix time>".
+	SafetyPath string
+	// DeleteOld deletes the previous live subvolume once the rollback is complete.
+	// Otherwise it is kept at RollbackResult.OldPath. Deleting fails if it is still mounted.
+	DeleteOld bool
+}
+
+// RollbackResult describes a completed Rollback, or one which failed after the exchange.
+type RollbackResult struct {
+	// OldId is the ID of the subvolume which was live before the rollback.
+	OldId uint64
+	// NewId is the ID of the subvolume which is now live.
+	NewId uint64
+	// OldPath is the path of the previous live subvolume, empty if it was deleted.
+	OldPath string
+	// SafetyPath is the path of the read-only safety snapshot.
+	SafetyPath string
+	// DefaultChanged is set if the live subvolume was the default subvolume and the default was updated.
+	DefaultChanged bool
+}
+
+// Rollback replaces the subvolume at livePath with a writable snapshot of snapshotPath.
+// A read-only safety snapshot of the live subvolume is taken first. The new subvolume is created
+// next to livePath and swapped into place atomically with renameat2(RENAME_EXCHANGE).
+// If the live subvolume was the default subvolume, the new one becomes the default.
+// The filesystem is synced between the steps, so after a crash livePath refers to either
+// the complete old or the complete new subvolume.
+//
+// The exchange and the change of the default subvolume are separate steps, however. A crash between
+// them leaves livePath at the new subvolume while the default subvolume is still the old one, now at
+// "<livePath>.rollback-<unix time>". To recover, compare GetDefaultSubvolume with the ID of that
+// subvolume and, if they match, set the default to the ID of the subvolume at livePath.
+//
+// If an error occurs after the exchange, Rollback returns it together with a non-nil result:
+// livePath then already refers to the new subvolume and the old one is at OldPath, but NewId
+// and DefaultChanged may not be set and the default subvolume may still be the old one.
+func Rollback(livePath string, snapshotPath string, opts *RollbackOptions) (*RollbackResult, error) {
+	if opts == nil {
+		opts = &RollbackOptions{}
+	}
+	livePath = filepath.Clean(livePath)
+
+	if ok, err := IsSubvolume(livePath); !ok {
+		return nil, err
+	}
+	oldId, err := SubvolumeId(livePath)
+	if err != nil {
+		return nil, err
+	}
+	defaultId, err := GetDefaultSubvolume(livePath)
+	if err != nil {
+		return nil, err
+	}
+
+	stamp := time.Now().Unix()
+	result := RollbackResult{
+		OldId:      oldId,
+		OldPath:    fmt.Sprintf("%s.rollback-%d", livePath, stamp),
+		SafetyPath: opts.SafetyPath,
+	}
+	if result.SafetyPath == "" {
+		result.SafetyPath = fmt.Sprintf("%s.pre-rollback-%d", livePath, stamp)
+	}
+
+	if err := CreateSnapshot(livePath, result.SafetyPath, false, true); err != nil {
+		return nil, err
+	}
+	// The new subvolume is created under the name the old one will have after the exchange.
+	if err := CreateSnapshot(snapshotPath, result.OldPath, false, false); err != nil {
+		return nil, err
+	}
+	if err := Sync(livePath); err != nil {
+		return nil, err
+	}
+
+	if err := renameExchange(result.OldPath, livePath); err != nil {
+		DeleteSubvolume(result.OldPath, false)
+		return nil, err
+	}
+	if err := Sync(livePath); err != nil {
+		return &result, err
+	}
+
+	result.NewId, err = SubvolumeId(livePath)
+	if err != nil {
+		return &result, err
+	}
+
+	if defaultId == oldId {
+		if err := SetDefaultSubvolume(livePath, result.NewId); err != nil {
+			return &result, err
+		}
+		if err := Sync(livePath); err != nil {
+			return &result, err
+		}
+		result.DefaultChanged = true
+	}
+
+	if opts.DeleteOld {
+		if err := DeleteSubvolume(result.OldPath, false); err != nil {
+			return &result, err
+		}
+		result.OldPath = ""
+	}
+	return &result, nil
+}
+
+// renameExchange atomically exchanges two paths.
+func renameExchange(oldpath string, newpath string) error {
+	Coldpath := C.CString(oldpath)
+	defer C.free(unsafe.Pointer(Coldpath))
+
+	Cnewpath := C.CString(newpath)
+	defer C.free(unsafe.Pointer(Cnewpath))
+
+	if ret, err := C.renameat2(C.AT_FDCWD, Coldpath, C.AT_FDCWD, Cnewpath, C.RENAME_EXCHANGE); ret != 0 {
+		return fmt.Errorf("%w: %v", ErrRenameFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	tests := []struct {
+		name        string
+		setDefault  bool
+		deleteOld   bool
+		wantDefault bool
+	}{
+		{"default", true, false, true},
+		{"delete-old", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := filepath.Join(mountpoint.path, tt.name)
+			snap := filepath.Join(mountpoint.path, tt.name+"-snap")
+
+			if CreateSubvolume(live) != nil {
+				t.Error("Failed to create subvolumes")
+			}
+			os.WriteFile(filepath.Join(live, "before"), nil, 0660)
+			if CreateSnapshot(live, snap, false, true) != nil {
+				t.Error("Failed to create snapshots")
+			}
+			os.WriteFile(filepath.Join(live, "after"), nil, 0660)
+
+			oldId, err := SubvolumeId(live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setDefault {
+				if err := SetDefaultSubvolume(live, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Rollback(live, snap, &RollbackOptions{DeleteOld: tt.deleteOld})
+			if err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+			if got.OldId != oldId || got.NewId == oldId || got.DefaultChanged != tt.wantDefault {
+				t.Errorf("Rollback() = %+v, want OldId %d and new default %v", got, oldId, tt.wantDefault)
+			}
+
+			if _, err := os.Stat(filepath.Join(live, "before")); err != nil {
+				t.Errorf("Rollback() live subvolume misses snapshot contents")
+			}
+			if _, err := os.Stat(filepath.Join(live, "after")); err == nil {
+				t.Errorf("Rollback() live subvolume still has newer contents")
+			}
+			if _, err := os.Stat(filepath.Join(got.SafetyPath, "after")); err != nil {
+				t.Errorf("Rollback() safety snapshot misses live contents")
+			}
+			if ro, _ := GetSubvolumeReadOnly(live); ro {
+				t.Errorf("Rollback() live subvolume is read-only")
+			}
+			if tt.deleteOld != (got.OldPath == "") {
+				t.Errorf("Rollback() OldPath = %q, deleteOld %v", got.OldPath, tt.deleteOld)
+			}
+
+			if id, _ := GetDefaultSubvolume(mountpoint.path); tt.wantDefault && id != got.NewId {
+				t.Errorf("GetDefaultSubvolume() = %d, want %d", id, got.NewId)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+import "C"
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ScrubProgress is the progress of a running scrub on one device, see struct btrfs_scrub_progress.
+type ScrubProgress struct {
+	DataExtentsScrubbed uint64
+	TreeExtentsScrubbed uint64
+	DataBytesScrubbed   uint64
+	TreeBytesScrubbed   uint64
+	ReadErrors          uint64
+	CsumErrors          uint64
+	VerifyErrors        uint64
+	NoCsum              uint64
+	CsumDiscards        uint64
+	SuperErrors         uint64
+	MallocErrors        uint64
+	UncorrectableErrors uint64
+	CorrectedErrors     uint64
+	LastPhysical        uint64
+	UnverifiedErrors    uint64
+}
+
+// GetScrubProgress returns the progress of the scrub running on the device with the given ID
+// in the filesystem containing path. It returns ErrNotRunning if no scrub is running on the device.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func GetScrubProgress(path string, devid uint64) (*ScrubProgress, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return GetScrubProgressFd(file.Fd(), devid)
+}
+
+// See GetScrubProgress.
+func GetScrubProgressFd(fd uintptr, devid uint64) (*ScrubProgress, error) {
+	args := new(C.struct_btrfs_ioctl_scrub_args)
+	args.devid = C.__u64(devid)
+
+	if err := ioctl(fd, C.BTRFS_IOC_SCRUB_PROGRESS, unsafe.Pointer(args)); err != nil {
+		if err == syscall.ENOTCONN {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("%w: %v", ErrScrubProgressFailed, err)
+	}
+
+	p := &args.progress
+	return &ScrubProgress{
+		DataExtentsScrubbed: uint64(p.data_extents_scrubbed),
+		TreeExtentsScrubbed: uint64(p.tree_extents_scrubbed),
+		DataBytesScrubbed:   uint64(p.data_bytes_scrubbed),
+		TreeBytesScrubbed:   uint64(p.tree_bytes_scrubbed),
+		ReadErrors:          uint64(p.read_errors),
+		CsumErrors:          uint64(p.csum_errors),
+		VerifyErrors:        uint64(p.verify_errors),
+		NoCsum:              uint64(p.no_csum),
+		CsumDiscards:        uint64(p.csum_discards),
+		SuperErrors:         uint64(p.super_errors),
+		MallocErrors:        uint64(p.malloc_errors),
+		UncorrectableErrors: uint64(p.uncorrectable_errors),
+		CorrectedErrors:     uint64(p.corrected_errors),
+		LastPhysical:        uint64(p.last_physical),
+		UnverifiedErrors:    uint64(p.unverified_errors),
+	}, nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+import "C"
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+// SendOptions configures Send.
+type SendOptions struct {
+	// Parent is a read-only snapshot to send an incremental stream against.
+	// It is also used as a clone source.
+	Parent string
+	// CloneSources are additional read-only subvolumes the stream may clone extents from.
+	CloneSources []string
+	// NoData omits file data; changed ranges are sent as update_extent commands instead.
+	NoData bool
+	// Protocol selects the send protocol version. Zero uses the kernel default.
+	Protocol uint32
+	// Compressed sends compressed extents without decompressing them.
+	// Requires protocol version 2.
+	Compressed bool
+}
+
+// Send writes a send stream of a read-only snapshot to w, like btrfs send.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func Send(ctx context.Context, snapshot string, w io.Writer, opts *SendOptions) error {
+	if opts == nil {
+		opts = &SendOptions{}
+	}
+
+	subvol, err := os.Open(snapshot)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer subvol.Close()
+
+	args := new(C.struct_btrfs_ioctl_send_args)
+
+	var cloneSources []uint64
+	if opts.Parent != "" {
+		id, err := SubvolumeId(opts.Parent)
+		if err != nil {
+			return err
+		}
+		args.parent_root = C.__u64(id)
+		cloneSources = append(cloneSources, id)
+	}
+	for _, source := range opts.CloneSources {
+		id, err := SubvolumeId(source)
+		if err != nil {
+			return err
+		}
+		cloneSources = append(cloneSources, id)
+	}
+	if len(cloneSources) > 0 {
+		args.clone_sources = (*C.__u64)(unsafe.Pointer(&cloneSources[0]))
+		args.clone_sources_count = C.__u64(len(cloneSources))
+	}
+
+	if opts.NoData {
+		args.flags |= C.BTRFS_SEND_FLAG_NO_FILE_DATA
+	}
+	if opts.Protocol != 0 {
+		args.flags |= C.BTRFS_SEND_FLAG_VERSION
+		args.version = C.__u32(opts.Protocol)
+	}
+	if opts.Compressed {
+		args.flags |= C.BTRFS_SEND_FLAG_COMPRESSED
+	}
+
+	// The kernel writes to a file descriptor; anything else is fed through a pipe.
+	if file, ok := w.(*os.File); ok {
+		return sendFd(subvol.Fd(), file.Fd(), args, cloneSources)
+	}
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sendFd(subvol.Fd(), pw.Fd(), args, cloneSources)
+		pw.Close()
+	}()
+
+	// Closing the read end makes the kernel fail with EPIPE.
+	copied := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-copied:
+		}
+	}()
+
+	_, copyErr := io.Copy(w, r)
+	close(copied)
+	r.Close()
+	sendErr := <-done
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	return copyErr
+}
+
+func sendFd(fd uintptr, sendFd uintptr, args *C.struct_btrfs_ioctl_send_args, cloneSources []uint64) error {
+	args.send_fd = C.__s64(sendFd)
+
+	err := ioctl(fd, C.BTRFS_IOC_SEND, unsafe.Pointer(args))
+	runtime.KeepAlive(cloneSources)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSendFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Send stream commands from fs/btrfs/send.h.
+const (
+	sendCmdSubvol       = 1
+	sendCmdSnapshot     = 2
+	sendCmdMkfile       = 3
+	sendCmdMkdir        = 4
+	sendCmdMknod        = 5
+	sendCmdMkfifo       = 6
+	sendCmdMksock       = 7
+	sendCmdSymlink      = 8
+	sendCmdRename       = 9
+	sendCmdLink         = 10
+	sendCmdUnlink       = 11
+	sendCmdRmdir        = 12
+	sendCmdSetXattr     = 13
+	sendCmdRemoveXattr  = 14
+	sendCmdWrite        = 15
+	sendCmdClone        = 16
+	sendCmdTruncate     = 17
+	sendCmdChmod        = 18
+	sendCmdChown        = 19
+	sendCmdUtimes       = 20
+	sendCmdEnd          = 21
+	sendCmdUpdateExtent = 22
+	sendCmdFallocate    = 23
+	sendCmdFileattr     = 24
+	sendCmdEncodedWrite = 25
+	sendCmdEnableVerity = 26
+)
+
+// Send stream attributes from fs/btrfs/send.h.
+const (
+	sendAttrUUID             = 1
+	sendAttrCtransid         = 2
+	sendAttrIno              = 3
+	sendAttrSize             = 4
+	sendAttrMode             = 5
+	sendAttrUid              = 6
+	sendAttrGid              = 7
+	sendAttrRdev             = 8
+	sendAttrCtime            = 9
+	sendAttrMtime            = 10
+	sendAttrAtime            = 11
+	sendAttrOtime            = 12
+	sendAttrXattrName        = 13
+	sendAttrXattrData        = 14
+	sendAttrPath             = 15
+	sendAttrPathTo           = 16
+	sendAttrPathLink         = 17
+	sendAttrFileOffset       = 18
+	sendAttrData             = 19
+	sendAttrCloneUUID        = 20
+	sendAttrCloneCtransid    = 21
+	sendAttrClonePath        = 22
+	sendAttrCloneOffset      = 23
+	sendAttrCloneLen         = 24
+	sendAttrFallocateMode    = 25
+	sendAttrFileattr         = 26
+	sendAttrUnencodedFileLen = 27
+	sendAttrUnencodedLen     = 28
+	sendAttrUnencodedOffset  = 29
+	sendAttrCompression      = 30
+	sendAttrEncryption       = 31
+)
+
+const (
+	sendStreamMagic        = "btrfs-stream\x00"
+	sendStreamHeaderLength = len(sendStreamMagic) + 4
+	sendCmdHeaderLength    = 10
+	// sendCmdMaxLength is the send buffer size of protocol version 2 in btrfs-progs, which
+	// holds the largest command of any version: 16 KiB and a compressed extent of 128 KiB.
+	sendCmdMaxLength = 16*1024 + encodedMaxLength
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// sendCommand is a single command of a send stream.
+type sendCommand struct {
+	cmd   uint16
+	attrs map[uint16][]byte
+}
+
+func (c *sendCommand) bytes(attr uint16) ([]byte, error) {
+	value, ok := c.attrs[attr]
+	if !ok {
+		return nil, ErrInvalidSendStream
+	}
+	return value, nil
+}
+
+func (c *sendCommand) string(attr uint16) (string, error) {
+	value, err := c.bytes(attr)
+	return string(value), err
+}
+
+func (c *sendCommand) uint64(attr uint16) (uint64, error) {
+	value, err := c.bytes(attr)
+	if err != nil {
+		return 0, err
+	}
+	switch len(value) {
+	case 8:
+		return binary.LittleEndian.Uint64(value), nil
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(value)), nil
+	}
+	return 0, ErrInvalidSendStream
+}
+
+func (c *sendCommand) uuid(attr uint16) (UUID, error) {
+	var uuid UUID
+	value, err := c.bytes(attr)
+	if err != nil {
+		return uuid, err
+	}
+	if len(value) != len(uuid) {
+		return uuid, ErrInvalidSendStream
+	}
+	copy(uuid[:], value)
+	return uuid, nil
+}
+
+func (c *sendCommand) time(attr uint16) (time.Time, error) {
+	value, err := c.bytes(attr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(value) != 12 {
+		return time.Time{}, ErrInvalidSendStream
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint64(value)), int64(binary.LittleEndian.Uint32(value[8:]))), nil
+}
+
+// sendStreamReader decodes the commands of a send stream.
+// Several streams may be concatenated, as produced by btrfs send with multiple subvolumes.
+type sendStreamReader struct {
+	r       *bufio.Reader
+	version uint32
+}
+
+func newSendStreamReader(r io.Reader) *sendStreamReader {
+	return &sendStreamReader{r: bufio.NewReaderSize(r, 256*1024)}
+}
+
+// next returns the next command, reading a new stream header if necessary.
+// It returns io.EOF once no further stream follows.
+func (s *sendStreamReader) next() (*sendCommand, error) {
+	if s.version == 0 {
+		header := make([]byte, sendStreamHeaderLength)
+		if _, err := io.ReadFull(s.r, header); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, ErrInvalidSendStream
+		}
+		if string(header[:len(sendStreamMagic)]) != sendStreamMagic {
+			return nil, ErrInvalidSendStream
+		}
+		s.version = binary.LittleEndian.Uint32(header[len(sendStreamMagic):])
+		if s.version == 0 {
+			return nil, ErrInvalidSendStream
+		}
+	}
+
+	header := make([]byte, sendCmdHeaderLength)
+	if _, err := io.ReadFull(s.r, header); err != nil {
+		return nil, ErrInvalidSendStream
+	}
+	length := binary.LittleEndian.Uint32(header)
+	cmd := sendCommand{
+		cmd:   binary.LittleEndian.Uint16(header[4:]),
+		attrs: make(map[uint16][]byte),
+	}
+	crc := binary.LittleEndian.Uint32(header[6:])
+	if length > sendCmdMaxLength {
+		return nil, ErrInvalidSendStream
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(s.r, payload); err != nil {
+		return nil, ErrInvalidSendStream
+	}
+
+	// The checksum is a raw crc32c seeded with zero over the header without its crc field.
+	header[6], header[7], header[8], header[9] = 0, 0, 0, 0
+	sum := crc32.Update(^uint32(0), crc32cTable, header)
+	sum = crc32.Update(sum, crc32cTable, payload)
+	if ^sum != crc {
+		return nil, ErrInvalidSendStream
+	}
+
+	for pos := 0; pos < len(payload); {
+		if pos+4 > len(payload) {
+			return nil, ErrInvalidSendStream
+		}
+		attr := binary.LittleEndian.Uint16(payload[pos:])
+		size := int(binary.LittleEndian.Uint16(payload[pos+2:]))
+		pos += 4
+
+		// Starting with version 2 the data attribute has no length and spans the rest of the command.
+		if attr == sendAttrData && s.version >= 2 {
+			pos -= 2
+			size = len(payload) - pos
+		}
+		if pos+size > len(payload) {
+			return nil, ErrInvalidSendStream
+		}
+		cmd.attrs[attr] = payload[pos : pos+size]
+		pos += size
+	}
+
+	if cmd.cmd == sendCmdEnd {
+		s.version = 0
+	}
+	return &cmd, nil
+}
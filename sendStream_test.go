@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testSendAttr struct {
+	attr  uint16
+	value []byte
+}
+
+// encodeSendCommand encodes a send stream command including its checksum.
+func encodeSendCommand(version uint32, cmd uint16, attrs ...testSendAttr) []byte {
+	var payload []byte
+	for _, a := range attrs {
+		payload = binary.LittleEndian.AppendUint16(payload, a.attr)
+		if a.attr != sendAttrData || version < 2 {
+			payload = binary.LittleEndian.AppendUint16(payload, uint16(len(a.value)))
+		}
+		payload = append(payload, a.value...)
+	}
+
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint16(buf, cmd)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	buf = append(buf, payload...)
+	binary.LittleEndian.PutUint32(buf[6:], ^crc32.Update(^uint32(0), crc32cTable, buf))
+	return buf
+}
+
+func encodeSendStreamHeader(version uint32) []byte {
+	return binary.LittleEndian.AppendUint32([]byte(sendStreamMagic), version)
+}
+
+func TestSendStreamReader(t *testing.T) {
+	path := testSendAttr{sendAttrPath, []byte("foo")}
+	offset := testSendAttr{sendAttrFileOffset, binary.LittleEndian.AppendUint64(nil, 4096)}
+	data := testSendAttr{sendAttrData, []byte("data")}
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		want    []*sendCommand
+		wantErr bool
+	}{
+		{
+			"v1",
+			bytes.Join([][]byte{
+				encodeSendStreamHeader(1),
+				encodeSendCommand(1, sendCmdWrite, path, offset, data),
+				encodeSendCommand(1, sendCmdEnd),
+			}, nil),
+			[]*sendCommand{
+				{sendCmdWrite, map[uint16][]byte{sendAttrPath: path.value, sendAttrFileOffset: offset.value, sendAttrData: data.value}},
+				{sendCmdEnd, map[uint16][]byte{}},
+			},
+			false,
+		},
+		{
+			"v2 data",
+			bytes.Join([][]byte{
+				encodeSendStreamHeader(2),
+				encodeSendCommand(2, sendCmdWrite, path, offset, data),
+				encodeSendCommand(2, sendCmdEnd),
+			}, nil),
+			[]*sendCommand{
+				{sendCmdWrite, map[uint16][]byte{sendAttrPath: path.value, sendAttrFileOffset: offset.value, sendAttrData: data.value}},
+				{sendCmdEnd, map[uint16][]byte{}},
+			},
+			false,
+		},
+		{
+			"concatenated",
+			bytes.Join([][]byte{
+				encodeSendStreamHeader(1),
+				encodeSendCommand(1, sendCmdEnd),
+				encodeSendStreamHeader(1),
+				encodeSendCommand(1, sendCmdEnd),
+			}, nil),
+			[]*sendCommand{
+				{sendCmdEnd, map[uint16][]byte{}},
+				{sendCmdEnd, map[uint16][]byte{}},
+			},
+			false,
+		},
+		{
+			"bad magic",
+			append([]byte("btrfs-strean\x00\x01\x00\x00\x00"), encodeSendCommand(1, sendCmdEnd)...),
+			nil,
+			true,
+		},
+		{
+			"bad crc",
+			func() []byte {
+				stream := append(encodeSendStreamHeader(1), encodeSendCommand(1, sendCmdUnlink, path)...)
+				stream[len(stream)-1] ^= 0xff
+				return stream
+			}(),
+			nil,
+			true,
+		},
+		{
+			"truncated",
+			append(encodeSendStreamHeader(1), encodeSendCommand(1, sendCmdUnlink, path)[:12]...),
+			nil,
+			true,
+		},
+		{
+			"too long",
+			func() []byte {
+				// The length is rejected before the payload is read or allocated.
+				stream := append(encodeSendStreamHeader(1), encodeSendCommand(1, sendCmdUnlink, path)...)
+				binary.LittleEndian.PutUint32(stream[sendStreamHeaderLength:], 1<<32-1)
+				return stream
+			}(),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newSendStreamReader(bytes.NewReader(tt.stream))
+
+			var got []*sendCommand
+			for {
+				cmd, err := stream.next()
+				if err == io.EOF {
+					break
+				}
+				if (err != nil) != tt.wantErr {
+					t.Errorf("sendStreamReader.next() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				got = append(got, cmd)
+			}
+			if tt.wantErr {
+				t.Errorf("sendStreamReader.next() error = nil, wantErr %v", tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sendStreamReader.next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigDir is the directory snapper keeps its config files in.
+var ConfigDir = "/etc/snapper/configs"
+
+// Config is a snapper config file, a list of shell-style KEY="value" assignments.
+// Comments and the order of the assignments are kept when it is written back.
+type Config struct {
+	// Name is the name of the config, e.g. "root".
+	Name string
+
+	lines  []string
+	values map[string]string
+	// index maps each key to the line it is assigned in.
+	index map[string]int
+}
+
+// LoadConfig reads the config with the given name from ConfigDir.
+func LoadConfig(name string) (*Config, error) {
+	if name == "" || strings.ContainsAny(name, "/\x00") || name == "." || name == ".." {
+		return nil, fmt.Errorf("%w: invalid name %q", ErrInvalidConfig, name)
+	}
+	file, err := os.Open(filepath.Join(ConfigDir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := ParseConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	config.Name = name
+	return config, nil
+}
+
+// ParseConfig parses a snapper config file.
+func ParseConfig(r io.Reader) (*Config, error) {
+	config := &Config{values: make(map[string]string), index: make(map[string]int)}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		config.lines = append(config.lines, line)
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok || !validKey(key) {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidConfig, n)
+		}
+		value, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidConfig, n)
+		}
+		config.values[key] = value
+		config.index[key] = len(config.lines) - 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// unquote removes the double quotes around a value and resolves its backslash escapes.
+func unquote(value string) (string, error) {
+	if !strings.HasPrefix(value, "\"") {
+		return value, nil
+	}
+	if len(value) < 2 || !strings.HasSuffix(value, "\"") {
+		return "", ErrInvalidConfig
+	}
+
+	var b strings.Builder
+	inner := value[1 : len(value)-1]
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		if c == '\\' {
+			if i++; i == len(inner) {
+				return "", ErrInvalidConfig
+			}
+			c = inner[i]
+		} else if c == '"' {
+			return "", ErrInvalidConfig
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+func quote(value string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+	return "\"" + r.Replace(value) + "\""
+}
+
+// Get returns the value of a key and whether it is set.
+func (c *Config) Get(key string) (string, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// Set assigns a value to a key, replacing an existing assignment in place.
+func (c *Config) Set(key string, value string) error {
+	if !validKey(key) {
+		return fmt.Errorf("%w: invalid key %q", ErrInvalidConfig, key)
+	}
+	if c.values == nil {
+		c.values, c.index = make(map[string]string), make(map[string]int)
+	}
+
+	line := key + "=" + quote(value)
+	if i, ok := c.index[key]; ok {
+		c.lines[i] = line
+	} else {
+		c.index[key] = len(c.lines)
+		c.lines = append(c.lines, line)
+	}
+	c.values[key] = value
+	return nil
+}
+
+// Bool returns whether a key is set to "yes", snapper's representation of true.
+func (c *Config) Bool(key string) bool {
+	return c.values[key] == "yes"
+}
+
+// Uint returns the value of a numeric key, e.g. NUMBER_LIMIT, or 0 if it is not a number.
+func (c *Config) Uint(key string) uint64 {
+	n, _ := strconv.ParseUint(c.values[key], 10, 64)
+	return n
+}
+
+// Subvolume returns the path of the subvolume the config snapshots.
+func (c *Config) Subvolume() string {
+	return c.values["SUBVOLUME"]
+}
+
+// SnapshotsDir returns the directory holding the snapshots of the config.
+func (c *Config) SnapshotsDir() string {
+	return filepath.Join(c.Subvolume(), ".snapshots")
+}
+
+// WriteTo writes the config file.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, line := range c.lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.WriteTo(w)
+}
+
+// Save writes the config to its file in ConfigDir, replacing it atomically.
+func (c *Config) Save() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: no name", ErrInvalidConfig)
+	}
+	file, err := os.CreateTemp(ConfigDir, "."+c.Name+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := c.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0640); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(ConfigDir, c.Name))
+}
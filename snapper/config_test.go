@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapper
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const snapperConfig = `
+# subvolume to snapshot
+SUBVOLUME="/"
+
+# filesystem type
+FSTYPE="btrfs"
+
+NUMBER_CLEANUP="yes"
+NUMBER_LIMIT="50"
+DESCRIPTION="say \"hi\" \\ bye"
+EMPTY=""
+`
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(snapperConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := config.Subvolume(); got != "/" {
+		t.Errorf("Subvolume() = %q, want /", got)
+	}
+	if got := config.SnapshotsDir(); got != "/.snapshots" {
+		t.Errorf("SnapshotsDir() = %q, want /.snapshots", got)
+	}
+	if !config.Bool("NUMBER_CLEANUP") || config.Bool("FSTYPE") {
+		t.Errorf("Bool() did not match yes")
+	}
+	if got := config.Uint("NUMBER_LIMIT"); got != 50 {
+		t.Errorf("Uint(NUMBER_LIMIT) = %v, want 50", got)
+	}
+	if got, _ := config.Get("DESCRIPTION"); got != `say "hi" \ bye` {
+		t.Errorf("Get(DESCRIPTION) = %q", got)
+	}
+	if got, ok := config.Get("EMPTY"); !ok || got != "" {
+		t.Errorf("Get(EMPTY) = %q, %v, want empty and set", got, ok)
+	}
+	if _, ok := config.Get("MISSING"); ok {
+		t.Errorf("Get(MISSING) is set")
+	}
+
+	var buf bytes.Buffer
+	config.WriteTo(&buf)
+	if buf.String() != snapperConfig {
+		t.Errorf("WriteTo() = %s, want unchanged %s", buf.String(), snapperConfig)
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, line := range []string{"NO_VALUE", "A B=\"c\"", "A=\"unterminated", "A=\"b\"c\"", "A=\"b\\\""} {
+		if _, err := ParseConfig(strings.NewReader(line)); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseConfig(%q) error = %v, want %v", line, err, ErrInvalidConfig)
+		}
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader("# comment\nNUMBER_LIMIT=\"50\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Set("NUMBER_LIMIT", "10")
+	config.Set("DESCRIPTION", `a "b"`)
+	if err := config.Set("not a key", ""); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Set() with invalid key error = %v, want %v", err, ErrInvalidConfig)
+	}
+
+	var buf bytes.Buffer
+	config.WriteTo(&buf)
+	want := "# comment\nNUMBER_LIMIT=\"10\"\nDESCRIPTION=\"a \\\"b\\\"\"\n"
+	if buf.String() != want {
+		t.Errorf("WriteTo() = %q, want %q", buf.String(), want)
+	}
+
+	reparsed, err := ParseConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reparsed.Get("DESCRIPTION"); got != `a "b"` {
+		t.Errorf("Get(DESCRIPTION) = %q", got)
+	}
+}
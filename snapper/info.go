@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapper
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Type is the type of a snapper snapshot.
+type Type string
+
+const (
+	TypeSingle Type = "single"
+	TypePre    Type = "pre"
+	TypePost   Type = "post"
+)
+
+// dateLayout is the format of the date in info.xml, which is always UTC.
+const dateLayout = "2006-01-02 15:04:05"
+
+// Info is the metadata snapper keeps in the info.xml of a snapshot.
+type Info struct {
+	Type   Type
+	Number uint
+	// PreNumber is the number of the pre snapshot belonging to a post snapshot.
+	PreNumber   uint
+	Date        time.Time
+	Uid         uint32
+	Description string
+	// Cleanup is the cleanup algorithm of the snapshot, e.g. "number" or "timeline".
+	Cleanup  string
+	Userdata map[string]string
+}
+
+// infoXML is the element layout of info.xml as written by snapper.
+type infoXML struct {
+	XMLName     xml.Name      `xml:"snapshot"`
+	Type        Type          `xml:"type"`
+	Number      uint          `xml:"num"`
+	Date        string        `xml:"date"`
+	Uid         uint32        `xml:"uid,omitempty"`
+	PreNumber   uint          `xml:"pre_num,omitempty"`
+	Description string        `xml:"description,omitempty"`
+	Cleanup     string        `xml:"cleanup,omitempty"`
+	Userdata    []userdataXML `xml:"userdata"`
+}
+
+type userdataXML struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+// ParseInfo parses the info.xml of a snapshot.
+func ParseInfo(r io.Reader) (*Info, error) {
+	var raw infoXML
+	if err := xml.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInfo, err)
+	}
+	switch raw.Type {
+	case TypeSingle, TypePre, TypePost:
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidInfo, raw.Type)
+	}
+	date, err := time.ParseInLocation(dateLayout, raw.Date, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInfo, err)
+	}
+
+	info := &Info{
+		Type:        raw.Type,
+		Number:      raw.Number,
+		PreNumber:   raw.PreNumber,
+		Date:        date,
+		Uid:         raw.Uid,
+		Description: raw.Description,
+		Cleanup:     raw.Cleanup,
+	}
+	if len(raw.Userdata) > 0 {
+		info.Userdata = make(map[string]string, len(raw.Userdata))
+		for _, data := range raw.Userdata {
+			info.Userdata[data.Key] = data.Value
+		}
+	}
+	return info, nil
+}
+
+// WriteTo writes the info in the info.xml format of snapper. Userdata is sorted by key.
+func (info *Info) WriteTo(w io.Writer) (int64, error) {
+	raw := infoXML{
+		Type:        info.Type,
+		Number:      info.Number,
+		Date:        info.Date.UTC().Format(dateLayout),
+		Uid:         info.Uid,
+		Description: info.Description,
+		Cleanup:     info.Cleanup,
+	}
+	if info.Type == TypePost {
+		raw.PreNumber = info.PreNumber
+	}
+	keys := make([]string, 0, len(info.Userdata))
+	for key := range info.Userdata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw.Userdata = append(raw.Userdata, userdataXML{Key: key, Value: info.Userdata[key]})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\"?>\n")
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(&raw); err != nil {
+		return 0, err
+	}
+	buf.WriteByte('\n')
+	return buf.WriteTo(w)
+}
+
+// ReadInfo reads the info.xml of the snapshot directory dir, e.g. /.snapshots/42.
+func ReadInfo(dir string) (*Info, error) {
+	file, err := os.Open(filepath.Join(dir, "info.xml"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseInfo(file)
+}
+
+// WriteInfo replaces the info.xml of the snapshot directory dir atomically.
+func WriteInfo(dir string, info *Info) error {
+	file, err := os.CreateTemp(dir, "info.xml.tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := info.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(dir, "info.xml"))
+}
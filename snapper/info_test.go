@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapper
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const snapperInfo = `<?xml version="1.0"?>
+<snapshot>
+  <type>post</type>
+  <num>43</num>
+  <date>2023-05-04 10:11:12</date>
+  <pre_num>42</pre_num>
+  <description>zypp(zypper)</description>
+  <cleanup>number</cleanup>
+  <userdata>
+    <key>important</key>
+    <value>no</value>
+  </userdata>
+</snapshot>
+`
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		want    *Info
+		wantErr bool
+	}{
+		{"snapper", snapperInfo, &Info{
+			Type:        TypePost,
+			Number:      43,
+			PreNumber:   42,
+			Date:        time.Date(2023, 5, 4, 10, 11, 12, 0, time.UTC),
+			Description: "zypp(zypper)",
+			Cleanup:     "number",
+			Userdata:    map[string]string{"important": "no"},
+		}, false},
+		{"minimal", "<snapshot><type>single</type><num>1</num><date>2020-01-01 00:00:00</date></snapshot>", &Info{
+			Type:   TypeSingle,
+			Number: 1,
+			Date:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}, false},
+		{"unknown type", "<snapshot><type>other</type><num>1</num><date>2020-01-01 00:00:00</date></snapshot>", nil, true},
+		{"invalid date", "<snapshot><type>single</type><num>1</num><date>yesterday</date></snapshot>", nil, true},
+		{"not xml", "snapshot", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInfo(strings.NewReader(tt.xml))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseInfo() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && !errors.Is(err, ErrInvalidInfo) {
+				t.Errorf("ParseInfo() error = %v, want %v", err, ErrInvalidInfo)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfoWriteTo(t *testing.T) {
+	info, err := ParseInfo(strings.NewReader(snapperInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := info.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != snapperInfo {
+		t.Errorf("WriteTo() = %s, want %s", buf.String(), snapperInfo)
+	}
+
+	info.Description = `a <b> & "c"`
+	info.Date = time.Date(2023, 5, 4, 12, 11, 12, 0, time.FixedZone("CEST", 2*60*60))
+	buf.Reset()
+	info.WriteTo(&buf)
+	got, err := ParseInfo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != info.Description || !got.Date.Equal(info.Date) {
+		t.Errorf("ParseInfo(WriteTo()) = %+v, want %+v", got, info)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package snapper reads and writes the snapshot metadata and configs of snapper,
+// so that snapshots can be created alongside it on hosts which already use it.
+//
+// A config snapshots the subvolume given by its SUBVOLUME key. Its snapshots are numbered
+// directories in the .snapshots subvolume beneath it, each holding an info.xml and the
+// read-only snapshot itself in a subvolume named snapshot.
+package snapper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+)
+
+var (
+	ErrInvalidConfig       = errors.New("invalid snapper config")
+	ErrInvalidInfo         = errors.New("invalid snapper info.xml")
+	ErrNotBtrfs            = errors.New("snapper config is not for Btrfs")
+	ErrPreSnapshotNotFound = errors.New("pre snapshot not found")
+)
+
+// Snapshot is a snapshot of a snapper config.
+type Snapshot struct {
+	Info
+	// Path is the path of the snapshot subvolume.
+	Path string
+}
+
+// SnapshotPath returns the path of the subvolume of the snapshot with the given number.
+func (c *Config) SnapshotPath(number uint) string {
+	return filepath.Join(c.SnapshotsDir(), strconv.FormatUint(uint64(number), 10), "snapshot")
+}
+
+// ListSnapshots returns the snapshots of a config ordered by number.
+// Directories without a valid info.xml are skipped, as snapper does.
+func ListSnapshots(config *Config) ([]*Snapshot, error) {
+	numbers, err := snapshotNumbers(config.SnapshotsDir())
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*Snapshot
+	for _, number := range numbers {
+		info, err := ReadInfo(filepath.Dir(config.SnapshotPath(number)))
+		if err != nil || info.Number != number {
+			continue
+		}
+		snapshots = append(snapshots, &Snapshot{Info: *info, Path: config.SnapshotPath(number)})
+	}
+	return snapshots, nil
+}
+
+// snapshotNumbers returns the numbers of the snapshot directories in dir in ascending order.
+func snapshotNumbers(dir string) ([]uint, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []uint
+	for _, entry := range entries {
+		n, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil || !entry.IsDir() || n == 0 || strconv.FormatUint(n, 10) != entry.Name() {
+			continue
+		}
+		numbers = append(numbers, uint(n))
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// CreateSnapperSnapshot creates a read-only snapshot of the subvolume of config with the next free number.
+// Post snapshots need the number of their pre snapshot, see CreateSnapperSnapshotWithInfo.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func CreateSnapperSnapshot(config *Config, typ Type, description string) (*Snapshot, error) {
+	return CreateSnapperSnapshotWithInfo(config, &Info{Type: typ, Description: description})
+}
+
+// CreateSnapperSnapshotWithInfo creates a read-only snapshot like CreateSnapperSnapshot
+// with the given metadata. The number and date of info are set by the call.
+// The .snapshots subvolume must exist already, as created by snapper create-config.
+func CreateSnapperSnapshotWithInfo(config *Config, info *Info) (*Snapshot, error) {
+	if fstype, ok := config.Get("FSTYPE"); ok && fstype != "btrfs" {
+		return nil, ErrNotBtrfs
+	}
+	if config.Subvolume() == "" {
+		return nil, fmt.Errorf("%w: SUBVOLUME not set", ErrInvalidConfig)
+	}
+	switch info.Type {
+	case TypeSingle, TypePre:
+	case TypePost:
+		pre, err := ReadInfo(filepath.Dir(config.SnapshotPath(info.PreNumber)))
+		if info.PreNumber == 0 || err != nil || pre.Type != TypePre {
+			return nil, fmt.Errorf("%w: %d", ErrPreSnapshotNotFound, info.PreNumber)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidInfo, info.Type)
+	}
+
+	dir, number, err := allocateNumber(config.SnapshotsDir())
+	if err != nil {
+		return nil, err
+	}
+	info.Number = number
+	info.Date = time.Now().UTC().Truncate(time.Second)
+
+	path := filepath.Join(dir, "snapshot")
+	if err := btrfsutil.CreateSnapshot(config.Subvolume(), path, false, true); err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	if err := WriteInfo(dir, info); err != nil {
+		btrfsutil.DeleteSubvolume(path, false)
+		os.Remove(dir)
+		return nil, err
+	}
+	return &Snapshot{Info: *info, Path: path}, nil
+}
+
+// allocateNumber creates the directory of the snapshot following the highest existing number.
+// Creating the directory claims the number against concurrent snapper processes.
+func allocateNumber(snapshotsDir string) (string, uint, error) {
+	numbers, err := snapshotNumbers(snapshotsDir)
+	if err != nil {
+		return "", 0, err
+	}
+	number := uint(1)
+	if len(numbers) > 0 {
+		number = numbers[len(numbers)-1] + 1
+	}
+
+	for {
+		dir := filepath.Join(snapshotsDir, strconv.FormatUint(uint64(number), 10))
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			return dir, number, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", 0, err
+		}
+		number++
+	}
+}
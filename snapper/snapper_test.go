@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	btrfsutil "github.com/sapphic-kitten/libbtrfsutil-go"
+	"github.com/sapphic-kitten/libbtrfsutil-go/internal/btrfstest"
+)
+
+func TestAllocateNumber(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1", "3", "007", "info", "0"} {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+	}
+	os.WriteFile(filepath.Join(dir, "9"), nil, 0644)
+
+	numbers, err := snapshotNumbers(dir)
+	if err != nil || len(numbers) != 2 || numbers[0] != 1 || numbers[1] != 3 {
+		t.Errorf("snapshotNumbers() = %v, %v, want [1 3]", numbers, err)
+	}
+	for _, want := range []uint{4, 5} {
+		path, number, err := allocateNumber(dir)
+		if err != nil || number != want || path != filepath.Join(dir, strconv.Itoa(int(want))) {
+			t.Errorf("allocateNumber() = %v, %v, %v, want %v", path, number, err, want)
+		}
+	}
+}
+
+func TestCreateSnapperSnapshot(t *testing.T) {
+	mountpoint := btrfstest.Mount(t)
+
+	config, err := ParseConfig(strings.NewReader("SUBVOLUME=\"" + mountpoint + "\"\nFSTYPE=\"btrfs\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateSnapperSnapshot(config, TypeSingle, "no .snapshots"); err == nil {
+		t.Errorf("CreateSnapperSnapshot() without .snapshots succeeded")
+	}
+	if err := btrfsutil.CreateSubvolume(config.SnapshotsDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	pre, err := CreateSnapperSnapshot(config, TypePre, "before")
+	if err != nil || pre.Number != 1 {
+		t.Fatalf("CreateSnapperSnapshot() = %+v, %v", pre, err)
+	}
+	if _, err := CreateSnapperSnapshot(config, TypePost, "after"); !errors.Is(err, ErrPreSnapshotNotFound) {
+		t.Errorf("CreateSnapperSnapshot() of post without pre error = %v, want %v", err, ErrPreSnapshotNotFound)
+	}
+	post, err := CreateSnapperSnapshotWithInfo(config, &Info{Type: TypePost, PreNumber: 1, Cleanup: "number"})
+	if err != nil || post.Number != 2 {
+		t.Fatalf("CreateSnapperSnapshotWithInfo() = %+v, %v", post, err)
+	}
+	if ro, err := btrfsutil.GetSubvolumeReadOnly(post.Path); err != nil || !ro {
+		t.Errorf("GetSubvolumeReadOnly() = %v, %v, want read-only", ro, err)
+	}
+
+	snapshots, err := ListSnapshots(config)
+	if err != nil || len(snapshots) != 2 {
+		t.Fatalf("ListSnapshots() = %v, %v", snapshots, err)
+	}
+	if snapshots[0].Description != "before" || snapshots[1].PreNumber != 1 || snapshots[1].Cleanup != "number" {
+		t.Errorf("ListSnapshots() = %+v, %+v", snapshots[0], snapshots[1])
+	}
+}
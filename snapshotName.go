@@ -0,0 +1,336 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapshotNamer is a naming scheme for snapshots.
+type SnapshotNamer interface {
+	// Name returns the name of a snapshot taken at t.
+	// existing are the names of the snapshots already present, which sequential schemes need.
+	Name(t time.Time, existing []string) string
+	// Parse returns the time encoded in a snapshot name.
+	// It returns ErrInvalidSnapshotName if the name does not follow the scheme or contains no time.
+	Parse(name string) (time.Time, error)
+}
+
+// ISO8601Namer names snapshots by their creation time in UTC, e.g. "home-2022-06-15T12:30:00Z".
+type ISO8601Namer struct {
+	Prefix string
+}
+
+const iso8601Basic = "20060102T150405Z0700"
+
+func (n ISO8601Namer) Name(t time.Time, existing []string) string {
+	return n.Prefix + t.UTC().Format(time.RFC3339)
+}
+
+// Parse accepts the extended format with any offset as well as the basic format, e.g. "20220615T123000Z".
+func (n ISO8601Namer) Parse(name string) (time.Time, error) {
+	if !strings.HasPrefix(name, n.Prefix) {
+		return time.Time{}, ErrInvalidSnapshotName
+	}
+	name = name[len(n.Prefix):]
+
+	if t, err := time.Parse(time.RFC3339, name); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(iso8601Basic, name); err == nil {
+		return t, nil
+	}
+	return time.Time{}, ErrInvalidSnapshotName
+}
+
+// SnapperNamer names snapshots with increasing numbers like snapper.
+// The names contain no time, so Parse always fails.
+type SnapperNamer struct{}
+
+func (SnapperNamer) Name(t time.Time, existing []string) string {
+	next := 1
+	for _, name := range existing {
+		if n, err := strconv.Atoi(name); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+func (SnapperNamer) Parse(name string) (time.Time, error) {
+	return time.Time{}, ErrInvalidSnapshotName
+}
+
+// ShadowCopyNamer names snapshots like the Samba module vfs_shadow_copy2 expects by default,
+// e.g. "@GMT-2022.06.15-12.30.00", so that Windows clients can browse them as previous versions.
+type ShadowCopyNamer struct {
+	// Location is the time zone of the names, which must match the shadow:localtime setting of Samba.
+	// Defaults to UTC.
+	Location *time.Location
+}
+
+const shadowCopyLayout = "@GMT-2006.01.02-15.04.05"
+
+func (n ShadowCopyNamer) location() *time.Location {
+	if n.Location == nil {
+		return time.UTC
+	}
+	return n.Location
+}
+
+func (n ShadowCopyNamer) Name(t time.Time, existing []string) string {
+	return t.In(n.location()).Format(shadowCopyLayout)
+}
+
+func (n ShadowCopyNamer) Parse(name string) (time.Time, error) {
+	t, err := time.ParseInLocation(shadowCopyLayout, name, n.location())
+	if err != nil {
+		return time.Time{}, ErrInvalidSnapshotName
+	}
+	return t, nil
+}
+
+// TemplateNamer names snapshots after a template with the following tokens:
+//
+//	%Y  year with four digits
+//	%m  month, 01 to 12
+//	%d  day of the month, 01 to 31
+//	%H  hour, 00 to 23
+//	%M  minute, 00 to 59
+//	%S  second, 00 to 59
+//	%s  seconds since the Unix epoch
+//	%n  sequence number, one more than the highest in the existing names
+//	%%  a literal %
+//
+// For example "home-%Y%m%d-%n". Other characters are used literally.
+type TemplateNamer struct {
+	Template string
+	// Location is the time zone of the names. Defaults to the local time zone.
+	Location *time.Location
+}
+
+func (n TemplateNamer) location() *time.Location {
+	if n.Location == nil {
+		return time.Local
+	}
+	return n.Location
+}
+
+// templatePart is a literal or, if verb is set, a token of a TemplateNamer template.
+type templatePart struct {
+	verb    byte
+	literal string
+}
+
+const templateVerbs = "YmdHMSsn"
+
+func (n TemplateNamer) parts() []templatePart {
+	var parts []templatePart
+	var literal strings.Builder
+	for i := 0; i < len(n.Template); i++ {
+		if n.Template[i] == '%' && i+1 < len(n.Template) {
+			i++
+			if strings.IndexByte(templateVerbs, n.Template[i]) >= 0 {
+				if literal.Len() > 0 {
+					parts = append(parts, templatePart{literal: literal.String()})
+					literal.Reset()
+				}
+				parts = append(parts, templatePart{verb: n.Template[i]})
+				continue
+			}
+			if n.Template[i] != '%' {
+				literal.WriteByte('%')
+			}
+		}
+		literal.WriteByte(n.Template[i])
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, templatePart{literal: literal.String()})
+	}
+	return parts
+}
+
+// regexp returns a regular expression matching the names of the template
+// with one group for each token.
+func (n TemplateNamer) regexp() *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, part := range n.parts() {
+		switch part.verb {
+		case 0:
+			expr.WriteString(regexp.QuoteMeta(part.literal))
+		case 'Y':
+			expr.WriteString(`(\d{4})`)
+		case 's', 'n':
+			expr.WriteString(`(\d+)`)
+		default:
+			expr.WriteString(`(\d{2})`)
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func (n TemplateNamer) Name(t time.Time, existing []string) string {
+	t = t.In(n.location())
+
+	var name strings.Builder
+	for _, part := range n.parts() {
+		switch part.verb {
+		case 0:
+			name.WriteString(part.literal)
+		case 'Y':
+			name.WriteString(t.Format("2006"))
+		case 'm':
+			name.WriteString(t.Format("01"))
+		case 'd':
+			name.WriteString(t.Format("02"))
+		case 'H':
+			name.WriteString(t.Format("15"))
+		case 'M':
+			name.WriteString(t.Format("04"))
+		case 'S':
+			name.WriteString(t.Format("05"))
+		case 's':
+			name.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'n':
+			name.WriteString(strconv.Itoa(n.nextSequence(existing)))
+		}
+	}
+	return name.String()
+}
+
+// values returns the values of the tokens in name by verb.
+func (n TemplateNamer) values(name string) (map[byte]int64, bool) {
+	match := n.regexp().FindStringSubmatch(name)
+	if match == nil {
+		return nil, false
+	}
+
+	values := make(map[byte]int64)
+	i := 1
+	for _, part := range n.parts() {
+		if part.verb == 0 {
+			continue
+		}
+		value, err := strconv.ParseInt(match[i], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		values[part.verb] = value
+		i++
+	}
+	return values, true
+}
+
+func (n TemplateNamer) nextSequence(existing []string) int {
+	next := 1
+	for _, name := range existing {
+		if values, ok := n.values(name); ok {
+			if seq, ok := values['n']; ok && int(seq) >= next {
+				next = int(seq) + 1
+			}
+		}
+	}
+	return next
+}
+
+// Parse requires the template to contain at least the year or %s.
+func (n TemplateNamer) Parse(name string) (time.Time, error) {
+	values, ok := n.values(name)
+	if !ok {
+		return time.Time{}, ErrInvalidSnapshotName
+	}
+	if unix, ok := values['s']; ok {
+		return time.Unix(unix, 0).In(n.location()), nil
+	}
+	if _, ok := values['Y']; !ok {
+		return time.Time{}, ErrInvalidSnapshotName
+	}
+
+	for verb, value := range map[byte]int64{'m': 1, 'd': 1} {
+		if _, ok := values[verb]; !ok {
+			values[verb] = value
+		}
+	}
+	t := time.Date(int(values['Y']), time.Month(values['m']), int(values['d']),
+		int(values['H']), int(values['M']), int(values['S']), 0, n.location())
+	// Reject values which time.Date would normalize, such as the 31st of June.
+	if int64(t.Month()) != values['m'] || int64(t.Day()) != values['d'] ||
+		int64(t.Hour()) != values['H'] || int64(t.Minute()) != values['M'] || int64(t.Second()) != values['S'] {
+		return time.Time{}, ErrInvalidSnapshotName
+	}
+	return t, nil
+}
+
+// defaultSnapshotNamers are the schemes tried by ParseSnapshotTime if none are given.
+var defaultSnapshotNamers = []SnapshotNamer{ISO8601Namer{}, ShadowCopyNamer{}}
+
+// ParseSnapshotTime returns the time encoded in a snapshot name, trying each of the given schemes in turn.
+// Without schemes, ISO-8601 and Samba shadow copy names are recognized.
+func ParseSnapshotTime(name string, namers ...SnapshotNamer) (time.Time, error) {
+	if len(namers) == 0 {
+		namers = defaultSnapshotNamers
+	}
+	for _, namer := range namers {
+		if t, err := namer.Parse(name); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidSnapshotName
+}
+
+// FindSnapshotAt returns the newest snapshot in dir which is not newer than t.
+// The time of a snapshot is taken from its name, see ParseSnapshotTime, or from its Otime if the name does not parse.
+// It returns ErrSubvolumeNotFound if there is no such snapshot.
+func FindSnapshotAt(dir string, t time.Time, namers ...SnapshotNamer) (*SubvolumeInfoIteratorResult, error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := findSnapshotAt(snapshots, t, namers)
+	if snapshot == nil {
+		return nil, ErrSubvolumeNotFound
+	}
+	return &SubvolumeInfoIteratorResult{snapshot.Path, snapshot.Info}, nil
+}
+
+func findSnapshotAt(snapshots []*RetentionSnapshot, t time.Time, namers []SnapshotNamer) *RetentionSnapshot {
+	var found *RetentionSnapshot
+	var foundTime time.Time
+	for _, snapshot := range snapshots {
+		taken, err := ParseSnapshotTime(filepath.Base(snapshot.Path), namers...)
+		if err != nil {
+			taken = snapshot.Info.Otime
+		}
+		if taken.After(t) {
+			continue
+		}
+		if found == nil || taken.After(foundTime) {
+			found, foundTime = snapshot, taken
+		}
+	}
+	return found
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotNamer(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	taken := time.Date(2022, 6, 15, 12, 30, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		namer    SnapshotNamer
+		existing []string
+		want     string
+		wantErr  bool
+	}{
+		{"iso8601", ISO8601Namer{}, nil, "2022-06-15T12:30:05Z", false},
+		{"iso8601 prefix", ISO8601Namer{Prefix: "home-"}, nil, "home-2022-06-15T12:30:05Z", false},
+		{"snapper", SnapperNamer{}, []string{"1", "7", "3", "other"}, "8", true},
+		{"snapper first", SnapperNamer{}, nil, "1", true},
+		{"shadow copy", ShadowCopyNamer{}, nil, "@GMT-2022.06.15-12.30.05", false},
+		{"shadow copy localtime", ShadowCopyNamer{Location: berlin}, nil, "@GMT-2022.06.15-14.30.05", false},
+		{"template", TemplateNamer{Template: "home_%Y%m%d-%H%M%S", Location: time.UTC}, nil, "home_20220615-123005", false},
+		{"template unix", TemplateNamer{Template: "snap.%s"}, nil, "snap.1655296205", false},
+		{"template sequence", TemplateNamer{Template: "%Y%m%d%H%M%S.%n", Location: time.UTC}, []string{"20220614000000.1", "20220614000000.2", "other.9"}, "20220615123005.3", false},
+		{"template literal percent", TemplateNamer{Template: "100%%-%Y%m%d%H%M%S", Location: time.UTC}, nil, "100%-20220615123005", false},
+		{"template without time", TemplateNamer{Template: "snap-%n"}, nil, "snap-1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := tt.namer.Name(taken, tt.existing)
+			if name != tt.want {
+				t.Errorf("Name() = %v, want %v", name, tt.want)
+			}
+
+			got, err := tt.namer.Parse(name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !got.Equal(taken) {
+				t.Errorf("Parse() = %v, want %v", got, taken)
+			}
+		})
+	}
+}
+
+func TestParseSnapshotTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		namers  []SnapshotNamer
+		want    time.Time
+		wantErr bool
+	}{
+		{"2022-06-15T12:30:05Z", nil, time.Date(2022, 6, 15, 12, 30, 5, 0, time.UTC), false},
+		{"2022-06-15T14:30:05+02:00", nil, time.Date(2022, 6, 15, 12, 30, 5, 0, time.UTC), false},
+		{"20220615T123005Z", nil, time.Date(2022, 6, 15, 12, 30, 5, 0, time.UTC), false},
+		{"@GMT-2022.06.15-12.30.05", nil, time.Date(2022, 6, 15, 12, 30, 5, 0, time.UTC), false},
+		{"@GMT-2022.06.31-12.30.05", nil, time.Time{}, true},
+		{"42", nil, time.Time{}, true},
+		{"daily-2022.06.15", []SnapshotNamer{TemplateNamer{Template: "daily-%Y.%m.%d", Location: time.UTC}}, time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC), false},
+		{"daily-2022.06.31", []SnapshotNamer{TemplateNamer{Template: "daily-%Y.%m.%d", Location: time.UTC}}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSnapshotTime(tt.name, tt.namers...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSnapshotTime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseSnapshotTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSnapshotAt(t *testing.T) {
+	base := time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)
+	snapshot := func(name string, otime time.Time) *RetentionSnapshot {
+		return &RetentionSnapshot{Path: filepath.Join("/snapshots", name), Info: &SubvolumeInfo{Otime: otime}}
+	}
+	// The names take precedence over Otime, which is later for copies of received snapshots.
+	snapshots := []*RetentionSnapshot{
+		snapshot("@GMT-2022.06.15-10.00.00", base.Add(48*time.Hour)),
+		snapshot("2022-06-15T08:00:00Z", base.Add(48*time.Hour)),
+		snapshot("7", base.Add(9*time.Hour)),
+		snapshot("@GMT-2022.06.15-12.00.00", base.Add(48*time.Hour)),
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"before all", base, ""},
+		{"exact", base.Add(8 * time.Hour), "2022-06-15T08:00:00Z"},
+		{"otime", base.Add(9*time.Hour + 30*time.Minute), "7"},
+		{"between", base.Add(11 * time.Hour), "@GMT-2022.06.15-10.00.00"},
+		{"after all", base.Add(72 * time.Hour), "@GMT-2022.06.15-12.00.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findSnapshotAt(snapshots, tt.at, nil)
+			if got == nil {
+				if tt.want != "" {
+					t.Errorf("findSnapshotAt() = nil, want %v", tt.want)
+				}
+				return
+			}
+			if filepath.Base(got.Path) != tt.want {
+				t.Errorf("findSnapshotAt() = %v, want %v", filepath.Base(got.Path), tt.want)
+			}
+		})
+	}
+}
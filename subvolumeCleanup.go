@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// BTRFS_IOC_SUBVOL_SYNC_WAIT was added in Linux 6.13 and is missing from older kernel headers.
+const (
+	ioctlSubvolSyncWait = 0x40109441
+
+	subvolSyncWaitForOne = 0
+)
+
+type subvolSyncWaitArgs struct {
+	subvolid uint64
+	mode     uint32
+	count    uint32
+}
+
+const (
+	cleanupPollMin = 100 * time.Millisecond
+	cleanupPollMax = 5 * time.Second
+)
+
+// WaitForSubvolumeCleanup waits until the deleted subvolumes with the given IDs have been cleaned up
+// and their space has been released. If ids is empty, it waits for all subvolumes which are deleted
+// at the time of the call. IDs which are not in the list of deleted subvolumes are considered cleaned up.
+// progress is called with the IDs which have not been cleaned up yet whenever the list changes and may be nil.
+// On Linux 6.13 or newer BTRFS_IOC_SUBVOL_SYNC_WAIT is used, otherwise DeletedSubvolumes is polled.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func WaitForSubvolumeCleanup(ctx context.Context, path string, ids []uint64, progress func(remaining []uint64)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer file.Close()
+
+	deleted, err := DeletedSubvolumesFd(file.Fd())
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		ids = deleted
+	}
+	remaining := remainingIds(ids, deleted)
+
+	report := func() {
+		if progress != nil {
+			progress(append([]uint64(nil), remaining...))
+		}
+	}
+	report()
+
+	for len(remaining) > 0 {
+		err := subvolSyncWait(ctx, path, remaining[0])
+		if err == syscall.ENOTTY {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		remaining = remaining[1:]
+		report()
+	}
+
+	// Fall back to polling on kernels without BTRFS_IOC_SUBVOL_SYNC_WAIT.
+	interval := cleanupPollMin
+	for len(remaining) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > cleanupPollMax {
+			interval = cleanupPollMax
+		}
+
+		deleted, err := DeletedSubvolumesFd(file.Fd())
+		if err != nil {
+			return err
+		}
+		if next := remainingIds(remaining, deleted); len(next) != len(remaining) {
+			remaining = next
+			report()
+		}
+	}
+	return nil
+}
+
+// remainingIds returns the IDs in ids which are contained in deleted.
+func remainingIds(ids []uint64, deleted []uint64) []uint64 {
+	pending := make(map[uint64]bool, len(deleted))
+	for _, id := range deleted {
+		pending[id] = true
+	}
+
+	var remaining []uint64
+	for _, id := range ids {
+		if pending[id] {
+			remaining = append(remaining, id)
+			// Report duplicates only once.
+			pending[id] = false
+		}
+	}
+	return remaining
+}
+
+// subvolSyncWait waits for the cleanup of one subvolume with BTRFS_IOC_SUBVOL_SYNC_WAIT.
+// It returns syscall.ENOTTY if the kernel does not support it.
+// The ioctl cannot be interrupted, so if ctx is done it keeps waiting in the background.
+func subvolSyncWait(ctx context.Context, path string, id uint64) error {
+	done := make(chan error, 1)
+	go func() {
+		file, err := os.Open(path)
+		if err != nil {
+			done <- ErrOpenFailed
+			return
+		}
+		defer file.Close()
+
+		args := subvolSyncWaitArgs{subvolid: id, mode: subvolSyncWaitForOne}
+		for {
+			err = ioctl(file.Fd(), ioctlSubvolSyncWait, unsafe.Pointer(&args))
+			if err != syscall.EINTR {
+				break
+			}
+		}
+		done <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		switch err {
+		case nil, syscall.ENOENT:
+			// ENOENT means the subvolume has already been cleaned up.
+			return nil
+		case syscall.ENOTTY, ErrOpenFailed:
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrSubvolSyncWaitFailed, err)
+	}
+}
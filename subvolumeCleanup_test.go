@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRemainingIds(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []uint64
+		deleted []uint64
+		want    []uint64
+	}{
+		{"all pending", []uint64{256, 257}, []uint64{257, 256}, []uint64{256, 257}},
+		{"some cleaned", []uint64{256, 257, 258}, []uint64{258, 300}, []uint64{258}},
+		{"all cleaned", []uint64{256}, nil, nil},
+		{"duplicates", []uint64{256, 256}, []uint64{256}, []uint64{256}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remainingIds(tt.ids, tt.deleted); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remainingIds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForSubvolumeCleanup(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	var ids []uint64
+	for _, name := range []string{"subvol1", "subvol2"} {
+		subvol := filepath.Join(mountpoint.path, name)
+		if CreateSubvolume(subvol) != nil {
+			t.Error("Failed to create subvolumes")
+		}
+		id, err := SubvolumeId(subvol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		if DeleteSubvolume(subvol, false) != nil {
+			t.Error("Failed to delete subvolumes")
+		}
+	}
+
+	var reports [][]uint64
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err = WaitForSubvolumeCleanup(ctx, mountpoint.path, ids, func(remaining []uint64) {
+		reports = append(reports, remaining)
+	})
+	if err != nil {
+		t.Fatalf("WaitForSubvolumeCleanup() error = %v", err)
+	}
+	if len(reports) == 0 || len(reports[len(reports)-1]) != 0 {
+		t.Errorf("WaitForSubvolumeCleanup() progress = %v, want final empty report", reports)
+	}
+
+	deleted, err := DeletedSubvolumes(mountpoint.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remainingIds(ids, deleted)) != 0 {
+		t.Errorf("WaitForSubvolumeCleanup() returned with deleted subvolumes %v", deleted)
+	}
+}
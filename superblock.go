@@ -0,0 +1,314 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// SuperblockSize is the size of a superblock on disk.
+	SuperblockSize = 4096
+	// SuperblockMirrors is the maximum number of copies of the superblock on a device.
+	SuperblockMirrors = 3
+
+	superblockMagic        = "_BHRfS_M"
+	superblockSysChunkSize = 2048
+	superblockBackupRoots  = 4
+	// BTRFS_FEATURE_INCOMPAT_METADATA_UUID
+	incompatMetadataUUID = 1 << 10
+)
+
+// SuperblockOffset returns the byte offset of a copy of the superblock on a device:
+// 64 KiB for the primary copy, 64 MiB and 256 GiB for the mirrors.
+func SuperblockOffset(mirror int) int64 {
+	if mirror == 0 {
+		return 64 << 10
+	}
+	return 16 << 10 << (12 * mirror)
+}
+
+// Superblock is the superblock of a Btrfs device, see struct btrfs_super_block.
+type Superblock struct {
+	Checksum [ChecksumSize]byte
+	Fsid     UUID
+	// Bytenr is the offset of this copy of the superblock.
+	Bytenr     uint64
+	Flags      uint64
+	Generation uint64
+	// Root, ChunkRoot and LogRoot are the logical addresses of the root nodes of the
+	// root tree, the chunk tree and the log tree, which is 0 if there is none.
+	Root                uint64
+	ChunkRoot           uint64
+	LogRoot             uint64
+	TotalBytes          uint64
+	BytesUsed           uint64
+	RootDirObjectid     uint64
+	NumDevices          uint64
+	Sectorsize          uint32
+	Nodesize            uint32
+	Stripesize          uint32
+	ChunkRootGeneration uint64
+	CompatFlags         uint64
+	CompatRoFlags       uint64
+	IncompatFlags       uint64
+	ChecksumType        ChecksumType
+	RootLevel           uint8
+	ChunkRootLevel      uint8
+	LogRootLevel        uint8
+	Device              DeviceItem
+	Label               string
+	CacheGeneration     uint64
+	UUIDTreeGeneration  uint64
+	// MetadataUUID is the UUID stamped into metadata blocks. It equals Fsid unless the
+	// filesystem UUID was changed without rewriting the metadata.
+	MetadataUUID UUID
+	// SysChunkArray holds the keys and chunk items needed to read the chunk tree.
+	SysChunkArray []byte
+	BackupRoots   [superblockBackupRoots]BackupRoot
+}
+
+// DeviceItem describes a device of a filesystem, see struct btrfs_dev_item.
+type DeviceItem struct {
+	Devid       uint64
+	TotalBytes  uint64
+	BytesUsed   uint64
+	IoAlign     uint32
+	IoWidth     uint32
+	SectorSize  uint32
+	Type        uint64
+	Generation  uint64
+	StartOffset uint64
+	DevGroup    uint32
+	SeekSpeed   uint8
+	Bandwidth   uint8
+	UUID        UUID
+	Fsid        UUID
+}
+
+// BackupRoot records the tree roots of a recent transaction, see struct btrfs_root_backup.
+type BackupRoot struct {
+	TreeRoot        uint64
+	TreeRootGen     uint64
+	ChunkRoot       uint64
+	ChunkRootGen    uint64
+	ExtentRoot      uint64
+	ExtentRootGen   uint64
+	FsRoot          uint64
+	FsRootGen       uint64
+	DevRoot         uint64
+	DevRootGen      uint64
+	CsumRoot        uint64
+	CsumRootGen     uint64
+	TotalBytes      uint64
+	BytesUsed       uint64
+	NumDevices      uint64
+	TreeRootLevel   uint8
+	ChunkRootLevel  uint8
+	ExtentRootLevel uint8
+	FsRootLevel     uint8
+	DevRootLevel    uint8
+	CsumRootLevel   uint8
+}
+
+// ReadSuperblock reads and verifies a copy of the superblock of a Btrfs device or image, see SuperblockOffset.
+// The filesystem need not be mounted. It returns ErrInvalidSuperblock if there is no valid superblock at the offset
+// and ErrSuperblockChecksum if it is corrupted. Copies beyond the end of r return an error wrapping io.EOF.
+func ReadSuperblock(r io.ReaderAt, mirror int) (*Superblock, error) {
+	if mirror < 0 || mirror >= SuperblockMirrors {
+		return nil, ErrInvalidArgument
+	}
+	offset := SuperblockOffset(mirror)
+	data := make([]byte, SuperblockSize)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSuperblock, err)
+	}
+
+	super, err := parseSuperblock(data)
+	if err != nil {
+		return nil, err
+	}
+	if super.Bytenr != uint64(offset) {
+		return nil, fmt.Errorf("%w: bytenr %d at offset %d", ErrInvalidSuperblock, super.Bytenr, offset)
+	}
+	return super, nil
+}
+
+// parseSuperblock decodes and verifies a struct btrfs_super_block.
+func parseSuperblock(data []byte) (*Superblock, error) {
+	if string(data[64:72]) != superblockMagic {
+		return nil, ErrInvalidSuperblock
+	}
+
+	le := binary.LittleEndian
+	super := &Superblock{
+		Bytenr:              le.Uint64(data[48:]),
+		Flags:               le.Uint64(data[56:]),
+		Generation:          le.Uint64(data[72:]),
+		Root:                le.Uint64(data[80:]),
+		ChunkRoot:           le.Uint64(data[88:]),
+		LogRoot:             le.Uint64(data[96:]),
+		TotalBytes:          le.Uint64(data[112:]),
+		BytesUsed:           le.Uint64(data[120:]),
+		RootDirObjectid:     le.Uint64(data[128:]),
+		NumDevices:          le.Uint64(data[136:]),
+		Sectorsize:          le.Uint32(data[144:]),
+		Nodesize:            le.Uint32(data[148:]),
+		Stripesize:          le.Uint32(data[156:]),
+		ChunkRootGeneration: le.Uint64(data[164:]),
+		CompatFlags:         le.Uint64(data[172:]),
+		CompatRoFlags:       le.Uint64(data[180:]),
+		IncompatFlags:       le.Uint64(data[188:]),
+		ChecksumType:        ChecksumType(le.Uint16(data[196:])),
+		RootLevel:           data[198],
+		ChunkRootLevel:      data[199],
+		LogRootLevel:        data[200],
+		Device:              parseDeviceItem(data[201:299]),
+		CacheGeneration:     le.Uint64(data[555:]),
+		UUIDTreeGeneration:  le.Uint64(data[563:]),
+	}
+	copy(super.Checksum[:], data[0:32])
+	copy(super.Fsid[:], data[32:48])
+	copy(super.MetadataUUID[:], data[571:587])
+	if super.IncompatFlags&incompatMetadataUUID == 0 {
+		super.MetadataUUID = super.Fsid
+	}
+	label := data[299:555]
+	if i := bytes.IndexByte(label, 0); i >= 0 {
+		label = label[:i]
+	}
+	super.Label = string(label)
+
+	sum, err := super.ChecksumType.Sum(data[ChecksumSize:])
+	if err != nil {
+		return nil, err
+	}
+	if sum != super.Checksum {
+		return nil, ErrSuperblockChecksum
+	}
+
+	if !validBlockSize(super.Sectorsize, 4096) || !validBlockSize(super.Nodesize, super.Sectorsize) {
+		return nil, fmt.Errorf("%w: sectorsize %d, nodesize %d", ErrInvalidSuperblock, super.Sectorsize, super.Nodesize)
+	}
+	size := le.Uint32(data[160:])
+	if size > superblockSysChunkSize {
+		return nil, fmt.Errorf("%w: sys_chunk_array_size %d", ErrInvalidSuperblock, size)
+	}
+	super.SysChunkArray = bytes.Clone(data[811 : 811+size])
+
+	for i := range super.BackupRoots {
+		super.BackupRoots[i] = parseBackupRoot(data[2859+168*i:])
+	}
+	return super, nil
+}
+
+// validBlockSize returns whether size is a power of two between min and 64 KiB.
+func validBlockSize(size, min uint32) bool {
+	return size >= min && size <= 64<<10 && size&(size-1) == 0
+}
+
+// parseDeviceItem decodes a struct btrfs_dev_item.
+func parseDeviceItem(data []byte) DeviceItem {
+	le := binary.LittleEndian
+	item := DeviceItem{
+		Devid:       le.Uint64(data[0:]),
+		TotalBytes:  le.Uint64(data[8:]),
+		BytesUsed:   le.Uint64(data[16:]),
+		IoAlign:     le.Uint32(data[24:]),
+		IoWidth:     le.Uint32(data[28:]),
+		SectorSize:  le.Uint32(data[32:]),
+		Type:        le.Uint64(data[36:]),
+		Generation:  le.Uint64(data[44:]),
+		StartOffset: le.Uint64(data[52:]),
+		DevGroup:    le.Uint32(data[60:]),
+		SeekSpeed:   data[64],
+		Bandwidth:   data[65],
+	}
+	copy(item.UUID[:], data[66:82])
+	copy(item.Fsid[:], data[82:98])
+	return item
+}
+
+// parseBackupRoot decodes a struct btrfs_root_backup.
+func parseBackupRoot(data []byte) BackupRoot {
+	le := binary.LittleEndian
+	return BackupRoot{
+		TreeRoot:        le.Uint64(data[0:]),
+		TreeRootGen:     le.Uint64(data[8:]),
+		ChunkRoot:       le.Uint64(data[16:]),
+		ChunkRootGen:    le.Uint64(data[24:]),
+		ExtentRoot:      le.Uint64(data[32:]),
+		ExtentRootGen:   le.Uint64(data[40:]),
+		FsRoot:          le.Uint64(data[48:]),
+		FsRootGen:       le.Uint64(data[56:]),
+		DevRoot:         le.Uint64(data[64:]),
+		DevRootGen:      le.Uint64(data[72:]),
+		CsumRoot:        le.Uint64(data[80:]),
+		CsumRootGen:     le.Uint64(data[88:]),
+		TotalBytes:      le.Uint64(data[96:]),
+		BytesUsed:       le.Uint64(data[104:]),
+		NumDevices:      le.Uint64(data[112:]),
+		TreeRootLevel:   data[152],
+		ChunkRootLevel:  data[153],
+		ExtentRootLevel: data[154],
+		FsRootLevel:     data[155],
+		DevRootLevel:    data[156],
+		CsumRootLevel:   data[157],
+	}
+}
+
+// ReadSuperblockMirrors reads all copies of the superblock within r, indexed by mirror.
+// Copies which fail to verify are nil and their errors are joined in the returned error.
+// It returns ErrSuperblockMismatch if the valid copies belong to different filesystems or transactions.
+func ReadSuperblockMirrors(r io.ReaderAt) ([]*Superblock, error) {
+	var supers []*Superblock
+	var errs []error
+	for mirror := 0; mirror < SuperblockMirrors; mirror++ {
+		super, err := ReadSuperblock(r, mirror)
+		if errors.Is(err, io.EOF) && mirror > 0 {
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mirror %d: %w", mirror, err))
+		}
+		supers = append(supers, super)
+	}
+
+	var first *Superblock
+	for mirror, super := range supers {
+		if super == nil {
+			continue
+		}
+		if first == nil {
+			first = super
+		} else if super.Fsid != first.Fsid || super.Generation != first.Generation {
+			errs = append(errs, fmt.Errorf("%w: mirror %d has generation %d of %v, want %d of %v",
+				ErrSuperblockMismatch, mirror, super.Generation, super.Fsid, first.Generation, first.Fsid))
+		}
+	}
+	return supers, errors.Join(errs...)
+}
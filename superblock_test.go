@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// sparseImage is an image of the given size which is zero except for the blocks written to it.
+type sparseImage struct {
+	size   int64
+	blocks map[int64][]byte
+}
+
+func (s *sparseImage) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > s.size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	clear(p)
+	for start, block := range s.blocks {
+		if start < off+int64(len(p)) && start+int64(len(block)) > off {
+			if start >= off {
+				copy(p[start-off:], block)
+			} else {
+				copy(p, block[off-start:])
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// testSuperblock returns a superblock for the given mirror.
+func testSuperblock(t *testing.T, mirror int, generation uint64, csum ChecksumType) []byte {
+	data := make([]byte, SuperblockSize)
+	le := binary.LittleEndian
+	copy(data[32:], []byte{1, 2, 3})
+	le.PutUint64(data[48:], uint64(SuperblockOffset(mirror)))
+	copy(data[64:], superblockMagic)
+	le.PutUint64(data[72:], generation)
+	le.PutUint64(data[80:], 30408704)
+	le.PutUint32(data[144:], 4096)
+	le.PutUint32(data[148:], 16384)
+	le.PutUint32(data[160:], 97)
+	le.PutUint16(data[196:], uint16(csum))
+	le.PutUint64(data[201:], 1)
+	copy(data[299:], "label")
+	le.PutUint64(data[2859+168:], 30408704)
+
+	sum, err := csum.Sum(data[ChecksumSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data, sum[:])
+	return data
+}
+
+func TestReadSuperblock(t *testing.T) {
+	for _, csum := range []ChecksumType{ChecksumCRC32C, ChecksumXXHash, ChecksumSHA256, ChecksumBlake2b} {
+		t.Run(csum.String(), func(t *testing.T) {
+			image := &sparseImage{size: 1 << 30, blocks: map[int64][]byte{
+				SuperblockOffset(0): testSuperblock(t, 0, 7, csum),
+			}}
+			super, err := ReadSuperblock(image, 0)
+			if err != nil {
+				t.Fatalf("ReadSuperblock() error = %v", err)
+			}
+			if super.Generation != 7 || super.Root != 30408704 || super.Nodesize != 16384 || super.Label != "label" ||
+				super.Fsid != (UUID{1, 2, 3}) || super.MetadataUUID != super.Fsid || super.Device.Devid != 1 ||
+				len(super.SysChunkArray) != 97 || super.BackupRoots[1].TreeRoot != 30408704 || super.ChecksumType != csum {
+				t.Errorf("ReadSuperblock() = %+v", super)
+			}
+		})
+	}
+
+	corrupt := testSuperblock(t, 0, 7, ChecksumCRC32C)
+	corrupt[100] ^= 1
+	misplaced := testSuperblock(t, 1, 7, ChecksumCRC32C)
+	unknown := testSuperblock(t, 0, 7, ChecksumCRC32C)
+	unknown[196] = 9
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"checksum", corrupt, ErrSuperblockChecksum},
+		{"bytenr", misplaced, ErrInvalidSuperblock},
+		{"checksum type", unknown, ErrUnsupportedChecksum},
+		{"magic", make([]byte, SuperblockSize), ErrInvalidSuperblock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := &sparseImage{size: 1 << 20, blocks: map[int64][]byte{SuperblockOffset(0): tt.data}}
+			if _, err := ReadSuperblock(image, 0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadSuperblock() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := ReadSuperblock(&sparseImage{size: 1 << 20}, 1); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadSuperblock() beyond end error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReadSuperblockMirrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		generations []uint64
+		wantCount   int
+		wantErr     error
+	}{
+		{"single", []uint64{5}, 1, nil},
+		{"equal", []uint64{5, 5}, 2, nil},
+		{"stale", []uint64{5, 4}, 2, ErrSuperblockMismatch},
+		{"corrupt", []uint64{5, 0}, 2, ErrSuperblockChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := &sparseImage{size: SuperblockOffset(len(tt.generations)-1) + SuperblockSize, blocks: map[int64][]byte{}}
+			for mirror, generation := range tt.generations {
+				data := testSuperblock(t, mirror, generation, ChecksumCRC32C)
+				if generation == 0 {
+					data[0] ^= 1
+				}
+				image.blocks[SuperblockOffset(mirror)] = data
+			}
+
+			supers, err := ReadSuperblockMirrors(image)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("ReadSuperblockMirrors() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(supers) != tt.wantCount || supers[0] == nil {
+				t.Errorf("ReadSuperblockMirrors() = %v", supers)
+			}
+		})
+	}
+}
+
+func TestReadSuperblockImage(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	if err := Sync(mountpoint.path); err != nil {
+		t.Fatal(err)
+	}
+	supers, err := ReadSuperblockMirrors(mountpoint.image)
+	if err != nil {
+		t.Fatalf("ReadSuperblockMirrors() error = %v", err)
+	}
+	if len(supers) != 2 {
+		t.Errorf("ReadSuperblockMirrors() = %d mirrors, want 2", len(supers))
+	}
+
+	info, err := os.Stat(mountpoint.image.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if super := supers[0]; super.TotalBytes != uint64(info.Size()) || super.NumDevices != 1 || super.Nodesize == 0 {
+		t.Errorf("ReadSuperblockMirrors() = %+v", super)
+	}
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// extentRefs returns the number of references to a data extent recorded in the extent tree.
+func extentRefs(fd uintptr, bytenr uint64) (uint64, error) {
+	key := searchKey{
+		treeId:      C.BTRFS_EXTENT_TREE_OBJECTID,
+		minObjectid: bytenr,
+		maxObjectid: bytenr,
+		minType:     C.BTRFS_EXTENT_ITEM_KEY,
+		maxType:     C.BTRFS_EXTENT_ITEM_KEY,
+		minOffset:   0,
+		maxOffset:   math.MaxUint64,
+	}
+
+	var refs uint64
+	err := treeSearch(fd, key, func(item *searchItem) error {
+		if item.typ == C.BTRFS_EXTENT_ITEM_KEY && len(item.data) >= 8 {
+			refs = binary.LittleEndian.Uint64(item.data)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// Offsets into struct btrfs_chunk and struct btrfs_stripe.
+const (
+	chunkLength       = 0
+	chunkNumStripes   = 44
+	chunkStripes      = 48
+	stripeOffset      = 8
+	chunkHeaderLength = chunkStripes
+)
+
+// logicalToPhysical maps a logical address to the physical offset of its first stripe
+// by searching the chunk tree.
+func logicalToPhysical(fd uintptr, logical uint64) (uint64, error) {
+	key := searchKey{
+		treeId:      C.BTRFS_CHUNK_TREE_OBJECTID,
+		minObjectid: C.BTRFS_FIRST_CHUNK_TREE_OBJECTID,
+		maxObjectid: C.BTRFS_FIRST_CHUNK_TREE_OBJECTID,
+		minType:     C.BTRFS_CHUNK_ITEM_KEY,
+		maxType:     C.BTRFS_CHUNK_ITEM_KEY,
+		minOffset:   0,
+		maxOffset:   logical,
+	}
+
+	var chunk *searchItem
+	err := treeSearch(fd, key, func(item *searchItem) error {
+		if item.typ == C.BTRFS_CHUNK_ITEM_KEY {
+			chunk = item
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if chunk == nil || len(chunk.data) < chunkHeaderLength+stripeOffset+8 {
+		return 0, ErrChunkNotFound
+	}
+
+	length := binary.LittleEndian.Uint64(chunk.data[chunkLength:])
+	if logical >= chunk.offset+length || binary.LittleEndian.Uint16(chunk.data[chunkNumStripes:]) == 0 {
+		return 0, ErrChunkNotFound
+	}
+	return binary.LittleEndian.Uint64(chunk.data[chunkStripes+stripeOffset:]) + logical - chunk.offset, nil
+}
+
+// CreateSwapfile creates a swapfile of the given size, which must be a multiple of the page size.
+// The filesystem must have a single device and its data profile must be single. The file is created NOCOW and
+// uncompressed, fully preallocated and formatted with a swap signature, like mkswap(8).
+// It is refused if the subvolume containing path has snapshots, as those would pin
+// its extents and make the kernel reject the swapfile.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func CreateSwapfile(path string, size uint64) error {
+	pagesize := uint64(os.Getpagesize())
+	if size%pagesize != 0 || size < 10*pagesize {
+		return ErrInvalidArgument
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer dir.Close()
+
+	if err := checkSingleDevice(dir.Fd()); err != nil {
+		return err
+	}
+	infos, err := GetSpaceInfoFd(dir.Fd())
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Flags&C.BTRFS_BLOCK_GROUP_DATA != 0 && info.Flags&C.BTRFS_BLOCK_GROUP_PROFILE_MASK != 0 {
+			return ErrProfileNotSupported
+		}
+	}
+
+	if err := checkSubvolumeSnapshots(dir.Fd()); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return ErrOpenFailed
+	}
+	defer file.Close()
+
+	if err := provisionSwapfile(file, size, pagesize); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// checkSingleDevice returns ErrMultipleDevices unless the filesystem containing fd has a single device.
+// Physical offsets of swapfiles are only meaningful on such a filesystem.
+func checkSingleDevice(fd uintptr) error {
+	info, err := GetFilesystemInfoFd(fd)
+	if err != nil {
+		return err
+	}
+	if info.NumDevices != 1 {
+		return ErrMultipleDevices
+	}
+	return nil
+}
+
+// checkSubvolumeSnapshots returns ErrSubvolumeHasSnapshots if any subvolume
+// is a snapshot of the subvolume containing fd.
+func checkSubvolumeSnapshots(fd uintptr) error {
+	info, err := GetSubvolumeInfoFd(fd, 0)
+	if err != nil {
+		return err
+	}
+	// Without a UUID of its own (e.g. an old top-level subvolume) snapshots cannot be told apart.
+	if info.UUID.IsZero() {
+		return nil
+	}
+
+	it, err := CreateSubvolumeInfoIteratorFd(fd, C.BTRFS_FS_TREE_OBJECTID, false)
+	if err != nil {
+		return err
+	}
+	defer it.Destroy()
+
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return err
+		}
+		if result.Info.ParentUUID == info.UUID {
+			return ErrSubvolumeHasSnapshots
+		}
+	}
+	return nil
+}
+
+func provisionSwapfile(file *os.File, size uint64, pagesize uint64) error {
+	if err := SetInodeFlagsFd(file.Fd(), InodeNoCOW); err != nil {
+		return err
+	}
+	flags, err := GetInodeFlagsFd(file.Fd())
+	if err != nil {
+		return err
+	}
+	if flags&InodeNoCOW == 0 {
+		return ErrSetFlagsFailed
+	}
+
+	if err := syscall.Fallocate(int(file.Fd()), 0, 0, int64(size)); err != nil {
+		return err
+	}
+	header, err := swapHeader(size, pagesize)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	extents, err := FileExtentsFd(file.Fd())
+	if err != nil {
+		return err
+	}
+	var end uint64
+	for _, extent := range extents {
+		if extent.Offset != end || extent.Inline || extent.DiskBytenr == 0 || extent.Compression != CompressionNone {
+			return ErrNotSwapfileCompatible
+		}
+		refs, err := extentRefs(file.Fd(), extent.DiskBytenr)
+		if err != nil {
+			return err
+		}
+		if refs > 1 {
+			return ErrSharedExtent
+		}
+		end += extent.Length
+	}
+	if end < size {
+		return ErrNotSwapfileCompatible
+	}
+	return nil
+}
+
+// swapHeader returns the first page of a version 1 swap area.
+func swapHeader(size uint64, pagesize uint64) ([]byte, error) {
+	page := make([]byte, pagesize)
+
+	// Layout of union swap_header from include/linux/swap.h following the 1024 boot bytes.
+	binary.LittleEndian.PutUint32(page[1024:], 1)
+	binary.LittleEndian.PutUint32(page[1028:], uint32(size/pagesize-1))
+	binary.LittleEndian.PutUint32(page[1032:], 0)
+	if _, err := rand.Read(page[1036:1052]); err != nil {
+		return nil, err
+	}
+	page[1036+6] = page[1036+6]&0x0f | 0x40
+	page[1036+8] = page[1036+8]&0x3f | 0x80
+
+	copy(page[pagesize-10:], "SWAPSPACE2")
+	return page, nil
+}
+
+// SwapfileOffset returns the physical offset of a swapfile in pages,
+// as expected by the resume_offset kernel parameter for hibernation.
+// The filesystem must have a single device, which the offset refers to.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func SwapfileOffset(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return SwapfileOffsetFd(file.Fd())
+}
+
+// See SwapfileOffset.
+func SwapfileOffsetFd(fd uintptr) (uint64, error) {
+	if err := checkSingleDevice(fd); err != nil {
+		return 0, err
+	}
+	extents, err := FileExtentsFd(fd)
+	if err != nil {
+		return 0, err
+	}
+	if len(extents) == 0 || extents[0].Offset != 0 || extents[0].Inline || extents[0].DiskBytenr == 0 {
+		return 0, ErrNotSwapfileCompatible
+	}
+
+	physical, err := logicalToPhysical(fd, extents[0].DiskBytenr)
+	if err != nil {
+		return 0, err
+	}
+	return physical / uint64(os.Getpagesize()), nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateSwapfile(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol2")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	if CreateSnapshot(filepath.Join(mountpoint.path, "subvol2"), filepath.Join(mountpoint.path, "snap2"), false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+
+	pagesize := uint64(os.Getpagesize())
+
+	type args struct {
+		path string
+		size uint64
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr error
+	}{
+		{"swapfile", args{path: "subvol1/swapfile", size: 16 * 1024 * 1024}, nil},
+		{"unaligned", args{path: "subvol1/unaligned", size: 16*1024*1024 + 1}, ErrInvalidArgument},
+		{"too small", args{path: "subvol1/small", size: pagesize}, ErrInvalidArgument},
+		{"snapshotted", args{path: "subvol2/swapfile", size: 16 * 1024 * 1024}, ErrSubvolumeHasSnapshots},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(mountpoint.path, tt.args.path)
+			if err := CreateSwapfile(path, tt.args.size); err != tt.wantErr {
+				t.Errorf("CreateSwapfile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr != nil {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("CreateSwapfile() left %s behind", path)
+				}
+				return
+			}
+
+			flags, err := GetInodeFlags(path)
+			if err != nil {
+				t.Errorf("GetInodeFlags() error = %v", err)
+			}
+			if flags&InodeNoCOW == 0 {
+				t.Errorf("GetInodeFlags() = %#x, want NoCOW", flags)
+			}
+
+			offset, err := SwapfileOffset(path)
+			if err != nil {
+				t.Errorf("SwapfileOffset() error = %v", err)
+			}
+			if offset == 0 {
+				t.Errorf("SwapfileOffset() = %v, want > 0", offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"path/filepath"
+	"sort"
+)
+
+// TreeNode is a subvolume within a Tree.
+type TreeNode struct {
+	// Path is the path of the subvolume relative to the top-level subvolume.
+	// It is empty for the top-level subvolume itself.
+	Path string
+	Info *SubvolumeInfo
+
+	parent       *TreeNode
+	children     []*TreeNode
+	origin       *TreeNode
+	snapshots    []*TreeNode
+	receivedFrom *TreeNode
+	received     []*TreeNode
+}
+
+// Tree is an in-memory model of all subvolumes of a Btrfs filesystem.
+// Subvolumes are linked by their location in the filesystem (ParentId)
+// and by their lineage (ParentUUID and ReceivedUUID).
+type Tree struct {
+	Root *TreeNode
+
+	byId   map[uint64]*TreeNode
+	byUUID map[UUID]*TreeNode
+	byPath map[string]*TreeNode
+}
+
+// BuildTree loads all subvolumes of the filesystem containing path.
+// The given path may be any path in the Btrfs filesystem.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func BuildTree(path string) (*Tree, error) {
+	info, err := GetSubvolumeInfo(path, C.BTRFS_FS_TREE_OBJECTID)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*TreeNode{{Info: info}}
+
+	it, err := CreateSubvolumeInfoIterator(path, C.BTRFS_FS_TREE_OBJECTID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Destroy()
+
+	for it.HasNext() {
+		result, err := it.GetNext()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &TreeNode{Path: result.Path, Info: result.Info})
+	}
+	return newTree(nodes), nil
+}
+
+// newTree links the given nodes, the first of which must be the top-level subvolume.
+func newTree(nodes []*TreeNode) *Tree {
+	t := &Tree{
+		Root:   nodes[0],
+		byId:   make(map[uint64]*TreeNode),
+		byUUID: make(map[UUID]*TreeNode),
+		byPath: make(map[string]*TreeNode),
+	}
+
+	for _, node := range nodes {
+		t.byId[node.Info.Id] = node
+		t.byPath[node.Path] = node
+		if !node.Info.UUID.IsZero() {
+			t.byUUID[node.Info.UUID] = node
+		}
+	}
+
+	for _, node := range nodes {
+		if parent, ok := t.byId[node.Info.ParentId]; ok && node != t.Root {
+			node.parent = parent
+			parent.children = append(parent.children, node)
+		}
+		if origin, ok := t.byUUID[node.Info.ParentUUID]; ok && !node.Info.ParentUUID.IsZero() {
+			node.origin = origin
+			origin.snapshots = append(origin.snapshots, node)
+		}
+		if source, ok := t.byUUID[node.Info.ReceivedUUID]; ok && !node.Info.ReceivedUUID.IsZero() {
+			node.receivedFrom = source
+			source.received = append(source.received, node)
+		}
+	}
+
+	for _, node := range nodes {
+		sortNodes(node.children)
+		sortNodes(node.snapshots)
+		sortNodes(node.received)
+	}
+	return t
+}
+
+func sortNodes(nodes []*TreeNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Path < nodes[j].Path
+	})
+}
+
+// NodeById returns the subvolume with the given ID, or nil.
+func (t *Tree) NodeById(id uint64) *TreeNode {
+	return t.byId[id]
+}
+
+// NodeByUUID returns the subvolume with the given UUID, or nil.
+func (t *Tree) NodeByUUID(uuid UUID) *TreeNode {
+	return t.byUUID[uuid]
+}
+
+// NodeByPath returns the subvolume at the given path relative to the top-level subvolume, or nil.
+func (t *Tree) NodeByPath(path string) *TreeNode {
+	path = filepath.Clean(path)
+	if path == "." || path == "/" {
+		path = ""
+	}
+	return t.byPath[path]
+}
+
+// Parent returns the subvolume containing n, or nil for the top-level subvolume.
+func (n *TreeNode) Parent() *TreeNode {
+	return n.parent
+}
+
+// Children returns the subvolumes directly nested within n, ordered by path.
+func (n *TreeNode) Children() []*TreeNode {
+	return n.children
+}
+
+// Origin returns the subvolume n is a snapshot of, or nil if n is not a snapshot
+// or its origin no longer exists.
+func (n *TreeNode) Origin() *TreeNode {
+	return n.origin
+}
+
+// Snapshots returns the snapshots taken of n, ordered by path.
+func (n *TreeNode) Snapshots() []*TreeNode {
+	return n.snapshots
+}
+
+// ReceivedFrom returns the subvolume n was received from, if it is part of the same filesystem.
+func (n *TreeNode) ReceivedFrom() *TreeNode {
+	return n.receivedFrom
+}
+
+// Received returns the subvolumes received from n within the same filesystem, ordered by path.
+func (n *TreeNode) Received() []*TreeNode {
+	return n.received
+}
+
+// Descendants returns all subvolumes derived from n, i.e. its snapshots and received copies,
+// their snapshots and received copies and so on, in pre-order.
+func (n *TreeNode) Descendants() []*TreeNode {
+	var descendants []*TreeNode
+	seen := map[*TreeNode]bool{n: true}
+
+	var walk func(node *TreeNode)
+	walk = func(node *TreeNode) {
+		for _, list := range [][]*TreeNode{node.snapshots, node.received} {
+			for _, derived := range list {
+				if seen[derived] {
+					continue
+				}
+				seen[derived] = true
+				descendants = append(descendants, derived)
+				walk(derived)
+			}
+		}
+	}
+	walk(n)
+	return descendants
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs.h>
+import "C"
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// searchKey describes the range of keys visited by treeSearch.
+// The minimum and maximum keys are compared as (objectid, type, offset) tuples.
+// A treeId of zero searches the tree of the subvolume containing the file descriptor.
+type searchKey struct {
+	treeId      uint64
+	minObjectid uint64
+	maxObjectid uint64
+	minType     uint32
+	maxType     uint32
+	minOffset   uint64
+	maxOffset   uint64
+}
+
+// searchItem is a single item returned by treeSearch.
+// data holds the item as stored on disk, i.e. in little-endian byte order.
+type searchItem struct {
+	transid  uint64
+	objectid uint64
+	typ      uint32
+	offset   uint64
+	data     []byte
+}
+
+// treeSearch calls fn for every item in the given key range using BTRFS_IOC_TREE_SEARCH.
+// Iteration stops at the first error returned by fn.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func treeSearch(fd uintptr, key searchKey, fn func(item *searchItem) error) error {
+	var args C.struct_btrfs_ioctl_search_args
+
+	sk := &args.key
+	sk.tree_id = C.__u64(key.treeId)
+	sk.min_objectid = C.__u64(key.minObjectid)
+	sk.max_objectid = C.__u64(key.maxObjectid)
+	sk.min_type = C.__u32(key.minType)
+	sk.max_type = C.__u32(key.maxType)
+	sk.min_offset = C.__u64(key.minOffset)
+	sk.max_offset = C.__u64(key.maxOffset)
+	sk.min_transid = 0
+	sk.max_transid = math.MaxUint64
+
+	buf := (*[C.BTRFS_SEARCH_ARGS_BUFSIZE]byte)(unsafe.Pointer(&args.buf[0]))[:]
+
+	for {
+		sk.nr_items = 4096
+
+		if err := ioctl(fd, C.BTRFS_IOC_TREE_SEARCH, unsafe.Pointer(&args)); err != nil {
+			return fmt.Errorf("%w: %w", ErrSearchFailed, err)
+		}
+		if sk.nr_items == 0 {
+			return nil
+		}
+
+		var item searchItem
+		pos := 0
+		for i := 0; i < int(sk.nr_items); i++ {
+			var header C.struct_btrfs_ioctl_search_header
+			pos += copy((*[C.sizeof_struct_btrfs_ioctl_search_header]byte)(unsafe.Pointer(&header))[:], buf[pos:])
+
+			item = searchItem{
+				transid:  uint64(header.transid),
+				objectid: uint64(header.objectid),
+				typ:      uint32(header._type),
+				offset:   uint64(header.offset),
+				data:     append([]byte(nil), buf[pos:pos+int(header.len)]...),
+			}
+			pos += int(header.len)
+
+			if err := fn(&item); err != nil {
+				return err
+			}
+		}
+
+		// Continue right after the last returned key.
+		switch {
+		case item.offset < math.MaxUint64:
+			sk.min_objectid = C.__u64(item.objectid)
+			sk.min_type = C.__u32(item.typ)
+			sk.min_offset = C.__u64(item.offset + 1)
+		case item.typ < math.MaxUint8:
+			sk.min_objectid = C.__u64(item.objectid)
+			sk.min_type = C.__u32(item.typ + 1)
+			sk.min_offset = 0
+		case item.objectid < math.MaxUint64:
+			sk.min_objectid = C.__u64(item.objectid + 1)
+			sk.min_type = 0
+			sk.min_offset = 0
+		default:
+			return nil
+		}
+		if sk.min_objectid > sk.max_objectid {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func nodePaths(nodes []*TreeNode) []string {
+	paths := []string{}
+	for _, node := range nodes {
+		paths = append(paths, node.Path)
+	}
+	return paths
+}
+
+func TestNewTree(t *testing.T) {
+	uuid := func(i byte) UUID {
+		return UUID{15: i}
+	}
+	nilUUID := uuid(0)
+
+	nodes := []*TreeNode{
+		{Path: "", Info: &SubvolumeInfo{Id: 5, UUID: nilUUID, ParentUUID: nilUUID, ReceivedUUID: nilUUID}},
+		{Path: "home", Info: &SubvolumeInfo{Id: 256, ParentId: 5, UUID: uuid(1), ParentUUID: nilUUID, ReceivedUUID: nilUUID}},
+		{Path: "home/user", Info: &SubvolumeInfo{Id: 257, ParentId: 256, UUID: uuid(2), ParentUUID: nilUUID, ReceivedUUID: nilUUID}},
+		{Path: "snapshots/1", Info: &SubvolumeInfo{Id: 258, ParentId: 5, UUID: uuid(3), ParentUUID: uuid(1), ReceivedUUID: nilUUID}},
+		{Path: "snapshots/2", Info: &SubvolumeInfo{Id: 259, ParentId: 5, UUID: uuid(4), ParentUUID: uuid(1), ReceivedUUID: nilUUID}},
+		{Path: "backup/1", Info: &SubvolumeInfo{Id: 260, ParentId: 5, UUID: uuid(5), ParentUUID: nilUUID, ReceivedUUID: uuid(3)}},
+		{Path: "backup/2", Info: &SubvolumeInfo{Id: 261, ParentId: 5, UUID: uuid(6), ParentUUID: uuid(5), ReceivedUUID: uuid(4)}},
+	}
+	tree := newTree(nodes)
+
+	home := tree.NodeByPath("home")
+	if home == nil || tree.NodeById(256) != home || tree.NodeByUUID(uuid(1)) != home {
+		t.Fatalf("lookup of home failed")
+	}
+	if tree.NodeByPath("/") != tree.Root || tree.NodeById(5) != tree.Root {
+		t.Errorf("lookup of root failed")
+	}
+
+	tests := []struct {
+		name string
+		got  []*TreeNode
+		want []string
+	}{
+		{"root children", tree.Root.Children(), []string{"backup/1", "backup/2", "home", "snapshots/1", "snapshots/2"}},
+		{"home children", home.Children(), []string{"home/user"}},
+		{"home snapshots", home.Snapshots(), []string{"snapshots/1", "snapshots/2"}},
+		{"snapshot received", tree.NodeByPath("snapshots/1").Received(), []string{"backup/1"}},
+		{"home descendants", home.Descendants(), []string{"snapshots/1", "backup/1", "backup/2", "snapshots/2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodePaths(tt.got); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := tree.NodeByPath("home/user").Parent(); got != home {
+		t.Errorf("TreeNode.Parent() = %v, want home", got)
+	}
+	if got := tree.NodeByPath("backup/2").Origin(); got != tree.NodeByPath("backup/1") {
+		t.Errorf("TreeNode.Origin() = %v, want backup/1", got)
+	}
+	if got := tree.NodeByPath("backup/2").ReceivedFrom(); got != tree.NodeByPath("snapshots/2") {
+		t.Errorf("TreeNode.ReceivedFrom() = %v, want snapshots/2", got)
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1/subvol2")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	if CreateSnapshot(filepath.Join(mountpoint.path, "subvol1"), filepath.Join(mountpoint.path, "snap1"), false, true) != nil {
+		t.Error("Failed to create snapshots")
+	}
+
+	tree, err := BuildTree(mountpoint.path)
+	if err != nil {
+		t.Fatalf("BuildTree() error = %v", err)
+	}
+
+	if got := nodePaths(tree.Root.Children()); !reflect.DeepEqual(got, []string{"snap1", "subvol1"}) {
+		t.Errorf("Tree.Root.Children() = %v", got)
+	}
+	subvol1 := tree.NodeById(256)
+	if subvol1 == nil || subvol1.Path != "subvol1" {
+		t.Fatalf("Tree.NodeById(256) = %v", subvol1)
+	}
+	if got := nodePaths(subvol1.Children()); !reflect.DeepEqual(got, []string{"subvol1/subvol2"}) {
+		t.Errorf("TreeNode.Children() = %v", got)
+	}
+	if got := nodePaths(subvol1.Snapshots()); !reflect.DeepEqual(got, []string{"snap1"}) {
+		t.Errorf("TreeNode.Snapshots() = %v", got)
+	}
+	if got := tree.NodeByPath("snap1").Origin(); got != subvol1 {
+		t.Errorf("TreeNode.Origin() = %v, want subvol1", got)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// UUID is a universally unique identifier as used for Btrfs subvolumes.
+// The zero value represents an absent UUID.
+type UUID [16]byte
+
+// ParseUUID parses a UUID in its canonical form, e.g. "01234567-89ab-cdef-0123-456789abcdef".
+// The hyphens may be omitted.
+func ParseUUID(s string) (UUID, error) {
+	var uuid UUID
+
+	switch len(s) {
+	case 36:
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return uuid, ErrInvalidArgument
+		}
+		s = s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	case 32:
+	default:
+		return uuid, ErrInvalidArgument
+	}
+
+	if _, err := hex.Decode(uuid[:], []byte(s)); err != nil {
+		return UUID{}, ErrInvalidArgument
+	}
+	return uuid, nil
+}
+
+// String returns the canonical form of the UUID.
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// IsZero returns whether the UUID is absent, i.e. all zero.
+func (u UUID) IsZero() bool {
+	return u == UUID{}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (u *UUID) UnmarshalText(text []byte) error {
+	uuid, err := ParseUUID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = uuid
+	return nil
+}
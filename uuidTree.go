@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+// #include <linux/btrfs_tree.h>
+import "C"
+import (
+	"encoding/binary"
+	"os"
+)
+
+// uuidTreeLookup returns the subvolume IDs stored in the UUID tree for a UUID and key type.
+func uuidTreeLookup(fd uintptr, uuid UUID, keyType uint32) ([]uint64, error) {
+	// The UUID is split into two little-endian halves forming objectid and offset of the key.
+	objectid := binary.LittleEndian.Uint64(uuid[:8])
+	offset := binary.LittleEndian.Uint64(uuid[8:])
+
+	key := searchKey{
+		treeId:      C.BTRFS_UUID_TREE_OBJECTID,
+		minObjectid: objectid,
+		maxObjectid: objectid,
+		minType:     keyType,
+		maxType:     keyType,
+		minOffset:   offset,
+		maxOffset:   offset,
+	}
+
+	var ids []uint64
+	err := treeSearch(fd, key, func(item *searchItem) error {
+		if item.objectid != objectid || item.typ != keyType || item.offset != offset {
+			return nil
+		}
+		for i := 0; i+8 <= len(item.data); i += 8 {
+			ids = append(ids, binary.LittleEndian.Uint64(item.data[i:]))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func findSubvolumesByUUID(fd uintptr, uuid UUID, keyType uint32) ([]*SubvolumeInfoIteratorResult, error) {
+	ids, err := uuidTreeLookup(fd, uuid, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*SubvolumeInfoIteratorResult
+	for _, id := range ids {
+		info, err := GetSubvolumeInfoFd(fd, id)
+		if err == ErrSubvolumeNotFound {
+			// The UUID tree may still refer to a deleted subvolume.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		path, err := SubvolumePathFd(fd, id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &SubvolumeInfoIteratorResult{path, info})
+	}
+	return results, nil
+}
+
+// FindSubvolumeByUUID returns the path and information of the subvolume with a given UUID,
+// looked up in the UUID tree of the filesystem containing path.
+// The path of the result is relative to the top-level subvolume.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func FindSubvolumeByUUID(path string, uuid UUID) (*SubvolumeInfoIteratorResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return FindSubvolumeByUUIDFd(file.Fd(), uuid)
+}
+
+// See FindSubvolumeByUUID.
+func FindSubvolumeByUUIDFd(fd uintptr, uuid UUID) (*SubvolumeInfoIteratorResult, error) {
+	results, err := findSubvolumesByUUID(fd, uuid, C.BTRFS_UUID_KEY_SUBVOL)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrSubvolumeNotFound
+	}
+	return results[0], nil
+}
+
+// FindSubvolumesByReceivedUUID returns the paths and information of all subvolumes
+// which were received from the subvolume with a given UUID, looked up in the UUID tree
+// of the filesystem containing path.
+// Paths are relative to the top-level subvolume.
+// Appropriate privileges are required (CAP_SYS_ADMIN).
+func FindSubvolumesByReceivedUUID(path string, uuid UUID) ([]*SubvolumeInfoIteratorResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+	defer file.Close()
+
+	return FindSubvolumesByReceivedUUIDFd(file.Fd(), uuid)
+}
+
+// See FindSubvolumesByReceivedUUID.
+func FindSubvolumesByReceivedUUIDFd(fd uintptr, uuid UUID) ([]*SubvolumeInfoIteratorResult, error) {
+	return findSubvolumesByUUID(fd, uuid, C.BTRFS_UUID_KEY_RECEIVED_SUBVOL)
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFindSubvolumeByUUID(t *testing.T) {
+	if !hasPrivileges() {
+		t.Skipf("must be run as root")
+	}
+
+	mountpoint, err := mountBtrfs()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer cleanup(mountpoint)
+
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+	if CreateSubvolume(filepath.Join(mountpoint.path, "subvol1/subvol2")) != nil {
+		t.Error("Failed to create subvolumes")
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"subvol1", "subvol1", false},
+		{"subvol2", "subvol1/subvol2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := GetSubvolumeInfo(filepath.Join(mountpoint.path, tt.path), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := FindSubvolumeByUUID(mountpoint.path, want.UUID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FindSubvolumeByUUID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.Path != tt.path || got.Info.Id != want.Id {
+				t.Errorf("FindSubvolumeByUUID() = %v %d, want %v %d", got.Path, got.Info.Id, tt.path, want.Id)
+			}
+		})
+	}
+
+	unknown := UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	if _, err := FindSubvolumeByUUID(mountpoint.path, unknown); err != ErrSubvolumeNotFound {
+		t.Errorf("FindSubvolumeByUUID() error = %v, want %v", err, ErrSubvolumeNotFound)
+	}
+
+	got, err := FindSubvolumesByReceivedUUID(mountpoint.path, unknown)
+	if err != nil || len(got) != 0 {
+		t.Errorf("FindSubvolumesByReceivedUUID() = %v, %v, want none", got, err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2022 Jana Marlou Rettig
+ *
+ * This file is part of libbtrfsutil-go.
+ *
+ * libbtrfsutil-go is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * libbtrfsutil-go is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with libbtrfsutil-go.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package btrfsutil
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseUUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    UUID
+		wantErr bool
+	}{
+		{"canonical", "01234567-89ab-cdef-0123-456789abcdef", UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, false},
+		{"upper case", "01234567-89AB-CDEF-0123-456789ABCDEF", UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, false},
+		{"no hyphens", "0123456789abcdef0123456789abcdef", UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, false},
+		{"zero", "00000000-0000-0000-0000-000000000000", UUID{}, false},
+		{"misplaced hyphen", "0123456-789ab-cdef-0123-456789abcdef", UUID{}, true},
+		{"not hex", "0123456g-89ab-cdef-0123-456789abcdef", UUID{}, true},
+		{"short", "01234567-89ab-cdef-0123-456789abcde", UUID{}, true},
+		{"empty", "", UUID{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUUID(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseUUID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseUUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUUID(t *testing.T) {
+	uuid := UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+	if got, want := uuid.String(), "01234567-89ab-cdef-0123-456789abcdef"; got != want {
+		t.Errorf("UUID.String() = %v, want %v", got, want)
+	}
+	if uuid.IsZero() || !(UUID{}).IsZero() {
+		t.Errorf("UUID.IsZero() failed")
+	}
+
+	info := SubvolumeInfo{UUID: uuid}
+	if got := info.UUIDString(); got != uuid.String() {
+		t.Errorf("SubvolumeInfo.UUIDString() = %v, want %v", got, uuid.String())
+	}
+	if got, want := info.ParentUUIDString(), "00000000-0000-0000-0000-000000000000"; got != want {
+		t.Errorf("SubvolumeInfo.ParentUUIDString() = %v, want %v", got, want)
+	}
+
+	data, err := json.Marshal(&info)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var got SubvolumeInfo
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got.UUID != uuid || !got.ParentUUID.IsZero() {
+		t.Errorf("json round trip = %v, want %v", got.UUID, uuid)
+	}
+}